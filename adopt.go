@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// imageConfig - return the dimensions and format of the image file at path
+// without decoding its pixels
+func imageConfig(path string) (image.Config, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer f.Close()
+	return image.DecodeConfig(f)
+}
+
+// legacyMismatch - return why dstname, written by an older version or another tool,
+// does not look like the output that srcname would be processed into, or an empty
+// string if it does: it must be in the format its name calls for, have the size
+// srcname would be resized to and, when software is given, have an EXIF Software
+// tag matching that case-insensitive pattern
+func legacyMismatch(opts *options, dstname, srcname, software string) string {
+	src, _, err := imageConfig(srcname)
+	if err != nil {
+		return fmt.Sprintf("source can not be read: %v", err)
+	}
+	dst, format, err := imageConfig(dstname)
+	if err != nil {
+		return fmt.Sprintf("destination can not be read: %v", err)
+	}
+	if format != resizer.FormatFromExt(dstname) {
+		return fmt.Sprintf("destination holds %s data", format)
+	}
+	if !hasTargetSize(opts, src.Width, src.Height, dst.Width, dst.Height) {
+		return fmt.Sprintf("destination is %dx%d, not the size the %dx%d source is resized to", dst.Width, dst.Height, src.Width, src.Height)
+	}
+	if len(software) > 0 {
+		value := exifText(dstname, exifTagSoftware)[exifTagSoftware]
+		if matched, _ := path.Match(strings.ToLower(software), strings.ToLower(value)); !matched {
+			return fmt.Sprintf("destination's EXIF Software is %q", value)
+		}
+	}
+	return ""
+}
+
+// runAdopt - the adopt subcommand, record the destination files that older versions
+// or other tools wrote as processed in the ledger, with the current settings, when
+// they look like what a batch run would write, so that --skip-compliant and the
+// other incremental features do not process their sources again.  Outputs that the
+// ledger already has are left alone.  Return 1 if any output does not match.
+func runAdopt(opts *options, software string) int {
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+	conflicts := newConflictResolver(opts.conflictStrategy)
+
+	adopted, known, missing, mismatched := 0, 0, 0, 0
+	for srcname := range paths {
+		fileOpts, err := opts.forFile(srcname)
+		if err != nil {
+			log.Printf("Unable to apply profile to %s: %v\n", srcname, err)
+			continue
+		}
+		dstname, release, ok := conflicts.resolve(destName(fileOpts, srcname), srcname)
+		release()
+		if !ok {
+			continue
+		}
+		if _, ok := opts.ledger.params[filepath.Clean(dstname)]; ok {
+			known++
+			continue
+		}
+		if !fileExists(dstname) {
+			missing++
+			continue
+		}
+		if why := legacyMismatch(fileOpts, dstname, srcname, software); len(why) > 0 {
+			mismatched++
+			fmt.Printf("not adopted: %s: %s\n", dstname, why)
+			continue
+		}
+		hash := ""
+		if opts.followRenames {
+			hash, _ = hashFile(opts.hashAlgorithm, srcname)
+		}
+		e := ledgerEntry{Event: "processed", Source: srcname, Path: dstname, Params: fileOpts.params(),
+			Note: "adopted from an earlier run"}.withSourceHash(hash)
+		if err := opts.ledger.record(e); err != nil {
+			log.Fatalf("Unable to write to ledger: %v\n", err)
+		}
+		adopted++
+		fmt.Printf("adopted: %s\n", dstname)
+	}
+	if err := <-errc; err != nil {
+		log.Printf("Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+	fmt.Printf("adopt: %d outputs adopted, %d not matching, %d missing, %d already in the ledger\n", adopted, mismatched, missing, known)
+	if mismatched > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// thumbCache - a directory of the resized photos served by /photo, from which the
+// least recently used are removed once they take up more than max bytes; a nil
+// thumbCache keeps nothing
+type thumbCache struct {
+	mu    sync.Mutex
+	dir   string
+	max   int64
+	size  int64
+	order *list.List               // of *cachedThumb, the most recently used first
+	items map[string]*list.Element // by file name
+}
+
+// cachedThumb - a file in the cache
+type cachedThumb struct {
+	name string
+	size int64
+}
+
+// openThumbCache - return the cache in dir, creating dir if needed; the files
+// already in it are kept, most recently used by their modification time
+func openThumbCache(dir string, max int64, dirMode os.FileMode) (*thumbCache, error) {
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+	c := &thumbCache{dir: dir, max: max, order: list.New(), items: make(map[string]*list.Element)}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || filepath.Ext(info.Name()) != ".jpg" {
+			continue
+		}
+		c.items[info.Name()] = c.order.PushBack(&cachedThumb{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+	return c, nil
+}
+
+// thumbKey - return the cache file name of source, last modified at modTime,
+// resized to size with the settings params
+func thumbKey(source string, modTime time.Time, size, params string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s\n%s", source, modTime.UnixNano(), size, params)))
+	return hex.EncodeToString(sum[:16]) + ".jpg"
+}
+
+// get - return the cached file called key, if there is one
+func (c *thumbCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	path := filepath.Join(c.dir, key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	// keep the order for the next start of the server
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// put - keep data as the file called key and remove the least recently used files
+// for as long as the cache is too large
+func (c *thumbCache) put(key string, data []byte, mode os.FileMode) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := filepath.Join(c.dir, key)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if e, ok := c.items[key]; ok {
+		c.size -= e.Value.(*cachedThumb).size
+		c.order.Remove(e)
+	}
+	c.items[key] = c.order.PushFront(&cachedThumb{name: key, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// remove - forget e and delete its file; c.mu must be held
+func (c *thumbCache) remove(e *list.Element) {
+	t := e.Value.(*cachedThumb)
+	c.order.Remove(e)
+	delete(c.items, t.name)
+	c.size -= t.size
+	os.Remove(filepath.Join(c.dir, t.name))
+}
+
+// evict - remove the least recently used files until the cache fits within max;
+// c.mu must be held
+func (c *thumbCache) evict() {
+	for c.size > c.max && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
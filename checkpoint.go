@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointName - the --checkpoint of a batch run when none is given, in the
+// destination directory
+const checkpointName = ".photo_id_resizer.checkpoint"
+
+// checkpointEntry - one line of a --checkpoint, a source whose outputs were all
+// written or skipped without an error
+type checkpointEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+// checkpoint - an append-only JSON lines journal of the sources a batch run has
+// completed, so that a run that is killed part of the way through can be
+// continued with --resume; it is removed once a batch completes without
+// errors, and a nil checkpoint records nothing
+type checkpoint struct {
+	mu        sync.Mutex
+	path      string
+	f         *os.File
+	enc       *json.Encoder
+	completed map[string]bool // sources completed by the run being resumed
+}
+
+// checkpointPath - return the --checkpoint given, or the default one in dest
+func checkpointPath(given, dest string) string {
+	if len(given) > 0 {
+		return given
+	}
+	return filepath.Join(dest, checkpointName)
+}
+
+// openCheckpoint - start the journal at path; with resume, the sources it
+// lists are kept and skipped, otherwise an earlier journal is discarded
+func openCheckpoint(path string, mode, dirMode os.FileMode, resume bool) (*checkpoint, error) {
+	c := &checkpoint{path: path, completed: make(map[string]bool)}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			logs.warn(logEntry{Action: "resume", File: path}, "Nothing to resume, no checkpoint found at %s\n", path)
+		} else if err != nil {
+			return nil, err
+		} else {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(nil, 1024*1024)
+			for scanner.Scan() {
+				var e checkpointEntry
+				// the last line is incomplete when the run was killed while writing it
+				if json.Unmarshal(scanner.Bytes(), &e) == nil && len(e.Source) > 0 {
+					c.completed[e.Source] = true
+				}
+			}
+			err = scanner.Err()
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			logs.info(logEntry{Action: "resume", File: path}, "Resuming, %d sources were completed before\n", len(c.completed))
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else if _, err := os.Stat(path); err == nil {
+		logs.info(logEntry{Action: "resume", File: path}, "Starting over, give --resume to continue the interrupted run of %s instead\n", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+	c.f, c.enc = f, json.NewEncoder(f)
+	return c, nil
+}
+
+// done - return true if the run being resumed completed source
+func (c *checkpoint) done(source string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[source]
+}
+
+// record - add source to the journal once all its outputs were completed
+func (c *checkpoint) record(source string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(checkpointEntry{Time: clock(), Source: source}); err != nil {
+		logs.warn(logEntry{Action: "checkpoint"}.withErr(err), "Unable to write to the checkpoint: %v\n", err)
+	}
+}
+
+// close - close the journal, removing it when the run completed so that the
+// next run starts over
+func (c *checkpoint) close(completed bool) {
+	if c == nil {
+		return
+	}
+	c.f.Close()
+	if completed {
+		os.Remove(c.path)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumsName - the manifest written by --checksums, in the format of sha256sum
+const checksumsName = "SHA256SUMS"
+
+// sha256File - return the hex SHA-256 of the file at path
+func sha256File(path string) (string, error) {
+	return hashFile("sha256", path)
+}
+
+// writeChecksums - write a SHA256SUMS file in dest listing files, which are in dest,
+// so that the delivered batch can be checked with sha256sum -c; return its path
+func writeChecksums(dest string, files []string, mode os.FileMode) (string, error) {
+	type sum struct{ hash, name string }
+	sums := make([]sum, 0, len(files))
+	for _, file := range files {
+		hash, err := sha256File(file)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(dest, file)
+		if err != nil {
+			rel = filepath.Base(file)
+		}
+		sums = append(sums, sum{hash, filepath.ToSlash(rel)})
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].name < sums[j].name })
+
+	var b strings.Builder
+	for _, s := range sums {
+		fmt.Fprintf(&b, "%s  %s\n", s.hash, s.name)
+	}
+	path := filepath.Join(dest, checksumsName)
+	if err := ioutil.WriteFile(path, []byte(b.String()), mode); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// readChecksums - return the hashes in a file in the format of sha256sum, keyed by
+// path; relative paths are relative to the directory of the file
+func readChecksums(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: expected a SHA-256 and a file name", path, i+1)
+		}
+		// sha256sum marks files read in binary mode with a *
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		sums[filepath.Clean(name)] = strings.ToLower(fields[0])
+	}
+	return sums, nil
+}
+
+// pinnedFiles - the files of a pins file, all of which have their pinned hash
+type pinnedFiles struct {
+	pins  string
+	files map[string]bool // by absolute name
+}
+
+// checkPinned - return the files listed in the pins file, or an error unless every
+// one of them has the hash listed for it
+func checkPinned(pins string) (*pinnedFiles, error) {
+	sums, err := readChecksums(pins)
+	if err != nil {
+		return nil, err
+	}
+	pf := &pinnedFiles{pins: pins, files: make(map[string]bool)}
+	for name, want := range sums {
+		got, err := sha256File(name)
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			return nil, fmt.Errorf("%s has SHA-256 %s, not the pinned %s", name, got, want)
+		}
+		if abs, err := filepath.Abs(name); err == nil {
+			pf.files[abs] = true
+		}
+	}
+	return pf, nil
+}
+
+// require - return an error unless file, which is described by what, is pinned
+func (pf *pinnedFiles) require(what, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if !pf.files[abs] {
+		return fmt.Errorf("the %s %s is not pinned in %s", what, file, pf.pins)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// testEpoch - the time the clock of --test-mode starts at unless --test-clock is given
+const testEpoch = "2000-01-01T00:00:00Z"
+
+// testRunID - the run ID of --test-mode, in --trash directories and output tags
+const testRunID = "test"
+
+// testSeed - seeds the random choices of --test-mode, such as the --verify-copies sample
+const testSeed = 1
+
+// clock - return the time of day as seen by --max-age, retention, active hours,
+// the ledger, logs and capture names; --test-mode replaces it. Durations, leases,
+// locks and upload checks keep using the real time
+var clock = time.Now
+
+// random - the random choices of a run, seeded from the time unless in --test-mode
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomFloat - return a random number in [0.0,1.0), safe to call from every worker
+func randomFloat() float64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.Float64()
+}
+
+// enableTestMode - the --test-mode, so that a run can be repeated with the same
+// results by integration tests: the clock starts at start and runs at real speed,
+// the run ID is fixed and the random choices are seeded
+func enableTestMode(start time.Time) {
+	began := time.Now()
+	clock = func() time.Time { return start.Add(time.Since(began)) }
+	runID = testRunID
+	random.Lock()
+	random.Rand = rand.New(rand.NewSource(testSeed))
+	random.Unlock()
+}
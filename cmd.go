@@ -9,10 +9,12 @@ Resize photo ID images using face recognition technology.
 package main
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 	"log"
 	"os"
@@ -20,32 +22,124 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
 )
 
 type result struct {
 	path string
 	err  error
+	dest string     // the destination file of path that this run wrote or found compliant, if any
+	skip reason     // that the output was skipped for, if it was
+	row  *reportRow // of the --report, nil for none
+}
+
+// options - settings shared by the directory walker and all of the workers
+type options struct {
+	source           string
+	dest             string
+	onlyUnder        subtrees // the parts of the source tree that are processed, empty for all of it
+	nestedDest       string   // the destination relative to the source when it is inside of it, which is not walked
+	match            string
+	exclude          string
+	numWorkers       int
+	gracePeriod      time.Duration  // that files being processed are given to finish after SIGINT or SIGTERM
+	maxAge           time.Duration  // skip files last modified longer ago, 0 for none
+	shard            shardSpec      // the part of the discovered files handled by this run
+	settle           time.Duration  // how long a file must be unchanged before it is processed
+	verifyCopies     float64        // percentage of pass-through copies whose hash is compared to the source
+	tmpDir           string         // where intermediate files go, empty for the system's temporary directory
+	cacheDir         string         // directory the serve subcommand keeps resized photos of /photo in, empty for none
+	cacheMB          int            // largest size of the cacheDir in megabytes
+	scanner          *scanner       // scans sources before they are decoded, nil for none
+	remote           *remoteSync    // downloads sources from and uploads outputs to bucket and server URLs of -s and -d, nil when both are local
+	summaryFile      string         // file the JSON summary of a batch run is written to, empty for none
+	report           string         // .json or .csv file the outcome of every output is written to, empty for none
+	metrics          *metrics       // exposed on /metrics by the serve subcommand, nil unless --metrics is given
+	summaryFD        int            // file descriptor the JSON summary is written to, 0 for none
+	summaryTo        *runSummary    // filled in with the summary of a batch run, for the jobs of the serve subcommand; nil for nothing
+	telemetry        *telemetry     // anonymous performance counters, nil unless --telemetry is given
+	stats            *runStats      // throughput of the copies and resizes of the run
+	activeHours      activeHours    // when files are processed, discovery continues outside of them
+	skipCompliant    bool           // leave destination files alone that already have the target size and format
+	missingOnly      bool           // only process sources that have no destination file at all
+	newerOnly        bool           // only process sources that are newer than their destination file
+	hashCache        *hashCache     // the source contents and settings processed by earlier runs, nil for none
+	checkpoint       *checkpoint    // the sources completed by this batch run, and by the one it resumes
+	engine           string         // how images are resized, see engines
+	scaler           string         // how images are scaled, see scalers
+	aspectTolerance  float64        // percent that an aspect ratio may differ by for the smart engine to only scale
+	cropMargin       float64        // percent of the face's size that face-crop keeps around it
+	marginSet        bool           // --margin was given, so presets do not frame the head
+	headMin, headMax float64        // range of the head's height in percent of the photo's, 0 for no rule
+	aspect           resizer.Aspect // the aspect ratio every output is cropped or padded to, if given
+	square           bool           // crop every output to a square around the face
+	sizes            []sizeStep     // every output size of --sizes, each in its own subdirectory, none for only size
+	seams            string         // the seams that may be carved, see seamDirections
+	maxCarve         float64        // largest percent of an image that is carved away, 0 for no limit
+	size             resizer.Size
+	format           string        // output format, empty to keep the format of each source
+	dpi              int           // pixel density stamped into JPEG output, 0 for none
+	quality          int           // JPEG and WebP quality of resized and converted outputs, 1 to 100
+	maxPixels        int           // largest image that is decoded, 0 for no limit
+	background       color.Color   // color that transparency is flattened onto, nil for none
+	alpha            resizer.Alpha // what happens to transparency in JPEG outputs without a background
+	fileMode         os.FileMode
+	dirMode          os.FileMode
+	tagOutputs       bool               // record the run and settings in an extended attribute of each output
+	lockOutputs      bool               // take a lock file on each output while writing it, for instances sharing a destination
+	fixExtensions    bool               // name outputs after the format of their content rather than the source's extension
+	stripMetadata    bool               // remove EXIF, GPS, IPTC and XMP data from copied outputs too
+	keepMetadata     bool               // copy the EXIF and XMP data of JPEG sources into resized JPEG outputs
+	followRenames    bool               // move the outputs of renamed sources, found in the ledger by their hash
+	hashAlgorithm    string             // how sources and copies are hashed, see hashAlgorithms
+	preserveColor    bool               // keep 16-bit, CMYK and paletted sources as they are instead of writing 8-bit RGB
+	windowsNames     string             // what happens to names Windows does not allow, see windowsNameModes
+	layout           string             // where outputs go in the destination, see layouts
+	conflictStrategy string             // how sources that share a destination name are handled
+	golden           string             // directory of approved outputs to compare new outputs to
+	goldenDistance   int                // most perceptual hash bits an output may differ from its golden file by
+	roster           *roster            // employee IDs expected to have a photo, nil for none
+	rosterReport     string             // CSV file listing every roster discrepancy
+	snapshot         *snapshot          // the source files at the start of a batch run, nil to not check for changes
+	notifier         *notifier          // told when the photo of a priority roster entry is written, nil for nobody
+	ledger           *ledger            // where what happens to every photo is recorded, nil for nowhere
+	encryptor        *encryptor         // encrypts every output, nil to write them as they are
+	token            string             // shared token of --token-file, empty for none
+	passphraseFile   string             // of --encrypt, for the encryptor of each destination; empty for none
+	trash            *trash             // keeps the destination files that outputs replace, nil to overwrite them
+	trashOutputs     bool               // give each destination a trash, see --trash
+	trashDays        int                // that the trash keeps the files of a run
+	checksums        bool               // write a SHA256SUMS file of the outputs at the end of a run
+	signingKey       ed25519.PrivateKey // signs the manifests and reports written, nil for none
+	lang             string             // language of user-facing messages
+	messages         catalog            // user-facing messages for reason codes
+	profiles         *profileCache      // per-directory overrides of the settings above, nil for none
+	routes           []route            // metadata rules that override settings and the destination after profiles
+	manifest         *inputManifest     // the files to process and their output names and settings instead of walking the source, nil for none
+	manifestName     string             // name of the output under the destination from its --manifest entry, empty to name it after the source
+	nameTemplate     string             // how outputs are named, see nameVariables; empty to name them after their sources
 }
 
 const pgmName = "photo_id_resizer"
 const pgmUrl = "https://github.com/jftuga/photo_id_resizer"
-const pgmVersion = "1.2.0"
+const pgmVersion = "1.3.0"
 const equalsLine = "=============================================================="
 
-// copy - copy a src file to a dst directory
-func copy(src, dst string) (int64, error) {
+// copy - copy a src file to a dst directory, creating dst with the given mode
+func copy(src, dst string, mode os.FileMode) (int64, error) {
 	source, err := os.Open(src)
 	if err != nil {
 		return 0, err
 	}
 	defer source.Close()
 
-	destination, err := os.Create(dst)
+	destination, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
 		return 0, err
 	}
@@ -54,97 +148,264 @@ func copy(src, dst string) (int64, error) {
 	return nBytes, err
 }
 
-// needsResizing - return true if source image has height greater than maxHeight
-// or image has width greater than maxWidth
-func needsResizing(path string, maxHeight, maxWidth int) bool {
-	if reader, err := os.Open(path); err == nil {
-		defer reader.Close()
-		im, _, err := image.DecodeConfig(reader)
-		if err != nil {
-			log.Printf("needsResizing(): %s: %v\n", path, err)
-			return false
-		}
-		if im.Height > maxHeight+1 {
-			return true
-		}
-		if im.Width > maxWidth+1 {
-			return true
-		}
+// isBackfilled - return true if --missing-only is given and the output written for
+// dstname already exists, whatever its size, age or settings
+func isBackfilled(opts *options, dstname string) bool {
+	return opts.missingOnly && fileExists(outputName(opts, dstname))
+}
+
+// isUpToDate - return true if --newer-only is given and the output written for
+// dstname was modified at or after srcname, and the ledger does not show that it
+// was written with other settings
+func isUpToDate(opts *options, dstname, srcname string) bool {
+	if !opts.newerOnly {
+		return false
 	}
-	return false
+	dst, err := os.Stat(outputName(opts, dstname))
+	if err != nil {
+		return false
+	}
+	src, err := os.Stat(srcname)
+	if err != nil || dst.ModTime().Before(src.ModTime()) {
+		return false
+	}
+	if opts.ledger.paramsChanged(dstname, opts.params()) {
+		logs.info(logEntry{Action: "changed", File: srcname, Dest: dstname}, "    settings changed since %s was written, processing it again\n", dstname)
+		return false
+	}
+	return true
+}
+
+// isCompliant - return true if dstname already exists, decodes in full, is in the
+// format its name calls for and has the size that srcname would be resized to,
+// so that processing srcname again would not change it, and the ledger does not
+// show that it was written with other settings
+func isCompliant(opts *options, dstname, srcname string) bool {
+	if opts.ledger.paramsChanged(dstname, opts.params()) {
+		logs.info(logEntry{Action: "changed", File: srcname, Dest: dstname}, "    settings changed since %s was written, processing it again\n", dstname)
+		return false
+	}
+	src, err := os.Open(srcname)
+	if err != nil {
+		return false
+	}
+	cfg, _, err := image.DecodeConfig(src)
+	src.Close()
+	if err != nil {
+		return false
+	}
+	img, format, err := decodeImage(dstname, opts.maxPixels)
+	if err != nil || format != resizer.FormatFromExt(dstname) {
+		return false
+	}
+	return hasTargetSize(opts, cfg.Width, cfg.Height, img.Bounds().Dx(), img.Bounds().Dy())
+}
+
+// hasTargetSize - return true if w x h is the size that a srcW x srcH source is
+// resized to, or its own size when it needs no resizing
+func hasTargetSize(opts *options, srcW, srcH, w, h int) bool {
+	ew, eh := opts.imageResizer(nil).Target(srcW, srcH)
+	// allow for rounding of the proportionally scaled dimension
+	dw, dh := w-ew, h-eh
+	return dw >= -1 && dw <= 1 && dh >= -1 && dh <= 1
 }
 
-// isOlderThan - return true if the given time, t is older than maxAge days
-func isOlderThan(maxAge int, t time.Time) bool {
-	days := maxAge * -1
-	earlier := time.Now().AddDate(0, 0, days)
+// isOlderThan - return true if the given time, t is older than maxAge
+func isOlderThan(maxAge time.Duration, t time.Time) bool {
+	earlier := clock().Add(-maxAge)
 	return t.Before(earlier)
 }
 
-// process - examine a single srcname, resize if necessary
+// process - examine a single srcname, resize and convert if necessary
 // and then save or copy to dstname
-func process(p *caire.Processor, dstname, srcname string) error {
-	var src io.Reader
+func process(p *caire.Processor, opts *options, dstname, srcname string) error {
+	start := time.Now()
 	_, err := os.Stat(srcname)
 	if err != nil {
-		log.Fatalf("Unable to open source: %v", err)
+		err = applyPolicy("source", withReason(reasonStat, err))
+		logs.error(logEntry{Action: "stat", File: srcname}.withErr(err), "\nUnable to open source %s. Reason: %s\n", srcname, err.Error())
+		return err
 	}
-	if !needsResizing(srcname, p.NewHeight, p.NewWidth) {
-		copy(srcname, dstname)
-		return nil
+	if opts.windowsNames == "flag" {
+		if problem := windowsNameProblem(filepath.Base(dstname)); len(problem) > 0 {
+			err = withReason(reasonWindowsName, fmt.Errorf("%s can not be written on Windows: %s", dstname, problem))
+			logs.error(logEntry{Action: "check", File: srcname, Dest: dstname}.withErr(err), "\n%v\n", err)
+			return err
+		}
 	}
-
-	f, err := os.Open(srcname)
+	if opts.lockOutputs {
+		unlock, err := lockOutput(outputName(opts, dstname), opts.fileMode)
+		if err != nil {
+			logs.error(logEntry{Action: "lock", File: srcname, Dest: dstname}.withErr(err), "\nUnable to lock %s. Reason: %s\n", dstname, err.Error())
+			return err
+		}
+		defer unlock()
+	}
+	// the output format follows the extension of dstname, see destName()
+	format := resizer.FormatFromExt(dstname)
+	r := opts.imageResizer(p)
+	plan, err := r.Plan(srcname, format)
 	if err != nil {
-		log.Fatalf("Unable to open source file: %v", err)
+		logs.warn(logEntry{Action: "decode", File: srcname}.withErr(err), "Unable to read the size of %s: %v\n", srcname, err)
+	}
+	if plan.Copy() {
+		if err = copyOutput(opts, srcname, dstname); err != nil {
+			logs.error(logEntry{Action: "copy", File: srcname, Dest: dstname}.withErr(err), "\nError copying image %s. Reason: %s\n", srcname, err.Error())
+		} else {
+			logs.info(logEntry{Action: "copy", File: srcname, Dest: dstname}.since(start), "")
+		}
+		return err
+	}
+	if len(format) == 0 {
+		err = withReason(reasonUnsupported, errors.New("unsupported image format"))
+		logs.error(logEntry{Action: "convert", File: srcname, Dest: dstname}.withErr(err), "\nError rescaling image %s. Reason: %s\n", srcname, err.Error())
+		return fallbackCopy(opts, srcname, dstname, err)
 	}
-	defer f.Close()
-	src = f
 
-	var dst io.Writer
-	f, err = os.OpenFile(dstname, os.O_CREATE|os.O_WRONLY, 0755)
+	img, _, err := decodeImage(srcname, opts.maxPixels)
+	if reasonOf(err) == reasonTooLarge {
+		// copying the original would put the oversized image in the destination
+		logs.error(logEntry{Action: "decode", File: srcname}.withErr(err), "\nError decoding image %s. Reason: %s\n", srcname, err.Error())
+		return err
+	}
 	if err != nil {
-		log.Fatalf("Unable to open output file: %v", err)
+		err = withReason(reasonDecode, err)
+		logs.error(logEntry{Action: "decode", File: srcname}.withErr(err), "\nError decoding image %s. Reason: %s\n", srcname, err.Error())
+		if !plan.Convert {
+			return fallbackCopy(opts, srcname, dstname, err)
+		}
+		return applyPolicy("decode", err)
+	}
+	oldWidth, oldHeight := img.Bounds().Dx(), img.Bounds().Dy()
+	opts.stats.recordIO(fileSize(srcname), 0)
+
+	var resizeErr error
+	if plan.Resize {
+		var resized image.Image
+		resized, resizeErr = r.ResizeWithEngine(img, plan.Width, plan.Height)
+		if resizeErr == nil {
+			img = resized
+		} else {
+			resizeErr = withReason(reasonResize, resizeErr)
+			logs.error(logEntry{Action: "resize", File: srcname, Dest: dstname}.withErr(resizeErr), "\nError rescaling image %s. Reason: %s\n", srcname, resizeErr.Error())
+			if !plan.Convert && !plan.Normalize && !plan.Stamp {
+				return fallbackCopy(opts, srcname, dstname, resizeErr)
+			}
+		}
+	}
+	if img, err = r.Finish(img, format); err != nil {
+		err = withReason(reasonTransparent, err)
+		logs.error(logEntry{Action: "encode", File: srcname, Dest: dstname}.withErr(err), "\nError encoding image %s. Reason: %s\n", dstname, err.Error())
+		return err
 	}
-	defer f.Close()
-	dst = f
 
-	err = p.Process(src, dst)
-	if err == nil {
-		fmt.Printf("file resized to: %s \n", path.Base(dstname))
-		fmt.Println(equalsLine)
+	f, err := createOutput(opts, dstname)
+	if err != nil {
+		err = applyPolicy("output", withReason(reasonWrite, err))
+		logs.error(logEntry{Action: "write", File: srcname, Dest: dstname}.withErr(err), "\nUnable to open output file %s. Reason: %s\n", dstname, err.Error())
+		return err
+	}
+	if opts.keepMetadata && format == "jpeg" {
+		err = encodeWithMetadata(f, img, opts.dpi, opts.quality, srcname)
+	} else {
+		err = resizer.Encode(f, img, format, opts.dpi, opts.quality)
+	}
+	if err != nil {
+		f.Close()
+		err = withReason(reasonEncode, err)
+		logs.error(logEntry{Action: "encode", File: srcname, Dest: dstname}.withErr(err), "\nError encoding image %s. Reason: %s\n", dstname, err.Error())
+		return err
+	}
+	// encrypted outputs are only written when closed
+	if err = f.Close(); err != nil {
+		err = withReason(reasonWrite, err)
+		logs.error(logEntry{Action: "write", File: srcname, Dest: dstname}.withErr(err), "\nError writing image %s. Reason: %s\n", dstname, err.Error())
+		return err
+	}
+	opts.stats.recordResize(time.Since(start))
+	opts.stats.recordIO(0, fileSize(outputName(opts, dstname)))
+	opts.telemetry.recordResize(oldWidth*oldHeight, time.Since(start))
+	entry := logEntry{Action: "convert", File: srcname, Dest: dstname, OldWidth: oldWidth, OldHeight: oldHeight,
+		NewWidth: img.Bounds().Dx(), NewHeight: img.Bounds().Dy()}.since(start)
+	if plan.Resize && resizeErr == nil {
+		entry.Action = "resize"
+		logs.info(entry, "file resized to: %s \n%s\n", path.Base(dstname), equalsLine)
 	} else {
-		log.Printf("\nError rescaling image %s. Reason: %s\n", srcname, err.Error())
-		copy(srcname, dstname)
+		logs.info(entry, "")
 	}
 
-	return err
+	return resizeErr
 }
 
-// walkFiles starts a goroutine to walk the directory tree at source and send the
-// path of each regular file on the string channel.  It sends the result of the
-// walk on the error channel.  If done is closed, walkFiles abandons its work.
-func walkFiles(done <-chan struct{}, source string, match, exclude string, maxAge int) (<-chan string, <-chan error) {
-	paths := make(chan string)
-	errc := make(chan error, 1)
+// layouts - the values of --layout: flatten writes every output directly into the
+// destination, mirror recreates the directories of the source tree under it
+var layouts = []string{"flatten", "mirror"}
 
-	usingExclude := false
-	var excludeMatched *regexp.Regexp
-	var err error
+// validLayout - return true if layout is one of layouts
+func validLayout(layout string) bool {
+	for _, l := range layouts {
+		if l == layout {
+			return true
+		}
+	}
+	return false
+}
 
-	if len(exclude) > 0 {
-		usingExclude = true
-		excludeMatched, err = regexp.Compile(exclude)
-		if err != nil {
-			log.Fatalf("Invalid regular expression: %s\n", exclude)
+// destName - return the destination path for srcname, using the extension
+// of the output format when the image is being converted; the output name of
+// a --manifest entry chooses the format with its extension unless one is given,
+// and otherwise the --name-template replaces the name of the source
+func destName(opts *options, srcname string) string {
+	name := filepath.Join(opts.dest, filepath.Base(srcname))
+	if len(opts.manifestName) > 0 {
+		name = filepath.Join(opts.dest, opts.manifestName)
+	} else if opts.layout == "mirror" {
+		if rel, err := filepath.Rel(opts.source, srcname); err == nil {
+			name = filepath.Join(opts.dest, rel)
+		}
+	}
+	format := opts.format
+	if len(format) == 0 && len(opts.manifestName) > 0 {
+		format = resizer.FormatFromExt(name)
+	}
+	if len(format) == 0 {
+		format = sourceFormat(opts, srcname)
+	}
+	if len(opts.nameTemplate) > 0 && len(opts.manifestName) == 0 {
+		name = filepath.Join(filepath.Dir(name), opts.templateName(srcname, format))
+		// a template without {ext} gets the extension added rather than replacing
+		// whatever follows a dot in the name
+		if len(resizer.FormatFromExt(name)) == 0 {
+			name += resizer.Extension(format)
 		}
 	}
+	name = resizer.WithFormat(name, format)
+	if opts.windowsNames == "rename" {
+		name = filepath.Join(filepath.Dir(name), windowsSafeName(filepath.Base(name)))
+	}
+	return name
+}
 
-	var includeMatched *regexp.Regexp
-	includeMatched, err = regexp.Compile(match)
+// walkFiles starts a goroutine to walk the directory tree at opts.source and send the
+// path of each regular file on the string channel.  It sends the result of the
+// walk on the error channel.  If done is closed, walkFiles abandons its work.
+func walkFiles(done <-chan struct{}, opts *options, maxAge time.Duration) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	filter, err := newFileFilter(opts.match, opts.exclude, maxAge, opts.shard)
 	if err != nil {
-		log.Fatalf("Invalid regular expression: %s\n", match)
+		close(paths)
+		errc <- err
+		return paths, errc
+	}
+	filter.stats = opts.stats
+	if opts.manifest != nil {
+		return opts.manifest.walk(done)
+	}
+	if opts.remote.remoteSource() {
+		return opts.remote.walk(done, opts, filter)
 	}
+	source := opts.source
 
 	go func() {
 		// Close the paths channel after Walk returns.
@@ -154,30 +415,19 @@ func walkFiles(done <-chan struct{}, source string, match, exclude string, maxAg
 			if err != nil {
 				return err
 			}
-			fmt.Println("name: ", info.Name())
-			if usingExclude && excludeMatched.Match([]byte(info.Name())) {
-				fmt.Printf("    file excluded via reg expr : %v\n", exclude)
-				fmt.Println(equalsLine)
+			if info.Name() == profileName {
 				return nil
 			}
-			if !includeMatched.Match([]byte(info.Name())) {
-				fmt.Printf("    file didn't match : %v\n", match)
-				fmt.Println(equalsLine)
+			// whatever is outside of the --only-under subtrees, or is the destination, is not walked at all
+			if rel, err := filepath.Rel(source, path); err == nil && (!opts.onlyUnder.selects(rel, info) || rel == opts.nestedDest) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
-			if !info.Mode().IsRegular() {
-				fmt.Println("    file is not regular")
-				fmt.Println(equalsLine)
+			if !filter.accepts(path, info) {
 				return nil
 			}
-			if maxAge > 0 && isOlderThan(maxAge, info.ModTime()) {
-				fmt.Printf("    file is too old   : %v\n", info.ModTime())
-				fmt.Println(equalsLine)
-				return nil
-			} else {
-				fmt.Printf("    file is new enough: %v\n", info.ModTime())
-				fmt.Println(equalsLine)
-			}
 			select {
 			case paths <- path:
 			case <-done:
@@ -190,36 +440,269 @@ func walkFiles(done <-chan struct{}, source string, match, exclude string, maxAg
 	return paths, errc
 }
 
+// fileFilter - decides which of the files found in the source directory are processed
+type fileFilter struct {
+	includeMatched *regexp.Regexp
+	excludeMatched *regexp.Regexp // nil when there is no --exclude
+	match, exclude string
+	maxAge         time.Duration
+	shard          shardSpec
+	stats          *runStats // that the files found are counted in, nil for none
+}
+
+// newFileFilter - return the filter of the -m, -x, -a and --shard flags
+func newFileFilter(match, exclude string, maxAge time.Duration, shard shardSpec) (*fileFilter, error) {
+	var err error
+	f := &fileFilter{match: match, exclude: exclude, maxAge: maxAge, shard: shard}
+	if len(exclude) > 0 {
+		f.excludeMatched, err = regexp.Compile(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s", exclude)
+		}
+	}
+	f.includeMatched, err = regexp.Compile(match)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %s", match)
+	}
+	return f, nil
+}
+
+// check - return true if the file at path is to be processed, and the reason
+// for the decision
+func (f *fileFilter) check(path string, info os.FileInfo) (ok bool, code reason, message string) {
+	switch {
+	case isInProgress(info.Name()):
+		return false, reasonSkipInProgress, "file is still being uploaded"
+	case f.excludeMatched != nil && f.excludeMatched.Match([]byte(info.Name())):
+		return false, reasonSkipRegex, fmt.Sprintf("file excluded via reg expr : %v", f.exclude)
+	case !f.includeMatched.Match([]byte(info.Name())):
+		return false, reasonSkipRegex, fmt.Sprintf("file didn't match : %v", f.match)
+	case !info.Mode().IsRegular():
+		return false, reasonSkipIrregular, "file is not regular"
+	case f.maxAge > 0 && isOlderThan(f.maxAge, info.ModTime()):
+		return false, reasonSkipAge, fmt.Sprintf("file is too old   : %v", info.ModTime())
+	case !f.shard.owns(path):
+		return false, reasonSkipShard, fmt.Sprintf("file belongs to another shard than %v", f.shard)
+	}
+	return true, "", fmt.Sprintf("file is new enough: %v", info.ModTime())
+}
+
+// accepts - return true if the file at path is to be processed, logging the decision
+func (f *fileFilter) accepts(path string, info os.FileInfo) bool {
+	ok, code, message := f.check(path, info)
+	if !info.IsDir() {
+		f.stats.recordScan(code, ok)
+	}
+	if ok {
+		logs.info(logEntry{Action: "select", File: path, Message: message}, "name:  %s\n    %s\n%s\n", info.Name(), message, equalsLine)
+	} else {
+		logs.info(logEntry{Action: "skip", File: path, Reason: code, Message: message}, "name:  %s\n    [%s] %s\n%s\n", info.Name(), code, message, equalsLine)
+	}
+	return ok
+}
+
 // digester reads path names from paths and sends digests of the corresponding
-// files on c until either paths or done is closed.
-func digester(done <-chan struct{}, paths <-chan string, dest string, p *caire.Processor, c chan<- result) {
+// files on c until either paths or done is closed, or halt is closed after a
+// fatal error.
+func digester(done, halt <-chan struct{}, paths <-chan string, opts *options, p *caire.Processor, conflicts *conflictResolver, c chan<- result) {
 	var err error
 	for path := range paths {
-		destFile := filepath.Join(dest, filepath.Base(path))
-		process(p, destFile, path)
-
 		select {
-		case c <- result{path, err}:
-		case <-done:
+		case <-halt:
+			return
+		default:
+		}
+		if !opts.activeHours.wait(done) {
 			return
 		}
+		if opts.checkpoint.done(path) {
+			opts.stats.recordSkip(reasonSkipResumed)
+			logs.info(logEntry{Action: "skip", File: path, Reason: reasonSkipResumed},
+				"    [%s] skipped, completed by the run being resumed: %s\n%s\n", reasonSkipResumed, path, equalsLine)
+			select {
+			case c <- result{path: path, skip: reasonSkipResumed, row: newReportRow(opts, path).finish("", "", reasonSkipResumed, false, nil, time.Now())}:
+				continue
+			case <-done:
+				return
+			}
+		}
+		if opts.settle > 0 && !waitUntilStable(path, opts.settle, done) {
+			opts.stats.recordSkip(reasonSkipInProgress)
+			logs.info(logEntry{Action: "skip", File: path, Reason: reasonSkipInProgress},
+				"    [%s] file disappeared while waiting for it to settle: %s\n%s\n", reasonSkipInProgress, path, equalsLine)
+			continue
+		}
+		if err = opts.scanner.check(opts.source, path); err != nil {
+			logs.error(logEntry{Action: "scan", File: path}.withErr(err), "    %v\n%s\n", err, equalsLine)
+			select {
+			case c <- result{path: path, err: err, row: newReportRow(opts, path).finish("", "", "", false, err, time.Now())}:
+				continue
+			case <-done:
+				return
+			}
+		}
+		if err = opts.snapshot.check(path); err != nil {
+			logs.info(logEntry{Action: "changed", File: path}.withErr(err), "    %v\n%s\n", err, equalsLine)
+			if opts.snapshot.skip {
+				select {
+				case c <- result{path: path, err: err, row: newReportRow(opts, path).finish("", "", "", false, err, time.Now())}:
+					continue
+				case <-done:
+					return
+				}
+			}
+		}
+		var fileOpts *options
+		if fileOpts, err = opts.forFile(path); err != nil {
+			logs.error(logEntry{Action: "profile", File: path}.withErr(err), "Unable to apply profile to %s: %v\n", path, err)
+			select {
+			case c <- result{path: path, err: err, row: newReportRow(opts, path).finish("", "", "", false, err, time.Now())}:
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		hash, completed := "", true
+		if opts.followRenames || opts.hashCache != nil {
+			// a source that can not be read fails when it is processed
+			hash, _ = hashFile(opts.hashAlgorithm, path)
+		}
+		for _, sized := range fileOpts.ladder() {
+			start := time.Now()
+			written, renamed, skip := "", false, reason("")
+			destFile, release, ok := conflicts.resolve(destName(sized, path), path)
+			if !ok {
+				err = nil
+				skip = reasonSkipConflict
+				logs.info(logEntry{Action: "skip", File: path, Reason: reasonSkipConflict},
+					"    [%s] skipped, a newer file has the same destination: %s\n%s\n", reasonSkipConflict, path, equalsLine)
+			} else if opts.hashCache.processed(hash, sized.params()) {
+				err = nil
+				skip = reasonSkipCached
+				logs.info(logEntry{Action: "skip", File: path, Dest: destFile, Reason: reasonSkipCached},
+					"    [%s] skipped, the same photo was processed with the same settings before: %s\n%s\n", reasonSkipCached, path, equalsLine)
+			} else if isBackfilled(sized, destFile) {
+				err = nil
+				written = outputName(opts, destFile)
+				skip = reasonSkipExists
+				logs.info(logEntry{Action: "skip", File: path, Dest: destFile, Reason: reasonSkipExists},
+					"    [%s] skipped, destination already exists: %s\n%s\n", reasonSkipExists, destFile, equalsLine)
+			} else if isUpToDate(sized, destFile, path) {
+				err = nil
+				written = outputName(opts, destFile)
+				skip = reasonSkipUpToDate
+				logs.info(logEntry{Action: "skip", File: path, Dest: destFile, Reason: reasonSkipUpToDate},
+					"    [%s] skipped, destination is newer than the source: %s\n%s\n", reasonSkipUpToDate, destFile, equalsLine)
+			} else if sized.skipCompliant && isCompliant(sized, destFile, path) {
+				err = nil
+				written = destFile
+				skip = reasonSkipCompliant
+				logs.info(logEntry{Action: "skip", File: path, Dest: destFile, Reason: reasonSkipCompliant},
+					"    [%s] skipped, destination already has the target size and format: %s\n%s\n", reasonSkipCompliant, destFile, equalsLine)
+			} else if err = os.MkdirAll(filepath.Dir(destFile), opts.dirMode); err != nil {
+				// destination subdirectories are created lazily, as each file needs them
+				err = applyPolicy("mkdir", withReason(reasonMkdir, err))
+				logs.error(logEntry{Action: "mkdir", File: path, Dest: destFile}.withErr(err), "Unable to create destination directory: %v\n", err)
+			} else if renamed, err = followRename(sized, path, destFile, hash); renamed || err != nil {
+				if err == nil {
+					written = outputName(opts, destFile)
+				} else {
+					logs.error(logEntry{Action: "rename", File: path, Dest: destFile}.withErr(err), "Unable to rename the output of %s: %v\n", path, err)
+				}
+			} else {
+				finished, measured := interrupts.output(outputName(opts, destFile)), opts.metrics.start()
+				err = process(p, sized, destFile, path)
+				finished()
+				measured()
+				opts.ledger.recordProcessed(path, destFile, sized.params(), hash, err)
+				if err == nil {
+					tagOutput(sized, outputName(opts, destFile))
+					opts.notifier.photoReady(opts.roster, path, outputName(opts, destFile))
+					opts.hashCache.record(hash, sized.params())
+				}
+				// a resize error still leaves the unresized image in the requested format
+				if r := reasonOf(err); err == nil || r == reasonFallbackCopy || r == reasonResize {
+					written = outputName(opts, destFile)
+				}
+				if err == nil && len(opts.golden) > 0 {
+					if err = compareGolden(opts, destFile); err != nil {
+						logs.error(logEntry{Action: "golden", File: path, Dest: destFile}.withErr(err), "%v\n", err)
+					}
+				}
+			}
+			if len(skip) > 0 {
+				opts.stats.recordSkip(skip)
+			}
+			if len(written) > 0 {
+				if uerr := opts.remote.upload(opts, written); uerr != nil {
+					err, written = uerr, ""
+					logs.error(logEntry{Action: "upload", File: path, Dest: destFile}.withErr(err), "%v\n", err)
+				}
+			}
+			release()
+			completed = completed && err == nil
+
+			select {
+			case c <- result{path: path, err: err, dest: written, skip: skip, row: newReportRow(opts, path).finish(destFile, written, skip, renamed, err, start)}:
+			case <-done:
+				return
+			}
+		}
+		if completed {
+			opts.checkpoint.record(path)
+		}
+		opts.remote.release(path)
 	}
 }
 
-// ImageSizeAll reads all the files in the file tree rooted at root and returns a map
-func ImageSizeAll(source, match, exclude, dest string, numWorkers, maxAge int, p *caire.Processor) error {
+// ImageSizeAll reads all the files in the file tree rooted at root and returns a map;
+// SIGINT and SIGTERM stop it from handing out more files, see interruptHandler
+func ImageSizeAll(opts *options, p *caire.Processor) error {
 	done := make(chan struct{})
-	defer close(done)
+	var once sync.Once
+	stop := func() { once.Do(func() { close(done) }) }
+	defer stop()
+	defer interrupts.graceful(stop, opts.gracePeriod)()
+
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+	err := processAll(done, paths, errc, opts, p)
+	opts.checkpoint.close(err == nil && !interrupts.interrupted())
+	return err
+}
 
-	paths, errc := walkFiles(done, source, match, exclude, maxAge)
+// processAll - process every path read from paths, then report on them; errc
+// delivers the error of whatever produced the paths once paths is closed
+func processAll(done <-chan struct{}, paths <-chan string, errc <-chan error, opts *options, p *caire.Processor) (err error) {
+	summary := &runSummary{Start: clock()}
+	var rows []reportRow
+	defer func() {
+		summary.finish(opts, err)
+		summary.report()
+		opts.metrics.recordBatch(err)
+		if serr := writeSummary(opts, summary); serr != nil {
+			logs.error(logEntry{Action: "summary"}.withErr(serr), "Unable to write the summary: %v\n", serr)
+		}
+		if len(opts.report) > 0 {
+			rerr := writeReport(opts.report, rows, opts.fileMode)
+			if rerr == nil {
+				rerr = signFile(opts.signingKey, opts.report, opts.fileMode)
+			}
+			if rerr != nil {
+				logs.error(logEntry{Action: "report", File: opts.report}.withErr(rerr), "Unable to write the report: %v\n", rerr)
+			}
+		}
+	}()
+	conflicts := newConflictResolver(opts.conflictStrategy)
 
 	// Start a fixed number of goroutines to read and digest files.
 	c := make(chan result)
+	halt := make(chan struct{})
 	var wg sync.WaitGroup
-	wg.Add(numWorkers)
-	for i := 0; i < numWorkers; i++ {
+	wg.Add(opts.numWorkers)
+	for i := 0; i < opts.numWorkers; i++ {
 		go func() {
-			digester(done, paths, dest, p, c)
+			digester(done, halt, paths, opts, resizer.CloneProcessor(p), conflicts, c)
 			wg.Done()
 		}()
 	}
@@ -230,17 +713,103 @@ func ImageSizeAll(source, match, exclude, dest string, numWorkers, maxAge int, p
 	// End of pipeline.
 
 	// consume c
+	matched, diverged := 0, 0
+	var sources, written []string
+	var failures []result
+	var fatal error
+	seen, failed := make(map[string]bool), make(map[string]bool)
 	for r := range c {
-		r.path += ""
+		summary.count(r)
+		opts.metrics.recordResult(r)
+		if r.row != nil {
+			rows = append(rows, *r.row)
+		}
+		if r.err != nil && reasonOf(r.err) != reasonFallbackCopy {
+			failures = append(failures, r)
+			failed[r.path] = true
+		}
+		// the files being processed are finished, the others are left for another run
+		if fatal == nil && isFatal(r.err) {
+			fatal = r.err
+			logs.error(logEntry{Action: "stop", File: r.path}.withErr(fatal), "Stopping the batch at %s: %v\n", r.path, fatal)
+			close(halt)
+		}
+		if !seen[r.path] {
+			seen[r.path] = true
+			sources = append(sources, r.path)
+		}
+		if len(r.dest) > 0 {
+			written = append(written, r.dest)
+		}
+		switch reasonOf(r.err) {
+		case reasonGoldenMissing, reasonGoldenDimensions, reasonGoldenDiffers:
+			diverged++
+		case "":
+			if r.err == nil {
+				matched++
+			}
+		}
 	}
 
-	if err := <-errc; err != nil {
+	if fatal != nil {
+		reportFailures(failures)
+		return fatal
+	}
+	// a walk that was stopped by a signal still has its files reported on
+	if err := <-errc; err != nil && !interrupts.interrupted() {
+		reportFailures(failures)
 		return err
 	}
 
+	if opts.roster != nil {
+		err := reportRoster(opts.roster, sources, opts.dest, opts.rosterReport, opts.fileMode)
+		if err == nil && len(opts.rosterReport) > 0 {
+			err = signFile(opts.signingKey, opts.rosterReport, opts.fileMode)
+		}
+		if err != nil {
+			logs.error(logEntry{Action: "roster"}.withErr(err), "Unable to write roster report: %v\n", err)
+		}
+	}
+	opts.stats.print()
+	if err := opts.telemetry.write(opts.fileMode); err != nil {
+		logs.warn(logEntry{Action: "telemetry"}.withErr(err), "Unable to write telemetry: %v\n", err)
+	}
+	if opts.checksums {
+		sums, err := writeChecksums(opts.dest, written, opts.fileMode)
+		if err == nil {
+			err = signFile(opts.signingKey, sums, opts.fileMode)
+		}
+		if err != nil {
+			logs.error(logEntry{Action: "checksums"}.withErr(err), "Unable to write %s: %v\n", checksumsName, err)
+		} else {
+			logs.info(logEntry{Action: "checksums", Dest: sums}, "checksums: %d files listed in %s\n", len(written), sums)
+		}
+	}
+	if len(opts.golden) > 0 {
+		logs.info(logEntry{Action: "summary"}, "golden: %d matched, %d diverged\n", matched, diverged)
+		if diverged > 0 {
+			return fmt.Errorf("%d outputs diverged from %s", diverged, opts.golden)
+		}
+	}
+	if len(failures) > 0 {
+		reportFailures(failures)
+		return fmt.Errorf("%d of %d files failed", len(failed), summary.Files)
+	}
 	return nil
 }
 
+// reportFailures - list the files that failed at the end of a run, with the
+// errors they failed with
+func reportFailures(failures []result) {
+	if len(failures) == 0 {
+		return
+	}
+	logs.error(logEntry{Action: "summary"}, "failed: %d outputs\n", len(failures))
+	for _, r := range failures {
+		logs.error(logEntry{Action: "failed", File: r.path}.withErr(r.err), "    %s: %v\n", r.path, r.err)
+	}
+}
+
 // fileExists - return true if given file exists
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
@@ -259,6 +828,62 @@ func dirExists(dirname string) bool {
 	return info.IsDir()
 }
 
+// parseMode - convert an octal permission string such as 0644 into a FileMode
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid permission mode: %s", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// subcommands - commands that can be given in place of the usual flags,
+// each is called with the remaining arguments and returns the exit code
+var subcommands = map[string]func(args []string) int{
+	"list-presets":  listPresets,
+	"list-reasons":  listReasons,
+	"list-policies": listPolicies,
+	"verify":        runVerify,
+	"decrypt":       runDecrypt,
+	"gen-testdata":  runGenTestdata,
+	"approve":       runTransition("approve"),
+	"reject":        runTransition("reject"),
+	"publish":       runTransition("publish"),
+	"reviews":       runReviews,
+	"compare-runs":  runCompareRuns,
+}
+
+// flagModes - subcommands that take the same flags as a batch run
+var flagModes = map[string]bool{
+	"serve":      true,
+	"coordinate": true,
+	"work":       true,
+	"estimate":   true,
+	"freshness":  true,
+	"adopt":      true,
+}
+
+// subcommandHelp - the subcommands listed by usage(), in order
+var subcommandHelp = [][2]string{
+	{"adopt", "record the outputs of older versions or other tools in the --ledger when they match the settings, see --adopt-software"},
+	{"approve", "move reviewed photos from processed to approved, see approve -h"},
+	{"compare-runs", "list the sources whose outcome, output dimensions or size differ between the --ledger files of two runs, see compare-runs -h"},
+	{"coordinate", "hand out the files of a batch to work subcommands, see --listen and --lease"},
+	{"decrypt", "decrypt photos written with --encrypt, see decrypt -h"},
+	{"estimate", "process a sample of the files and estimate the runtime and output size of the batch, see --sample"},
+	{"freshness", "list the photos taken longer ago than --photo-age, see --recapture"},
+	{"gen-testdata", "write synthetic photos of drawn faces, broken files among them, to try a configuration on, see gen-testdata -h"},
+	{"list-policies", "output which conditions end the run, fail the file or warn, by default and with --strict"},
+	{"list-presets", "output the presets available to --preset"},
+	{"list-reasons", "output the reason codes used in logs and reports"},
+	{"publish", "move photos from approved to published, see publish -h"},
+	{"reject", "move reviewed photos from processed or approved to rejected, see reject -h"},
+	{"reviews", "output how often photos were rejected for each --note in the --ledger and export the decisions as CSV, see reviews -h"},
+	{"serve", "start an HTTP server, see --listen, --grpc-listen and --jobs-root, or run the batch on a --schedule"},
+	{"verify", "check the signatures written by --sign-key, see verify -h"},
+	{"work", "process files handed out by a coordinate subcommand, see --coordinator"},
+}
+
 // usgae - output program's usage
 func usage() {
 	pgmName := os.Args[0]
@@ -268,66 +893,812 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\n%s: resize photo ID image files\n", pgmName)
 	fmt.Fprintf(os.Stderr, "version: %s\n", pgmVersion)
 	fmt.Fprintf(os.Stderr, "%s\n\n", pgmUrl)
-	flag.PrintDefaults()
+	printFlags(os.Stderr)
+	fmt.Fprintf(os.Stderr, "\nsubcommands:\n")
+	for _, sc := range subcommandHelp {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", sc[0], sc[1])
+	}
 }
 
 // main - process command-line arguments, do some error checking
 // and then call ImageSizeAll()
 func main() {
-	argsSource := flag.String("s", "", "source directory")
-	argsDestination := flag.String("d", "", "destination directory")
-	argsHeight := flag.Int("h", 0, "max image height")
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd, ok := subcommands[args[0]]; ok {
+			os.Exit(cmd(args[1:]))
+		}
+	}
+	var mode string
+	if len(args) > 0 && flagModes[args[0]] {
+		mode, args = args[0], args[1:]
+	}
+
+	argsSource := flag.String("s", "", "source directory, or s3://bucket/prefix, gs://bucket/prefix, sftp://user@host/path or webdavs://user@host/path")
+	aliasFlag("s", "source")
+	argsDestination := flag.String("d", "", "destination directory, or s3://bucket/prefix, gs://bucket/prefix, sftp://user@host/path or webdavs://user@host/path")
+	aliasFlag("d", "dest")
+	argsHeight := flag.Int("max-height", 0, "max image height")
+	aliasFlag("max-height", "height")
 	argsWidth := flag.Int("w", 0, "max image width")
+	aliasFlag("w", "max-width")
+	aliasFlag("w", "width")
+	argsMaxSize := flag.String("max-size", "", "max image size as WxH, Wx, xH or a percentage. Ex: 800x600, 50%")
+	argsEngine := flag.String("engine", "carve", "how images are resized: carve, scale without carving, or face-crop to crop around the face and scale; routes and profiles can choose another")
+	argsAspectTolerance := flag.Float64("aspect-tolerance", resizer.DefaultAspectTolerance, "percent that an image's aspect ratio may differ from the size's for --engine smart to scale it instead of carving")
+	argsAspect := flag.String("aspect", "", "aspect ratio every output must have, filling in the dimension -w or --max-height leave out; images within the size are cropped or padded to it. Ex: 3:4")
+	argsAspectMode := flag.String("aspect-mode", "crop", "how images within the size are brought to the --aspect: crop their sides or pad them with the --background, or white")
+	argsFaceCrop := flag.Bool("face-crop", false, "crop around the largest face and then scale, the same as --engine face-crop")
+	argsMargin := flag.String("margin", fmt.Sprintf("%d%%", defaultCropMargin), "percent of the face's size that --face-crop keeps around it on every side. Ex: 25%")
+	argsSquare := flag.Bool("square", false, "write square outputs, the smaller of the size's dimensions on a side, cropped around the face; photos without a face are carved square")
+	argsSeams := flag.String("seams", "both", "seams that carving may remove: both, vertical to only narrow images or horizontal to only shorten them; the rest is scaled and cropped")
+	argsMaxCarve := flag.Float64("max-carve", 0, "largest percent of an image's width or height that carving may remove, the rest is scaled and cropped; 0 for no limit")
+	argsScaler := flag.String("scaler", "seam", "how images are scaled: seam to carve them as --engine says, or lanczos or the faster bilinear to only scale them proportionally, many times faster")
+	argsSizes := flag.String("sizes", "", "write every source in each of these sizes, as accepted by --max-size, into a subdirectory of the destination named after the size. Ex: 96x96,240x240,648x648")
+	argsWidthMM := flag.Float64("width-mm", 0, "output width in millimeters, converted to pixels at --dpi. Ex: 35")
+	argsHeightMM := flag.Float64("height-mm", 0, "output height in millimeters, converted to pixels at --dpi. Ex: 45")
+	argsDPI := flag.Int("dpi", 0, "pixel density stamped into JPEG output, and used to convert --width-mm and --height-mm to pixels")
+	argsFit := flag.String("fit", "", "scale proportionally to fit within WxH, or N for NxN, without carving. Ex: 400x400")
+	argsPreset := flag.String("preset", "", "use the size, DPI, format and background of a preset, see list-presets")
+	argsQuality := flag.Int("quality", resizer.DefaultQuality, "JPEG and WebP quality of resized and converted outputs, from 1 to 100")
+	argsFormat := flag.String("format", "", "output format: jpg, png, gif, webp, tif or bmp. Default: same as the source")
 	argsMatch := flag.String("m", "jpg|png", "regular expression to match files. Ex: jpg")
+	aliasFlag("m", "match")
+	var argsOnlyUnder stringList
+	flag.Var(&argsOnlyUnder, "only-under", "only process files under `dir`, a subdirectory of the source directory; can be given more than once. Ex: Sales/East")
 	argsExclude := flag.String("x", "", "regular expression to exclude files, precedes -m")
+	aliasFlag("x", "exclude")
+	argsStrict := flag.Bool("strict", false, "end the run on problems that by default only fail the file or are warned about, see list-policies")
+	var argsPolicy stringList
+	flag.Var(&argsPolicy, "policy", "set what a condition does, `condition=action` with an action of fatal, fail or warn, overriding --strict; can be given more than once. Ex: mkdir=fail")
 	argsFace := flag.String("f", "facefinder", "path to 'facefinder' classification file")
+	aliasFlag("f", "facefinder")
 	argsWorkers := flag.Int("t", runtime.NumCPU(), "number of files to process concurrently")
-	argsMaxAge := flag.Int("a", 0, "skip files older than X number of days. Ex: 0=do not skip any, 7=skip files older than a week")
+	aliasFlag("t", "threads")
+	argsGracePeriod := flag.Duration("grace-period", 30*time.Second, "on SIGINT or SIGTERM, how long the files being processed are given to finish before the batch is aborted and their incomplete outputs removed")
+	argsMaxDays := flag.Int("a", 0, "skip files older than X number of days. Ex: 0=do not skip any, 7=skip files older than a week")
+	aliasFlag("a", "max-days")
+	argsMaxAge := flag.Duration("max-age", 0, "skip files last modified longer ago than this, in place of -a. Ex: 36h, 90m, 0 to not skip any")
+	argsConflict := flag.String("conflict-strategy", "overwrite", "when sources share a destination name: overwrite, numeric, timestamp, hash or newest-wins")
+	argsTagOutputs := flag.Bool("tag-outputs", false, "record the run and settings of each output in its "+tagName+" extended attribute, or alternate data stream on Windows")
+	argsLockOutputs := flag.Bool("lock-outputs", false, "take a lock file on each output while it is written, when several instances or work subcommands share a destination")
+	argsTrash := flag.Bool("trash", false, "move destination files that would be overwritten into "+trashDir+"/<run> in the destination instead")
+	argsTrashDays := flag.Int("trash-days", 30, "days --trash keeps the files of a run before removing them, 0 to keep them until removed by hand")
+	argsStripMetadata := flag.Bool("strip-metadata", false, "remove EXIF, GPS, IPTC, XMP and comments from outputs that are copied unchanged; resized outputs never have any")
+	argsKeepMetadata := flag.Bool("keep-metadata", false, "copy the EXIF and XMP data of JPEG sources into resized and converted JPEG outputs, which otherwise have none")
+	argsAlpha := flag.String("alpha", defaultAlpha, "what happens to transparent pixels of JPEG outputs: flatten:COLOR onto a hex color, keep, which leaves them to the encoder, or error")
+	argsPreserveColor := flag.Bool("preserve-color", false, "keep the bit depth and color model of 16-bit, CMYK and paletted sources instead of writing them as 8-bit RGB")
+	argsFixExtensions := flag.Bool("fix-extensions", true, "name outputs after the format of their content, such as a.png for PNG data in a.jpg, which is then copied unchanged")
+	argsLayout := flag.String("layout", "flatten", "flatten to write every output directly into the destination, or mirror to recreate the source's directories under it")
+	argsWindowsNames := flag.String("windows-names", defaultWindowsNames(), "for names Windows does not allow, such as CON.jpg: rename, flag or ignore")
+	argsShard := flag.String("shard", "", "only process shard i of N, so that N machines can split one batch. Ex: 2/8")
+	argsDiff := flag.Bool("diff", false, "only list the destination files a run would create (+) or change (~) and those no source leads to (-), changing nothing")
+	argsGate := flag.String("gate", "", "only validate the source images against the size, format and preset rules, writing a JSON verdict per file to this file")
+	argsKiosk := flag.String("kiosk", "", "capture photos of people stepping up to a camera, from an MJPEG or snapshot URL, or - for an MJPEG stream on stdin")
+	argsCacheDir := flag.String("cache-dir", "", "directory the serve subcommand keeps the photos it resized for /photo in")
+	argsCacheMB := flag.Int("cache-mb", 512, "megabytes of resized photos kept in --cache-dir, the least recently used are removed")
+	argsListen := flag.String("listen", "127.0.0.1:8080", "address the serve and coordinate subcommands listen on, only this machine by default. Ex: :8080 for every interface")
+	argsSchedule := flag.String("schedule", "", "make the serve subcommand a daemon that runs the batch at the times of this crontab entry instead of serving HTTP, or @hourly, @daily, @weekly or @monthly. Ex: \"0 2 * * *\"")
+	argsGRPCListen := flag.String("grpc-listen", "", "address the serve subcommand also serves the Resizer gRPC service of proto/resizer.proto on, none to not serve it. Ex: :9090")
+	argsMetrics := flag.Bool("metrics", false, "serve Prometheus metrics of the images processed, failures, queue depth and processing latency on /metrics of the serve subcommand, also with --schedule")
+	argsJobsRoot := flag.String("jobs-root", "", "directory whose subdirectories jobs submitted to the serve subcommand's /jobs endpoint may read and write, none to not accept jobs")
+	argsPIDFile := flag.String("pid-file", "", "file the serve subcommand writes its process ID to while running with --schedule")
+	argsLease := flag.Duration("lease", defaultLeaseTime, "how long a work subcommand has to process a file before the coordinator hands it out again")
+	argsCoordinator := flag.String("coordinator", "", "URL of the coordinate subcommand to lease files from. Ex: http://10.0.0.5:8080")
+	argsTokenFile := flag.String("token-file", "", "file whose first line is a shared token that the coordinate subcommand requires of work subcommands, which send it, and the serve subcommand of requests to /jobs")
+	argsSample := flag.Int("sample", defaultSampleSize, "number of files the estimate subcommand processes")
+	argsActiveHours := flag.String("active-hours", "", "only process files during this time of day, which may span midnight, waiting outside of it. Ex: 22:00-06:00")
+	argsVerifyCopies := flag.Float64("verify-copies", 0, "percentage of the files copied unchanged whose hash is compared to the source's after copying. Ex: 5")
+	argsHash := flag.String("hash", "sha256", "algorithm for the hashes of --snapshot, --follow-renames and --verify-copies: sha256, blake3 or xxhash, the fastest. SHA256SUMS is always SHA-256")
+	argsSnapshot := flag.String("snapshot", "", "record the path, size, modification time and hash of every source file in this file at the start of a batch run, and check files for changes before processing them")
+	argsSnapshotChanges := flag.String("snapshot-changes", "warn", "what happens to source files that changed after the --snapshot: warn or skip")
+	argsPipe := flag.Bool("pipe", false, "process the one image read from stdin and write the output to stdout, without -s and -d; logs go to stderr")
+	argsWatch := flag.Bool("watch", false, "keep running after processing the source directory, processing files as they are added or changed")
+	argsWatchMode := flag.String("watch-mode", "auto", "how --watch finds new and changed files: notify, poll, which works on NFS and SMB mounts, or auto to notify where possible")
+	argsDebounce := flag.Duration("debounce", 2*time.Second, "how long --watch waits after the last change notification before processing the changed files")
+	argsPollInterval := flag.Duration("poll-interval", 10*time.Second, "how often --watch walks the source directory when polling")
+	argsSettle := flag.Duration("settle", 0, "only process files that have not changed for this long, waiting for uploads in progress. Ex: 30s")
+	argsMissingOnly := flag.Bool("missing-only", false, "only process sources that have no destination file at all, without comparing sizes, times or settings, to recover lost outputs cheaply")
+	aliasFlag("missing-only", "skip-existing")
+	argsCacheFile := flag.String("cache-file", "", "JSON lines file of the content hashes of processed sources and their settings, so that re-runs skip photos processed before wherever their outputs went")
+	argsForce := flag.Bool("force", false, "process every source even if the --cache-file shows it was processed before, recording it again")
+	argsCheckpoint := flag.String("checkpoint", "", "JSON lines journal of the sources a batch run has completed, for a later --resume, removed once the batch completes without errors; with --resume alone, "+checkpointName+" in the destination")
+	argsResume := flag.Bool("resume", false, "continue an interrupted batch run, skipping the sources its --checkpoint lists as completed")
+	argsNewerOnly := flag.Bool("newer-only", false, "only process sources modified after their destination file was written, for nightly re-runs; with --ledger, outputs written with other settings are processed again")
+	argsSkipCompliant := flag.Bool("skip-compliant", false, "skip sources whose destination file already decodes and has the target size and format, whatever its age")
+	argsGolden := flag.String("golden", "", "compare outputs to the approved files with the same names in this directory and report differences")
+	argsGoldenDistance := flag.Int("golden-distance", defaultGoldenDistance, "how many of the 64 perceptual hash bits an output may differ from its --golden file by")
+	argsNameTemplate := flag.String("name-template", "", "name outputs with this template instead of after their sources, using {basename}, {ext}, {format}, {width}, {height}, {date} and {hash}. Ex: \"{basename}_{width}x{height}.{ext}\"")
+	argsManifest := flag.String("manifest", "", "CSV or JSON file listing the sources under -s to process, in its order, instead of every file, with the name of each output and optionally its size and format")
+	argsRoutes := flag.String("routes", "", "CSV file of rules that send images to destination subdirectories and profiles by camera make, model, software or megapixels")
+	argsRoster := flag.String("roster", "", "CSV file of employee IDs expected to have a photo named after their ID, to report who is missing")
+	argsNotify := flag.String("notify", "", "webhook URL, such as a Slack incoming webhook, to POST a JSON message to when the photo of a --roster entry with a priority is written")
+	argsRosterReport := flag.String("roster-report", "", "CSV file to list every photo missing from or not on the --roster in")
+	argsPhotoAge := flag.String("photo-age", "5y", "photos taken longer ago than this are listed by the freshness subcommand. Ex: 5y, 18m, 6w, 90d")
+	argsRecapture := flag.String("recapture", "", "directory the freshness subcommand moves photos that are too old into")
+	argsWorkflow := flag.String("workflow", "", "process DIR/incoming into DIR/processed for review, see approve, reject and publish")
+	argsS3Endpoint := flag.String("s3-endpoint", "", "URL of an S3 compatible service, such as MinIO, for s3:// directories; the credentials and region come from the AWS environment variables or configuration files")
+	argsGCSCredentials := flag.String("gcs-credentials", "", "service account key file for gs:// directories, instead of the application default credentials")
+	argsSFTPKey := flag.String("sftp-key", "", "private key file for sftp:// directories; keys of the SSH agent of SSH_AUTH_SOCK are also tried, and without either, ~/.ssh/id_ed25519, id_ecdsa and id_rsa")
+	argsSFTPKnownHosts := flag.String("sftp-known-hosts", "", "known_hosts file the host keys of sftp:// servers are checked against, instead of ~/.ssh/known_hosts")
+	argsWebDAVPassword := flag.String("webdav-password", "", "file with the password of the user of webdav:// and webdavs:// directories in its first line, such as a Nextcloud app password")
+	argsURLs := flag.String("urls", "", "file listing the http and https URLs of images to download and process, one per line, instead of -s; - reads them from stdin")
+	argsFetchWorkers := flag.Int("fetch-workers", 4, "number of --urls images to download concurrently")
+	argsFetchTimeout := flag.Duration("fetch-timeout", 30*time.Second, "how long downloading one --urls image may take")
+	argsFetchRetries := flag.Int("fetch-retries", 3, "how many times to retry a --urls download that failed with a network error, 429 or 5xx status, waiting 1s, 2s, 4s, ... between tries")
+	argsTmpDir := flag.String("tmp-dir", "", "directory for intermediate files, such as the samples of the estimate subcommand, instead of the system's temporary directory")
+	argsScanCommand := flag.String("scan-command", "", "scan every source with this command before it is decoded, the path is appended; exit code 1 rejects the file. Ex: \"clamdscan --no-summary\"")
+	argsScanICAP := flag.String("scan-icap", "", "scan every source with this ICAP service before it is decoded. Ex: icap://av.example.com:1344/avscan")
+	argsScanTimeout := flag.Duration("scan-timeout", time.Minute, "how long the scan of one source may take")
+	argsQuarantine := flag.String("quarantine", "", "directory sources rejected by --scan-command or --scan-icap are moved to")
+	argsReport := flag.String("report", "", "write what happened to every output, with its path, action, old and new dimensions and size, duration and error, to this .json or .csv file")
+	argsSummaryFile := flag.String("summary-file", "", "write the outcome of the batch run to this file as one line of JSON, for wrapper scripts")
+	argsSummaryFD := flag.Int("summary-fd", 0, "write the JSON summary of --summary-file to this open file descriptor, such as 3, apart from the log output")
+	argsTelemetry := flag.String("telemetry", "", "opt in to appending anonymous performance counters, such as resize times by image size, to this file to share upstream; no names or paths are recorded")
+	argsConfig := flag.String("config", "", "YAML file, or TOML file if the name ends in .toml, of option: value lines to use for the options not given on the command line")
+	argsReadonlySource := flag.Bool("assert-readonly-source", false, "refuse to run if the source and destination overlap or a feature would move or write files in the source directory")
+	argsLogFormat := flag.String("log-format", "text", "format of what is output while processing: text, or json for one JSON object per line")
+	argsLogLevel := flag.String("log-level", "info", "least severe messages to output: info, warn or error")
+	argsTestMode := flag.Bool("test-mode", false, "for integration tests: start the clock at --test-clock, name the run \""+testRunID+"\" and seed the random choices, so that runs can be repeated")
+	argsTestClock := flag.String("test-clock", testEpoch, "RFC 3339 time the clock of --test-mode starts at")
+	argsFollowRenames := flag.Bool("follow-renames", false, "record the hash of sources in the --ledger and rename the output of a renamed source instead of processing it again")
+	argsLedger := flag.String("ledger", "", "JSON lines file to record what happens to every photo in")
+	argsAdoptSoftware := flag.String("adopt-software", "", "only adopt outputs whose EXIF Software tag matches this case-insensitive pattern. Ex: \"Photoshop*\"")
+	argsEncrypt := flag.String("encrypt", "", "encrypt outputs with AES-256-GCM using the passphrase in the first line of this file, writing .enc files and a manifest")
+	argsChecksums := flag.Bool("checksums", false, "write a SHA256SUMS file listing the destination files of the run")
+	argsSignKey := flag.String("sign-key", "", "PEM file of an Ed25519 private key to sign SHA256SUMS, --gate verdicts, --roster-report, --report and --summary-file with, writing FILE.sig next to each")
+	argsPinned := flag.String("pinned", "", "sha256sum file of the classification file and configuration that must be unchanged, or the run is refused")
+	argsMaxMegapixels := flag.Int("max-megapixels", defaultMaxMegapixels, "refuse to decode images larger than this, so that one huge image can not exhaust memory. 0 for no limit")
+	argsLang := flag.String("lang", defaultLanguage, "language of the messages in --gate verdicts. Ex: en, es, fr, de")
+	argsMessages := flag.String("messages", "", "directory of <lang>.json files overriding or adding to the built-in messages")
+	argsFileMode := flag.String("file-mode", "0644", "permissions for destination files, subject to umask")
+	argsDirMode := flag.String("dir-mode", "0755", "permissions for the destination directory, subject to umask")
 	flag.Usage = usage
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
-	if len(*argsSource) == 0 || len(*argsDestination) == 0 {
+	// the pins are checked before the config is read, which must be pinned too,
+	// so that a changed config can not point to another classification file
+	var pinned *pinnedFiles
+	if len(*argsPinned) > 0 {
+		var err error
+		if pinned, err = checkPinned(*argsPinned); err == nil && len(*argsConfig) > 0 {
+			err = pinned.require("config file", *argsConfig)
+		}
+		if err != nil {
+			log.Fatalf("Refusing to run, pinned files do not match: %v\n", err)
+		}
+	}
+	if len(*argsConfig) > 0 {
+		values, err := loadConfig(*argsConfig)
+		if err != nil {
+			log.Fatalf("Unable to read config: %v\n", err)
+		}
+		if err := applyConfig(values); err != nil {
+			log.Fatalf("Invalid config %s: %v\n", *argsConfig, err)
+		}
+	}
+
+	if !validLogFormat(*argsLogFormat) {
+		log.Fatalf("Invalid --log-format: %s\n", *argsLogFormat)
+	}
+	if logs.level = parseLogLevel(*argsLogLevel); logs.level < 0 {
+		log.Fatalf("Invalid --log-level: %s\n", *argsLogLevel)
+	}
+	if len(*argsReport) > 0 {
+		if err := validReport(*argsReport); err != nil {
+			log.Fatalf("Invalid --report: %v\n", err)
+		}
+	}
+	logs.json = *argsLogFormat == "json"
+	logs.stderr = *argsPipe
+	if err := setPolicy(*argsStrict, argsPolicy); err != nil {
+		log.Fatalf("Invalid --policy: %v\n", err)
+	}
+	if *argsTestMode {
+		start, err := time.Parse(time.RFC3339, *argsTestClock)
+		if err != nil {
+			log.Fatalf("Invalid --test-clock: %v\n", err)
+		}
+		enableTestMode(start)
+	}
+
+	if len(*argsWorkflow) > 0 {
+		if len(*argsSource) == 0 {
+			*argsSource = filepath.Join(*argsWorkflow, stateIncoming)
+		}
+		if len(*argsDestination) == 0 {
+			*argsDestination = filepath.Join(*argsWorkflow, stateProcessed)
+		}
+	}
+
+	stats := &runStats{}
+	// bucket URLs and --urls are replaced by local directories that remoteSync keeps in step with them
+	var remote *remoteSync
+	if len(*argsManifest) > 0 && (len(mode) > 0 || *argsWatch || len(*argsKiosk) > 0 || len(*argsURLs) > 0 || isRemoteURL(*argsSource)) {
+		log.Fatalf("--manifest lists the files of a batch run under -s and can not be used with subcommands, --watch, --kiosk, --urls or a bucket source\n")
+	}
+	if (*argsResume || len(*argsCheckpoint) > 0) && (len(mode) > 0 || *argsWatch || len(*argsKiosk) > 0 || *argsPipe || len(*argsURLs) > 0 || isRemoteURL(*argsSource) || isRemoteURL(*argsDestination)) {
+		log.Fatalf("--checkpoint and --resume only apply to batch runs between local directories, without subcommands, --watch, --kiosk, --pipe or --urls\n")
+	}
+	var urlList []string
+	if len(*argsURLs) > 0 {
+		if len(*argsSource) > 0 {
+			log.Fatalf("--urls replaces -s, only one of them can be given\n")
+		}
+		var err error
+		if urlList, err = readURLList(*argsURLs); err != nil {
+			log.Fatalf("Unable to read the URLs: %v\n", err)
+		}
+	}
+	if isRemoteURL(*argsSource) || isRemoteURL(*argsDestination) || len(urlList) > 0 {
+		if len(mode) > 0 || *argsWatch || *argsDiff || len(*argsGate) > 0 || len(*argsKiosk) > 0 || len(*argsWorkflow) > 0 || len(*argsSnapshot) > 0 || *argsTrash || *argsChecksums ||
+			*argsFollowRenames || *argsSkipCompliant || *argsMissingOnly || *argsNewerOnly || len(*argsRoster) > 0 || len(*argsLedger) > 0 || len(*argsEncrypt) > 0 {
+			log.Fatalf("--urls and s3://, gs://, sftp:// and WebDAV directories are only supported by batch runs, without --watch, --diff, --gate, --kiosk, --workflow, --snapshot, --trash, --checksums, --follow-renames, --skip-compliant, --missing-only, --newer-only, --roster, --ledger or --encrypt\n")
+		}
+		var err error
+		if remote, err = newRemoteSync(*argsSource, *argsDestination, *argsS3Endpoint, *argsGCSCredentials, *argsSFTPKey, *argsSFTPKnownHosts, *argsWebDAVPassword, *argsTmpDir, *argsWorkers, stats); err != nil {
+			log.Fatalf("Unable to use the bucket: %v\n", err)
+		}
+		if remote.source != nil || len(urlList) > 0 {
+			*argsSource = remote.localSource
+		}
+		if remote.dest != nil {
+			*argsDestination = remote.localDest
+		}
+	}
+
+	daemon := mode == "serve" && len(*argsSchedule) > 0
+	if *argsPipe && (len(mode) > 0 || *argsWatch || len(*argsKiosk) > 0 || len(*argsGate) > 0 || *argsDiff || len(*argsSizes) > 0 || len(*argsEncrypt) > 0 ||
+		len(*argsSource) > 0 || len(*argsDestination) > 0 || len(*argsWorkflow) > 0) {
+		log.Fatalf("--pipe takes the image from stdin and can not be used with -s, -d, subcommands, --watch, --kiosk, --gate, --diff, --sizes, --encrypt or --workflow\n")
+	}
+	needDest := (len(mode) == 0 && len(*argsGate) == 0 && !*argsPipe) || mode == "work" || mode == "adopt" || daemon
+	if ((mode != "serve" || daemon) && !*argsPipe && len(*argsSource) == 0) || (needDest && len(*argsDestination) == 0) ||
+		(mode == "work" && len(*argsCoordinator) == 0) {
 		usage()
 		os.Exit(1)
 	}
 
+	faceDetect := true
 	if !fileExists(*argsFace) {
-		log.Fatalf("Classification file not found: %s", *argsFace)
+		if policy["classifier"] == "fatal" {
+			log.Fatalf("Classification file not found: %s", *argsFace)
+		}
+		logs.warn(logEntry{Action: "check", File: *argsFace}, "Classification file not found, resizing without face detection: %s\n", *argsFace)
+		faceDetect = false
+	}
+	for _, pattern := range []*string{argsMatch, argsExclude} {
+		if _, err := regexp.Compile(*pattern); err != nil {
+			if policy["regex"] == "fatal" {
+				log.Fatalf("Invalid regular expression: %s\n", *pattern)
+			}
+			logs.warn(logEntry{Action: "check"}.withErr(err), "Invalid regular expression, matching it as plain text: %s\n", *pattern)
+			*pattern = regexp.QuoteMeta(*pattern)
+		}
+	}
+	if pinned != nil {
+		if err := pinned.require("classification file", *argsFace); err != nil {
+			log.Fatalf("Refusing to run, pinned files do not match: %v\n", err)
+		}
 	}
 
-	if !dirExists(*argsSource) {
+	if (mode != "serve" || daemon) && !*argsPipe && !dirExists(*argsSource) {
 		log.Fatalf("Source directory does not exist: %s", *argsSource)
 	}
 
+	fileMode, err := parseMode(*argsFileMode)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	dirMode, err := parseMode(*argsDirMode)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+
+	size := resizer.Size{Width: *argsWidth, Height: *argsHeight}
+	if len(*argsMaxSize) > 0 || len(*argsFit) > 0 {
+		if size.IsSet() || (len(*argsMaxSize) > 0 && len(*argsFit) > 0) {
+			log.Fatalf("Only one of -w/--max-height, --max-size or --fit can be used\n")
+		}
+		if len(*argsFit) > 0 {
+			size, err = resizer.ParseSize(*argsFit, true)
+		} else {
+			size, err = resizer.ParseSize(*argsMaxSize, false)
+		}
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+	if *argsDPI < 0 || *argsWidthMM < 0 || *argsHeightMM < 0 {
+		log.Fatalf("Invalid --dpi, --width-mm or --height-mm\n")
+	}
+	if *argsWidthMM > 0 || *argsHeightMM > 0 {
+		if size.IsSet() {
+			log.Fatalf("Only one of -w/--max-height, --max-size, --fit or --width-mm/--height-mm can be used\n")
+		}
+		dpi := *argsDPI
+		if dpi == 0 && len(*argsPreset) > 0 {
+			dpi = lookupPreset(*argsPreset).dpi
+		}
+		if dpi == 0 {
+			log.Fatalf("--width-mm and --height-mm need a --dpi\n")
+		}
+		size = resizer.Size{Width: millimetersToPixels(*argsWidthMM, dpi), Height: millimetersToPixels(*argsHeightMM, dpi)}
+	}
+
+	var sizes []sizeStep
+	if len(*argsSizes) > 0 {
+		if size.IsSet() || *argsWidthMM > 0 || *argsHeightMM > 0 {
+			log.Fatalf("Only one of -w/--max-height, --max-size, --fit, --width-mm/--height-mm or --sizes can be used\n")
+		}
+		if mode == "coordinate" || mode == "work" || mode == "adopt" || *argsDiff {
+			log.Fatalf("--sizes is only supported by batch runs and --watch\n")
+		}
+		if sizes, err = parseSizes(*argsSizes); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		// the first size stands for all of them where only one is used, such as in estimates
+		size = sizes[0].size
+	}
+	aspect, err := resizer.ParseAspect(*argsAspect)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	if *argsAspectMode != "crop" && *argsAspectMode != "pad" {
+		log.Fatalf("Invalid --aspect-mode: %s\n", *argsAspectMode)
+	}
+	aspect.Pad = *argsAspectMode == "pad"
+
+	var dpi int
+	var headMin, headMax float64
+	var background color.Color
+	format := *argsFormat
+	if len(*argsPreset) > 0 {
+		// flags given on the command line take precedence over the preset
+		pr := lookupPreset(*argsPreset)
+		if !size.IsSet() {
+			size = resizer.Size{Width: pr.width, Height: pr.height}
+		}
+		if !isFlagSet("format") {
+			format = pr.format
+		}
+		dpi = pr.dpi
+		headMin, headMax = float64(pr.headMin), float64(pr.headMax)
+		if len(pr.background) > 0 {
+			bg, _ := resizer.ParseColor(pr.background)
+			background = bg
+		}
+	}
+	if *argsDPI > 0 {
+		dpi = *argsDPI
+	}
+	if size, err = aspect.ApplyTo(size); err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	for i := range sizes {
+		if sizes[i].size, err = aspect.ApplyTo(sizes[i].size); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+	if len(format) > 0 {
+		if format, err = resizer.ParseFormat(format); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+
+	if !validConflictStrategy(*argsConflict) {
+		log.Fatalf("Invalid conflict strategy: %s\n", *argsConflict)
+	}
+	if *argsMaxAge < 0 {
+		log.Fatalf("Invalid --max-age: %v\n", *argsMaxAge)
+	}
+	if *argsMaxDays > 0 && *argsMaxAge > 0 {
+		log.Fatalf("Only one of -a and --max-age can be used\n")
+	}
+	maxAge := *argsMaxAge
+	if *argsMaxDays > 0 {
+		maxAge = time.Duration(*argsMaxDays) * 24 * time.Hour
+	}
+	if !validEngine(*argsEngine) {
+		log.Fatalf("Invalid --engine: %s\n", *argsEngine)
+	}
+	engine := *argsEngine
+	if *argsFaceCrop {
+		if isFlagSet("engine") && engine != "face-crop" {
+			log.Fatalf("--face-crop can not be used with --engine %s\n", engine)
+		}
+		engine = "face-crop"
+	}
+	margin, err := strconv.ParseFloat(strings.TrimSuffix(*argsMargin, "%"), 64)
+	if err != nil || margin < 0 {
+		log.Fatalf("Invalid --margin: %s\n", *argsMargin)
+	}
+	if headMin > 0 && !isFlagSet("margin") && size.Width > 0 && size.Height > 0 {
+		// frame the head as the preset requires
+		margin = framingMargin(headMin, headMax, size.Width, size.Height)
+	}
+	if *argsSquare {
+		// the smaller dimension of the size is the side of the square
+		side := size.Width
+		if side == 0 || (size.Height > 0 && size.Height < side) {
+			side = size.Height
+		}
+		if side == 0 || size.Fit || size.Percent > 0 {
+			log.Fatalf("--square needs a width or height in pixels\n")
+		}
+		if engine != "carve" && engine != "face-crop" {
+			log.Fatalf("--square can not be used with --engine %s\n", engine)
+		}
+		size = resizer.Size{Width: side, Height: side}
+		engine = "face-crop"
+	}
+	if !validScaler(*argsScaler) {
+		log.Fatalf("Invalid --scaler: %s\n", *argsScaler)
+	}
+	if *argsAspectTolerance < 0 || *argsAspectTolerance >= 100 {
+		log.Fatalf("Invalid --aspect-tolerance: %g\n", *argsAspectTolerance)
+	}
+	if !validSeams(*argsSeams) {
+		log.Fatalf("Invalid --seams: %s\n", *argsSeams)
+	}
+	if *argsMaxCarve < 0 || *argsMaxCarve >= 100 {
+		log.Fatalf("Invalid --max-carve: %g\n", *argsMaxCarve)
+	}
+	if !validHashAlgorithm(*argsHash) {
+		log.Fatalf("Invalid --hash: %s\n", *argsHash)
+	}
+	if len(*argsNameTemplate) > 0 {
+		if err := parseNameTemplate(*argsNameTemplate); err != nil {
+			log.Fatalf("Invalid --name-template: %v\n", err)
+		}
+	}
+	if !validSnapshotChanges(*argsSnapshotChanges) {
+		log.Fatalf("Invalid --snapshot-changes: %s\n", *argsSnapshotChanges)
+	}
+	if !validWatchMode(*argsWatchMode) {
+		log.Fatalf("Invalid --watch-mode: %s\n", *argsWatchMode)
+	}
+	if *argsDebounce <= 0 {
+		log.Fatalf("Invalid --debounce: %s\n", *argsDebounce)
+	}
+	var schedule cronSchedule
+	if len(*argsSchedule) > 0 {
+		if mode != "serve" || *argsWatch || len(*argsKiosk) > 0 || len(*argsGate) > 0 || *argsDiff {
+			log.Fatalf("--schedule is only supported by the serve subcommand, without --watch, --kiosk, --gate or --diff\n")
+		}
+		if schedule, err = parseSchedule(*argsSchedule); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+	if *argsMetrics && mode != "serve" {
+		log.Fatalf("--metrics needs the serve subcommand\n")
+	}
+	if len(*argsJobsRoot) > 0 && (mode != "serve" || daemon || !dirExists(*argsJobsRoot)) {
+		log.Fatalf("--jobs-root needs the serve subcommand without --schedule and an existing directory: %s\n", *argsJobsRoot)
+	}
+	if len(*argsJobsRoot) > 0 && len(*argsTokenFile) == 0 && !loopbackAddr(*argsListen) {
+		log.Fatalf("--jobs-root needs --token-file when --listen accepts connections from other machines: %s\n", *argsListen)
+	}
+	if len(*argsGRPCListen) > 0 && (mode != "serve" || daemon) {
+		log.Fatalf("--grpc-listen needs the serve subcommand without --schedule\n")
+	}
+	if len(*argsPIDFile) > 0 && !daemon {
+		log.Fatalf("--pid-file needs the serve subcommand with --schedule\n")
+	}
+	if *argsPollInterval <= 0 {
+		log.Fatalf("Invalid --poll-interval: %s\n", *argsPollInterval)
+	}
+	if *argsQuality < 1 || *argsQuality > 100 {
+		log.Fatalf("Invalid --quality: %d\n", *argsQuality)
+	}
+	alpha, err := resizer.ParseAlpha(*argsAlpha)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	if !validLayout(*argsLayout) {
+		log.Fatalf("Invalid --layout: %s\n", *argsLayout)
+	}
+	if !validWindowsNames(*argsWindowsNames) {
+		log.Fatalf("Invalid --windows-names: %s\n", *argsWindowsNames)
+	}
+	if *argsReadonlySource && len(*argsSource) > 0 {
+		var modifiers []string
+		if len(*argsKiosk) > 0 {
+			modifiers = append(modifiers, "--kiosk saves its captures in the source directory")
+		}
+		if mode == "freshness" && len(*argsRecapture) > 0 {
+			modifiers = append(modifiers, "--recapture moves stale photos out of the source directory")
+		}
+		if len(*argsQuarantine) > 0 {
+			modifiers = append(modifiers, "--quarantine moves rejected sources out of the source directory")
+		}
+		outputs := map[string]string{
+			"--snapshot":      *argsSnapshot,
+			"--roster-report": *argsRosterReport,
+			"--ledger":        *argsLedger,
+			"--telemetry":     *argsTelemetry,
+			"--tmp-dir":       *argsTmpDir,
+			"--recapture":     *argsRecapture,
+		}
+		if err := checkReadonlySource(*argsSource, *argsDestination, modifiers, outputs); err != nil {
+			log.Fatalf("Refusing to run, --assert-readonly-source: %v\n", err)
+		}
+	}
+
+	shard, err := parseShard(*argsShard)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+
+	messages, err := loadCatalog(*argsMessages)
+	if err != nil {
+		log.Fatalf("Unable to load messages: %v\n", err)
+	}
+
+	only, err := parseSubtrees(*argsSource, argsOnlyUnder)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	hours, err := parseActiveHours(*argsActiveHours)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	scan, err := newScanner(*argsScanCommand, *argsScanICAP, *argsScanTimeout, *argsQuarantine)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	if *argsCacheMB < 1 {
+		log.Fatalf("Invalid --cache-mb: %d\n", *argsCacheMB)
+	}
+	if *argsSummaryFD < 0 {
+		log.Fatalf("Invalid --summary-fd: %d\n", *argsSummaryFD)
+	}
+
+	opts := &options{
+		source:           *argsSource,
+		dest:             *argsDestination,
+		onlyUnder:        only,
+		match:            *argsMatch,
+		exclude:          *argsExclude,
+		numWorkers:       *argsWorkers,
+		gracePeriod:      *argsGracePeriod,
+		settle:           *argsSettle,
+		verifyCopies:     *argsVerifyCopies,
+		stats:            stats,
+		tmpDir:           *argsTmpDir,
+		activeHours:      hours,
+		skipCompliant:    *argsSkipCompliant,
+		missingOnly:      *argsMissingOnly,
+		newerOnly:        *argsNewerOnly,
+		cacheDir:         *argsCacheDir,
+		cacheMB:          *argsCacheMB,
+		scanner:          scan,
+		remote:           remote,
+		summaryFile:      *argsSummaryFile,
+		report:           *argsReport,
+		summaryFD:        *argsSummaryFD,
+		engine:           engine,
+		square:           *argsSquare,
+		aspect:           aspect,
+		sizes:            sizes,
+		cropMargin:       margin,
+		marginSet:        isFlagSet("margin"),
+		headMin:          headMin,
+		headMax:          headMax,
+		scaler:           *argsScaler,
+		aspectTolerance:  *argsAspectTolerance,
+		seams:            *argsSeams,
+		maxCarve:         *argsMaxCarve,
+		maxAge:           maxAge,
+		shard:            shard,
+		size:             size,
+		format:           format,
+		dpi:              dpi,
+		quality:          *argsQuality,
+		background:       background,
+		alpha:            alpha,
+		fileMode:         fileMode,
+		dirMode:          dirMode,
+		maxPixels:        *argsMaxMegapixels * 1000000,
+		conflictStrategy: *argsConflict,
+		windowsNames:     *argsWindowsNames,
+		layout:           *argsLayout,
+		fixExtensions:    *argsFixExtensions,
+		preserveColor:    *argsPreserveColor,
+		followRenames:    *argsFollowRenames,
+		hashAlgorithm:    *argsHash,
+		nameTemplate:     *argsNameTemplate,
+		stripMetadata:    *argsStripMetadata,
+		keepMetadata:     *argsKeepMetadata,
+		tagOutputs:       *argsTagOutputs,
+		lockOutputs:      *argsLockOutputs,
+		golden:           *argsGolden,
+		goldenDistance:   *argsGoldenDistance,
+		lang:             *argsLang,
+		messages:         messages,
+	}
+	if len(*argsSource) > 0 {
+		opts.profiles = newProfileCache(*argsSource)
+	}
+	if len(*argsSource) > 0 && len(*argsDestination) > 0 {
+		if opts.nestedDest, err = nestedDest(*argsSource, *argsDestination); err != nil {
+			log.Fatalf("Refusing to run, %v\n", err)
+		}
+		if len(opts.nestedDest) > 0 {
+			logs.info(logEntry{Action: "skip", File: *argsDestination}, "destination %s is inside the source directory and is not processed\n", *argsDestination)
+		}
+	}
+	if len(*argsRoutes) > 0 {
+		if opts.routes, err = loadRoutes(*argsRoutes); err != nil {
+			log.Fatalf("Unable to read routes: %v\n", err)
+		}
+	}
+	if len(*argsManifest) > 0 {
+		if opts.manifest, err = loadManifest(*argsManifest, opts.source); err != nil {
+			log.Fatalf("Unable to read the manifest: %v\n", err)
+		}
+	}
+	if len(*argsRoster) > 0 {
+		if opts.roster, err = loadRoster(*argsRoster); err != nil {
+			log.Fatalf("Unable to read roster: %v\n", err)
+		}
+	}
+	opts.rosterReport = *argsRosterReport
+	if len(*argsNotify) > 0 {
+		opts.notifier = newNotifier(*argsNotify, opts.stats)
+	}
+	opts.checksums = *argsChecksums
+	opts.passphraseFile = *argsEncrypt
+	if len(*argsTokenFile) > 0 {
+		if opts.token, err = readToken(*argsTokenFile); err != nil {
+			log.Fatalf("Unable to read the token: %v\n", err)
+		}
+	}
+	opts.trashOutputs, opts.trashDays = *argsTrash, *argsTrashDays
+	if *argsMetrics {
+		opts.metrics = newMetrics()
+	}
+	if len(*argsSignKey) > 0 {
+		if opts.signingKey, err = loadSigningKey(*argsSignKey); err != nil {
+			log.Fatalf("Unable to read signing key: %v\n", err)
+		}
+	}
+	if *argsKeepMetadata && *argsStripMetadata {
+		log.Fatalf("Only one of --keep-metadata and --strip-metadata can be used\n")
+	}
+	if *argsFollowRenames && (len(*argsLedger) == 0 || len(*argsEncrypt) > 0) {
+		log.Fatalf("--follow-renames needs a --ledger and can not be used with --encrypt\n")
+	}
+	if len(*argsLedger) > 0 {
+		if opts.ledger, err = openLedger(*argsLedger, fileMode); err != nil {
+			log.Fatalf("Unable to open ledger: %v\n", err)
+		}
+	}
+	if *argsForce && len(*argsCacheFile) == 0 {
+		log.Fatalf("--force only applies to a --cache-file\n")
+	}
+	if len(*argsCacheFile) > 0 {
+		if opts.hashCache, err = openHashCache(*argsCacheFile, fileMode, *argsForce); err != nil {
+			log.Fatalf("Unable to open the cache file: %v\n", err)
+		}
+	}
+
+	p := resizer.NewProcessor(*argsFace)
+	p.FaceDetect = faceDetect
+	if len(*argsTelemetry) > 0 {
+		runMode := mode
+		switch {
+		case len(runMode) > 0:
+		case len(*argsKiosk) > 0:
+			runMode = "kiosk"
+		case *argsWatch:
+			runMode = "watch"
+		default:
+			runMode = "batch"
+		}
+		opts.telemetry = newTelemetry(*argsTelemetry, runMode, *argsWorkers, p.BlurRadius, p.SobelThreshold)
+	}
+	if len(urlList) > 0 {
+		opts.remote.urls = newURLFetcher(urlList, *argsFetchWorkers, *argsFetchRetries, *argsFetchTimeout, opts.stats)
+	}
+
+	if len(*argsGate) > 0 {
+		os.Exit(runGate(opts, *argsFace, *argsGate))
+	}
+
+	switch mode {
+	case "serve":
+		if !daemon {
+			os.Exit(runServer(opts, p, *argsFace, *argsListen, *argsGRPCListen, *argsJobsRoot))
+		}
+	case "coordinate":
+		os.Exit(runCoordinator(opts, *argsListen, *argsLease))
+	case "freshness":
+		age, err := parsePhotoAge(*argsPhotoAge)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		os.Exit(runFreshness(opts, age, *argsRecapture))
+	}
+
+	if !size.IsSet() {
+		fmt.Fprintf(os.Stderr, "\nYou must provide either a --max-height and/or -w, --max-size or --fit command-line option.\n")
+		os.Exit(1)
+	}
+
+	if size.Width > 0 && size.Height > 0 && !size.Fit && (engine == "carve" || engine == "smart") && *argsScaler == "seam" && len(*argsPreset) == 0 && *argsWidthMM == 0 {
+		fmt.Fprintf(os.Stderr, "\nWARNING: Using both --max-height and -w together may lead to undesirable results!\n\n")
+	}
+
+	if *argsPipe {
+		os.Exit(runPipe(opts, p))
+	}
+
+	if mode == "estimate" {
+		if *argsSample < 1 {
+			log.Fatalf("Invalid sample size: %d\n", *argsSample)
+		}
+		os.Exit(runEstimate(opts, p, *argsSample))
+	}
+
+	if *argsDiff {
+		os.Exit(runDiff(opts, len(*argsEncrypt) > 0))
+	}
+
+	if mode == "adopt" {
+		if opts.ledger == nil || len(*argsEncrypt) > 0 {
+			log.Fatalf("adopt needs a --ledger and can not be used with --encrypt\n")
+		}
+		os.Exit(runAdopt(opts, *argsAdoptSoftware))
+	}
+
 	if !dirExists(*argsDestination) {
-		err := os.Mkdir(*argsDestination, 0700)
+		err := os.MkdirAll(*argsDestination, dirMode)
 		if err != nil {
 			log.Fatalf("Destination directory does not exist: %s ; %s\n", *argsDestination, err)
 		}
 	}
+	if err := opts.openDest(); err != nil {
+		log.Fatalf("Unable to set up encryption: %v\n", err)
+	}
 
-	if *argsHeight == 0 && *argsWidth == 0 {
-		fmt.Fprintf(os.Stderr, "\nYou must provide either a -h and/or -w command-line option.\n")
-		os.Exit(1)
+	if len(*argsKiosk) > 0 {
+		os.Exit(runKiosk(opts, p, *argsFace, *argsKiosk))
 	}
 
-	if *argsHeight > 0 && *argsWidth > 0 {
-		fmt.Fprintf(os.Stderr, "\nWARNING: Using both -h and -w together may lead to undesirable results!\n\n")
+	if mode == "work" {
+		os.Exit(runWorker(opts, p, *argsCoordinator))
 	}
 
-	p := &caire.Processor{
-		BlurRadius:     10,
-		SobelThreshold: 1,
-		NewWidth:       *argsWidth,
-		NewHeight:      *argsHeight,
-		Percentage:     false,
-		Square:         false,
-		Debug:          false,
-		Scale:          true,
-		FaceDetect:     true,
-		FaceAngle:      0,
-		Classifier:     *argsFace,
+	if daemon {
+		os.Exit(runDaemon(opts, p, schedule, *argsListen, *argsPIDFile, *argsSnapshot, *argsSnapshotChanges))
 	}
 
-	ImageSizeAll(*argsSource, *argsMatch, *argsExclude, *argsDestination, *argsWorkers, *argsMaxAge, p)
+	if *argsWatch {
+		os.Exit(runWatch(opts, p, *argsWatchMode, *argsPollInterval, *argsDebounce))
+	}
+
+	if len(*argsSnapshot) > 0 {
+		if opts.snapshot, err = takeSnapshot(opts, *argsSnapshot, *argsSnapshotChanges); err != nil {
+			log.Fatalf("Unable to take snapshot: %v\n", err)
+		}
+	}
+	// the journal is only kept when it is asked for, so that runs that will
+	// never be resumed do not write a line for every source
+	if remote == nil && (len(*argsCheckpoint) > 0 || *argsResume) {
+		if opts.checkpoint, err = openCheckpoint(checkpointPath(*argsCheckpoint, *argsDestination), fileMode, dirMode, *argsResume); err != nil {
+			log.Fatalf("Unable to open the checkpoint: %v\n", err)
+		}
+	}
+	err = ImageSizeAll(opts, p)
+	opts.remote.cleanup()
+	if interrupts.interrupted() {
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 }
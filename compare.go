@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// runOutcome - what the last run recorded in a ledger did with a source
+type runOutcome struct {
+	outcome       string // processed, failed or failed [REASON]
+	width, height int
+	bytes         int64
+}
+
+// lastOutcomes - return the outcome of the last processed or failed entry of each
+// source in the ledger at path, by source
+func lastOutcomes(path string) (map[string]runOutcome, error) {
+	entries, err := readLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, fmt.Errorf("%s: no such ledger", path)
+	}
+	outcomes := make(map[string]runOutcome)
+	for _, e := range entries {
+		if (e.Event != "processed" && e.Event != "failed") || len(e.Source) == 0 {
+			continue
+		}
+		o := runOutcome{outcome: e.Event, width: e.Width, height: e.Height, bytes: e.Bytes}
+		if len(e.Reason) > 0 {
+			o.outcome += fmt.Sprintf(" [%s]", e.Reason)
+		}
+		outcomes[e.Source] = o
+	}
+	return outcomes, nil
+}
+
+// percentChange - return the change from before to after as a signed percentage
+func percentChange(before, after int64) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*float64(after-before)/float64(before))
+}
+
+// runCompareRuns - the compare-runs subcommand, compare the ledgers of two runs of
+// the same sources, such as before and after a change of settings, and list the
+// sources whose outcome, output dimensions or output size changed, followed by a
+// summary.  Only the last entry of each source in each ledger is compared, and
+// ledgers written before the dimensions and sizes were recorded only compare outcomes.
+func runCompareRuns(args []string) int {
+	fs := flag.NewFlagSet("compare-runs", flag.ExitOnError)
+	threshold := fs.Float64("size-threshold", 1, "percent that an output's size must change by to be listed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s compare-runs [--size-threshold PCT] BEFORE-LEDGER AFTER-LEDGER\n", pgmName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+	before, err := lastOutcomes(fs.Arg(0))
+	if err != nil {
+		log.Printf("Unable to read ledger: %v\n", err)
+		return 1
+	}
+	after, err := lastOutcomes(fs.Arg(1))
+	if err != nil {
+		log.Printf("Unable to read ledger: %v\n", err)
+		return 1
+	}
+
+	sources := make([]string, 0, len(before)+len(after))
+	for source := range before {
+		sources = append(sources, source)
+	}
+	for source := range after {
+		if _, ok := before[source]; !ok {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+
+	var outcomes, dimensions, sizes, onlyBefore, onlyAfter, compared int
+	var bytesBefore, bytesAfter int64
+	for _, source := range sources {
+		b, inBefore := before[source]
+		a, inAfter := after[source]
+		switch {
+		case !inAfter:
+			onlyBefore++
+			fmt.Printf("removed    %s: %s\n", source, b.outcome)
+			continue
+		case !inBefore:
+			onlyAfter++
+			fmt.Printf("added      %s: %s\n", source, a.outcome)
+			continue
+		}
+		compared++
+		if b.outcome != a.outcome {
+			outcomes++
+			fmt.Printf("outcome    %s: %s -> %s\n", source, b.outcome, a.outcome)
+		}
+		if b.width == 0 || a.width == 0 {
+			continue
+		}
+		if b.width != a.width || b.height != a.height {
+			dimensions++
+			fmt.Printf("dimensions %s: %dx%d -> %dx%d\n", source, b.width, b.height, a.width, a.height)
+		}
+		bytesBefore += b.bytes
+		bytesAfter += a.bytes
+		if a.bytes != b.bytes && b.bytes > 0 && math.Abs(float64(a.bytes-b.bytes))*100/float64(b.bytes) >= *threshold {
+			sizes++
+			fmt.Printf("size       %s: %d -> %d bytes (%s)\n", source, b.bytes, a.bytes, percentChange(b.bytes, a.bytes))
+		}
+	}
+	fmt.Printf("compare-runs: %d sources in both runs, %d changed outcome, %d changed dimensions, %d changed size by %g%% or more, %d only in %s, %d only in %s\n",
+		compared, outcomes, dimensions, sizes, *threshold, onlyBefore, fs.Arg(0), onlyAfter, fs.Arg(1))
+	fmt.Printf("compare-runs: outputs of both runs total %d bytes before and %d after (%s)\n", bytesBefore, bytesAfter, percentChange(bytesBefore, bytesAfter))
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseFlatTOML - parse the subset of TOML that config files need: one
+// key = value pair per line, with blank lines and # comments ignored and
+// optional quotes around values; tables and arrays are not supported
+func parseFlatTOML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			end := strings.IndexByte(value[1:], value[0])
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", n)
+			}
+			value = value[1 : end+1]
+		} else if j := strings.Index(value, "#"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is given twice", n, key)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// loadConfig - read the --config file at path, TOML if its name ends in .toml and
+// YAML otherwise, returning its option names and values
+func loadConfig(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		values, err = parseFlatTOML(data)
+	} else {
+		values, err = parseFlatYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+// applyConfig - set the flags named in values, which are the long or short flag
+// names without dashes, as if they were given on the command line, unless the flag
+// or one of its aliases already was; values of flags that can be given more than
+// once are comma separated
+func applyConfig(values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		name := strings.TrimLeft(key, "-")
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown option: %s", key)
+		}
+		if name == "pinned" {
+			return fmt.Errorf("%s can only be given on the command line, since it checks the config", key)
+		}
+		canonical := name
+		if target, ok := flagAliases[name]; ok {
+			canonical = target
+		}
+		if isFlagSet(canonical) {
+			continue
+		}
+		parts := []string{values[key]}
+		if _, ok := f.Value.(*stringList); ok {
+			parts = strings.Split(values[key], ",")
+		}
+		for _, part := range parts {
+			if err := flag.Set(name, strings.TrimSpace(part)); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %v", values[key], key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlatConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func([]byte) (map[string]string, error)
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "yaml",
+			parse: parseFlatYAML,
+			data:  "---\n# nightly run\ns: /mnt/incoming\nquality: 85  # smaller files\n\nmatch: \"jpg|png\"\nexclude: 'tmp #1'\nlisten: 127.0.0.1:8080\n",
+			want:  map[string]string{"s": "/mnt/incoming", "quality": "85", "match": "jpg|png", "exclude": "tmp #1", "listen": "127.0.0.1:8080"},
+		},
+		{
+			name:  "toml",
+			parse: parseFlatTOML,
+			data:  "# nightly run\ns = \"/mnt/incoming\"\nquality = 85 # smaller files\n\n\"match\" = 'jpg|png'\nexclude = \"tmp #1\" # kept\nformat=webp\n",
+			want:  map[string]string{"s": "/mnt/incoming", "quality": "85", "match": "jpg|png", "exclude": "tmp #1", "format": "webp"},
+		},
+		{name: "empty yaml", parse: parseFlatYAML, data: "", want: map[string]string{}},
+		{name: "empty toml", parse: parseFlatTOML, data: "\n# nothing\n", want: map[string]string{}},
+		{name: "yaml without a colon", parse: parseFlatYAML, data: "quality 85\n", wantErr: true},
+		{name: "yaml without a key", parse: parseFlatYAML, data: ": 85\n", wantErr: true},
+		{name: "yaml key given twice", parse: parseFlatYAML, data: "quality: 85\nquality: 90\n", wantErr: true},
+		{name: "toml without an equals sign", parse: parseFlatTOML, data: "quality 85\n", wantErr: true},
+		{name: "toml without a key", parse: parseFlatTOML, data: "= 85\n", wantErr: true},
+		{name: "toml table", parse: parseFlatTOML, data: "[resize]\nquality = 85\n", wantErr: true},
+		{name: "toml unterminated string", parse: parseFlatTOML, data: "match = \"jpg\n", wantErr: true},
+		{name: "toml key given twice", parse: parseFlatTOML, data: "quality = 85\n\"quality\" = 90\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tt.parse([]byte(tt.data))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testFlags - the flags that applyConfig is tested with
+type testFlags struct {
+	source, format string
+	quality        int
+	exclude        stringList
+}
+
+// withTestFlags - replace the command line flags with a few of the program's,
+// parsed from args, until the test ends
+func withTestFlags(t *testing.T, args ...string) *testFlags {
+	t.Helper()
+	commandLine, aliases := flag.CommandLine, flagAliases
+	t.Cleanup(func() { flag.CommandLine, flagAliases = commandLine, aliases })
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	flagAliases = make(map[string]string)
+
+	f := &testFlags{}
+	flag.StringVar(&f.source, "s", "", "source directory")
+	aliasFlag("s", "source")
+	flag.StringVar(&f.format, "format", "", "output format")
+	flag.IntVar(&f.quality, "quality", 100, "quality")
+	flag.Var(&f.exclude, "exclude", "names to leave out")
+	flag.String("config", "", "config file")
+	flag.String("pinned", "", "pinned checksums")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		values map[string]string
+		want   testFlags
+	}{
+		{
+			name:   "config only",
+			values: map[string]string{"s": "/cfg", "quality": "85", "--format": "webp"},
+			want:   testFlags{source: "/cfg", format: "webp", quality: 85},
+		},
+		{
+			name:   "command line wins",
+			args:   []string{"-s", "/cli", "--quality", "70"},
+			values: map[string]string{"s": "/cfg", "quality": "85", "format": "png"},
+			want:   testFlags{source: "/cli", format: "png", quality: 70},
+		},
+		{
+			name:   "alias on the command line wins over the flag in the config",
+			args:   []string{"--source", "/cli"},
+			values: map[string]string{"s": "/cfg"},
+			want:   testFlags{source: "/cli", quality: 100},
+		},
+		{
+			name:   "flag on the command line wins over the alias in the config",
+			args:   []string{"-s", "/cli"},
+			values: map[string]string{"source": "/cfg"},
+			want:   testFlags{source: "/cli", quality: 100},
+		},
+		{
+			name:   "lists are comma separated",
+			values: map[string]string{"exclude": "thumbs, .tmp"},
+			want:   testFlags{quality: 100, exclude: stringList{"thumbs", ".tmp"}},
+		},
+		{
+			name:   "lists on the command line are not added to",
+			args:   []string{"--exclude", "raw"},
+			values: map[string]string{"exclude": "thumbs,.tmp"},
+			want:   testFlags{quality: 100, exclude: stringList{"raw"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := withTestFlags(t, tt.args...)
+			if err := applyConfig(tt.values); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*f, tt.want) {
+				t.Errorf("got %+v, want %+v", *f, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"colour": "red"}, "unknown option"},
+		{map[string]string{"config": "other.yaml"}, "unknown option"},
+		{map[string]string{"pinned": "SHA256SUMS"}, "only be given on the command line"},
+		{map[string]string{"quality": "high"}, "invalid value"},
+	}
+	for _, tt := range tests {
+		withTestFlags(t)
+		err := applyConfig(tt.values)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("applyConfig(%v): got %v, want an error with %q", tt.values, err, tt.want)
+		}
+	}
+}
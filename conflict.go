@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conflictStrategies - the ways that two sources sharing a destination name can be resolved
+//
+//	overwrite:   every source is written to the same name, the last one processed wins
+//	numeric:     later sources get a _1, _2, ... suffix
+//	timestamp:   later sources get a suffix of their modification time
+//	hash:        later sources get a suffix of a hash of their source path
+//	newest-wins: only the source with the most recent modification time is written
+var conflictStrategies = []string{"overwrite", "numeric", "timestamp", "hash", "newest-wins"}
+
+// validConflictStrategy - return true if name is one of conflictStrategies
+func validConflictStrategy(name string) bool {
+	for _, s := range conflictStrategies {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// claim - the source that has been given a destination name during this run
+type claim struct {
+	source  string
+	modTime time.Time
+	writing sync.Mutex // held while the destination is written, for newest-wins
+}
+
+// conflictResolver - hands out destination names so that two sources never
+// silently write the same file, including names that differ only by case
+type conflictResolver struct {
+	strategy string
+	mu       sync.Mutex
+	claims   map[string]*claim // keyed by lower-cased destination path
+}
+
+// newConflictResolver - return a resolver for the given strategy
+func newConflictResolver(strategy string) *conflictResolver {
+	return &conflictResolver{strategy: strategy, claims: make(map[string]*claim)}
+}
+
+// resolve - return the destination name that source should be written to.
+// ok is false when source should be skipped.  release must be called once the
+// destination has been written, whether or not ok is true.
+func (r *conflictResolver) resolve(dest, source string) (name string, release func(), ok bool) {
+	release = func() {}
+	var modTime time.Time
+	if info, err := os.Stat(source); err == nil {
+		modTime = info.ModTime()
+	}
+
+	r.mu.Lock()
+	existing, taken := r.claims[strings.ToLower(dest)]
+	if !taken || r.strategy == "overwrite" {
+		c := &claim{source: source, modTime: modTime}
+		if r.strategy == "newest-wins" {
+			c.writing.Lock()
+			release = c.writing.Unlock
+		}
+		r.claims[strings.ToLower(dest)] = c
+		r.mu.Unlock()
+		return dest, release, true
+	}
+
+	if r.strategy == "newest-wins" {
+		if !modTime.After(existing.modTime) {
+			r.mu.Unlock()
+			return dest, release, false
+		}
+		existing.source, existing.modTime = source, modTime
+		r.mu.Unlock()
+
+		// wait for an older source that is still being written to this name,
+		// then make sure an even newer source has not claimed it in the meantime
+		existing.writing.Lock()
+		r.mu.Lock()
+		newest := existing.source == source
+		r.mu.Unlock()
+		if !newest {
+			existing.writing.Unlock()
+			return dest, release, false
+		}
+		return dest, existing.writing.Unlock, true
+	}
+	defer r.mu.Unlock()
+
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	switch r.strategy {
+	case "timestamp":
+		base += "_" + modTime.Format("20060102-150405")
+	case "hash":
+		sum := sha256.Sum256([]byte(source))
+		base += fmt.Sprintf("_%x", sum[:4])
+	}
+	// fall back to a numeric suffix when the suffixed name is also taken
+	name = base + ext
+	for i := 1; r.isClaimed(name); i++ {
+		name = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	r.claims[strings.ToLower(name)] = &claim{source: source, modTime: modTime}
+	return name, release, true
+}
+
+// isClaimed - return true if name has already been given out, r.mu must be held
+func (r *conflictResolver) isClaimed(name string) bool {
+	_, ok := r.claims[strings.ToLower(name)]
+	return ok
+}
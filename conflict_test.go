@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSources - create an empty file for each name in dir, each one an hour
+// newer than the one before, and return their paths
+func writeSources(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	base := time.Date(2024, 3, 1, 9, 30, 0, 0, time.Local)
+	var paths []string
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// resolveAll - resolve every source to dest in order, releasing each name
+// right away, and return the names given out, with "" for skipped sources
+func resolveAll(r *conflictResolver, dests, sources []string) []string {
+	var names []string
+	for i, source := range sources {
+		name, release, ok := r.resolve(dests[i], source)
+		release()
+		if !ok {
+			name = ""
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestConflictResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sources := writeSources(t, dir, "a/photo.jpg", "b/photo.jpg", "c/Photo.jpg")
+	out := filepath.Join(dir, "out")
+	same := []string{filepath.Join(out, "photo.jpg"), filepath.Join(out, "photo.jpg"), filepath.Join(out, "photo.jpg")}
+	cased := []string{filepath.Join(out, "photo.jpg"), filepath.Join(out, "PHOTO.jpg"), filepath.Join(out, "Photo.jpg")}
+
+	stamp := func(hour int) string {
+		return time.Date(2024, 3, 1, hour, 30, 0, 0, time.Local).Format("20060102-150405")
+	}
+	tests := []struct {
+		strategy string
+		dests    []string
+		want     []string
+	}{
+		{"overwrite", same, []string{"photo.jpg", "photo.jpg", "photo.jpg"}},
+		{"numeric", same, []string{"photo.jpg", "photo_1.jpg", "photo_2.jpg"}},
+		{"numeric", cased, []string{"photo.jpg", "PHOTO_1.jpg", "Photo_2.jpg"}},
+		{"timestamp", same, []string{"photo.jpg", "photo_" + stamp(10) + ".jpg", "photo_" + stamp(11) + ".jpg"}},
+		{"newest-wins", same, []string{"photo.jpg", "photo.jpg", "photo.jpg"}},
+		{"newest-wins", cased, []string{"photo.jpg", "PHOTO.jpg", "Photo.jpg"}},
+	}
+	for _, tt := range tests {
+		got := resolveAll(newConflictResolver(tt.strategy), tt.dests, sources)
+		for i := range got {
+			if len(got[i]) > 0 {
+				got[i] = filepath.Base(got[i])
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.strategy, got, tt.want)
+		}
+	}
+
+	// sources that share a name must never share a destination, whatever the case
+	for _, strategy := range []string{"numeric", "timestamp", "hash"} {
+		for _, dests := range [][]string{same, cased} {
+			names := resolveAll(newConflictResolver(strategy), dests, sources)
+			seen := make(map[string]bool)
+			for _, name := range names {
+				key := strings.ToLower(name)
+				if seen[key] {
+					t.Errorf("%s: %s was given out twice in %v", strategy, name, names)
+				}
+				seen[key] = true
+			}
+		}
+	}
+}
+
+func TestConflictResolverNumericSuffixTaken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sources := writeSources(t, dir, "a/photo_1.jpg", "a/photo.jpg", "b/photo.jpg")
+	dests := []string{"out/photo_1.jpg", "out/photo.jpg", "out/photo.jpg"}
+
+	// photo_1.jpg is already taken by a source of that name, so the
+	// second photo.jpg moves on to photo_2.jpg
+	got := resolveAll(newConflictResolver("numeric"), dests, sources)
+	want := []string{"out/photo_1.jpg", "out/photo.jpg", "out/photo_2.jpg"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestConflictResolverNewestWins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sources := writeSources(t, dir, "a/photo.jpg", "b/photo.jpg")
+	dests := []string{"out/photo.jpg", "out/photo.jpg"}
+
+	// the newer source wins when it is seen second
+	got := resolveAll(newConflictResolver("newest-wins"), dests, sources)
+	if got[0] != "out/photo.jpg" || got[1] != "out/photo.jpg" {
+		t.Errorf("newer source last: got %v", got)
+	}
+
+	// an older source that is seen second is skipped
+	got = resolveAll(newConflictResolver("newest-wins"), dests, []string{sources[1], sources[0]})
+	if got[0] != "out/photo.jpg" || got[1] != "" {
+		t.Errorf("older source last: got %v, want the second to be skipped", got)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// defaultLeaseTime - the default for --lease
+const defaultLeaseTime = 5 * time.Minute
+
+// maxLeaseAttempts - a file whose lease has expired this many times is given up on,
+// since it most likely crashes or hangs every worker that tries it
+const maxLeaseAttempts = 3
+
+// coordinatorLinger - how long the coordinator keeps telling workers that the batch
+// is finished before exiting, so that idle workers exit too instead of retrying
+const coordinatorLinger = 30 * time.Second
+
+// workerRetryInterval - how long a worker waits when there is nothing to lease
+// right now or the coordinator can not be reached
+const workerRetryInterval = 2 * time.Second
+
+// workerGiveUp - a worker exits when the coordinator has been unreachable this long
+const workerGiveUp = time.Minute
+
+// coordinatorTimeout - how long a worker waits for the coordinator to answer, so
+// that a hung coordinator counts as unreachable rather than blocking the worker
+const coordinatorTimeout = 30 * time.Second
+
+// work item states
+const (
+	itemPending = iota
+	itemLeased
+	itemDone
+)
+
+// workItem - a file handed out to workers, with paths relative to the source and
+// destination directories so that workers can mount them anywhere
+type workItem struct {
+	ID     int    `json:"id"`
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+
+	state    int
+	attempts int
+	expires  time.Time
+}
+
+// workResult - what a worker reports back once it has processed a work item
+type workResult struct {
+	ID     int    `json:"id"`
+	Error  string `json:"error,omitempty"`
+	Reason reason `json:"reason,omitempty"`
+}
+
+// workStatus - the progress of the batch, returned by /status
+type workStatus struct {
+	Total   int `json:"total"`
+	Pending int `json:"pending"`
+	Leased  int `json:"leased"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+}
+
+// coordinator - hands out the files of one batch to workers over HTTP; a lease
+// that is not completed in time is handed out again
+type coordinator struct {
+	mu        sync.Mutex
+	leaseTime time.Duration
+	items     []*workItem
+	pending   []int // IDs of items waiting for a worker, by roster priority and then in walk order
+	leased    map[int]*workItem
+	done      int
+	failed    int
+	finished  chan struct{}
+}
+
+// newCoordinator - walk the source directory and queue every file, deciding each
+// file's destination name up front so that --conflict-strategy sees the whole batch
+func newCoordinator(opts *options, leaseTime time.Duration) (*coordinator, error) {
+	co := &coordinator{leaseTime: leaseTime, leased: make(map[int]*workItem), finished: make(chan struct{})}
+
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+	conflicts := newConflictResolver(opts.conflictStrategy)
+	byDest := make(map[string]*workItem)
+	for path := range paths {
+		fileOpts, err := opts.forFile(path)
+		if err != nil {
+			logs.error(logEntry{Action: "profile", File: path}.withErr(err), "Unable to apply profile to %s: %v\n", path, err)
+			continue
+		}
+		destFile, release, ok := conflicts.resolve(destName(fileOpts, path), path)
+		release()
+		if !ok {
+			logs.info(logEntry{Action: "skip", File: path, Reason: reasonSkipConflict},
+				"    [%s] skipped, a newer file has the same destination: %s\n", reasonSkipConflict, path)
+			continue
+		}
+		src, err := filepath.Rel(opts.source, path)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := filepath.Rel(opts.dest, destFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// overwrite and newest-wins reuse a name; only the last source given it is written
+		key := strings.ToLower(dst)
+		if item, ok := byDest[key]; ok {
+			item.Source = filepath.ToSlash(src)
+			continue
+		}
+		item := &workItem{ID: len(co.items), Source: filepath.ToSlash(src), Dest: filepath.ToSlash(dst)}
+		byDest[key] = item
+		co.items = append(co.items, item)
+		co.pending = append(co.pending, item.ID)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if opts.roster != nil {
+		sort.SliceStable(co.pending, func(i, j int) bool {
+			return opts.roster.priorityOf(co.items[co.pending[i]].Source) > opts.roster.priorityOf(co.items[co.pending[j]].Source)
+		})
+	}
+	if len(co.items) == 0 {
+		close(co.finished)
+	}
+	return co, nil
+}
+
+// expireLeases - put items whose lease has run out back in the queue, co.mu must be held
+func (co *coordinator) expireLeases() {
+	now := time.Now()
+	for id, item := range co.leased {
+		if now.Before(item.expires) {
+			continue
+		}
+		delete(co.leased, id)
+		if item.attempts >= maxLeaseAttempts {
+			logs.error(logEntry{Action: "lease", File: item.Source, Reason: reasonLeaseExpired},
+				"[%s] giving up on %s after %d attempts\n", reasonLeaseExpired, item.Source, item.attempts)
+			co.finish(item, false)
+			continue
+		}
+		item.state = itemPending
+		co.pending = append(co.pending, id)
+	}
+}
+
+// finish - mark item as done, co.mu must be held
+func (co *coordinator) finish(item *workItem, ok bool) {
+	item.state = itemDone
+	if ok {
+		co.done++
+	} else {
+		co.failed++
+	}
+	if co.done+co.failed == len(co.items) {
+		close(co.finished)
+	}
+}
+
+// handleLease - hand out the next work item; 204 means try again later and 410
+// means the batch is finished
+func (co *coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST to lease a file", http.StatusMethodNotAllowed)
+		return
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.expireLeases()
+	if len(co.pending) == 0 {
+		if len(co.leased) == 0 {
+			w.WriteHeader(http.StatusGone)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	item := co.items[co.pending[0]]
+	co.pending = co.pending[1:]
+	item.state = itemLeased
+	item.attempts++
+	item.expires = time.Now().Add(co.leaseTime)
+	co.leased[item.ID] = item
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleComplete - record a worker's result; a late result for an item that was
+// handed out again is accepted if the item has not been finished in the meantime
+func (co *coordinator) handleComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a result", http.StatusMethodNotAllowed)
+		return
+	}
+	var res workResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if res.ID < 0 || res.ID >= len(co.items) {
+		http.Error(w, "unknown work item", http.StatusNotFound)
+		return
+	}
+	item := co.items[res.ID]
+	if item.state == itemDone {
+		return
+	}
+	if item.state == itemPending {
+		for i, id := range co.pending {
+			if id == item.ID {
+				co.pending = append(co.pending[:i], co.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(co.leased, item.ID)
+	if len(res.Error) > 0 {
+		logs.error(logEntry{Action: "complete", File: item.Source, Reason: res.Reason, Error: res.Error},
+			"Unable to process %s: %s\n", item.Source, res.Error)
+	}
+	co.finish(item, len(res.Error) == 0)
+}
+
+// handleStatus - return the progress of the batch
+func (co *coordinator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(co.status())
+}
+
+func (co *coordinator) status() workStatus {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return workStatus{
+		Total:   len(co.items),
+		Pending: len(co.pending),
+		Leased:  len(co.leased),
+		Done:    co.done,
+		Failed:  co.failed,
+	}
+}
+
+// runCoordinator - the coordinate subcommand, serve the files of the source
+// directory to workers until all of them have been processed
+func runCoordinator(opts *options, listen string, leaseTime time.Duration) int {
+	co, err := newCoordinator(opts, leaseTime)
+	if err != nil {
+		logs.error(logEntry{Action: "walk", File: opts.source}.withErr(err), "Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+	logs.warn(logEntry{Action: "listen"}, "coordinating %d files on %s\n", len(co.items), listen)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lease", requireToken(opts.token, co.handleLease))
+	mux.HandleFunc("/complete", requireToken(opts.token, co.handleComplete))
+	mux.HandleFunc("/status", requireToken(opts.token, co.handleStatus))
+	errc := make(chan error, 1)
+	go func() {
+		errc <- http.ListenAndServe(listen, mux)
+	}()
+
+	select {
+	case err := <-errc:
+		logs.error(logEntry{Action: "listen"}.withErr(err), "%v\n", err)
+		return 1
+	case <-co.finished:
+	}
+	st := co.status()
+	logs.info(logEntry{Action: "summary"}, "coordinate: %d processed, %d failed\n", st.Done, st.Failed)
+	time.Sleep(coordinatorLinger)
+	if st.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// coordinatorClient - the requests a worker makes to the coordinator
+type coordinatorClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// newCoordinatorClient - return a client of the coordinator at url, sending token
+func newCoordinatorClient(url, token string) *coordinatorClient {
+	return &coordinatorClient{url: strings.TrimSuffix(url, "/"), token: token, client: &http.Client{Timeout: coordinatorTimeout}}
+}
+
+// post - POST body to path of the coordinator
+func (cc *coordinatorClient) post(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, cc.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	withToken(req, cc.token)
+	return cc.client.Do(req)
+}
+
+// leaseWork - ask the coordinator for a work item, nil means there is none right
+// now; finished is true once the batch is done
+func (cc *coordinatorClient) leaseWork() (item *workItem, finished bool, err error) {
+	resp, err := cc.post("/lease", nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		item = &workItem{}
+		return item, false, json.NewDecoder(resp.Body).Decode(item)
+	case http.StatusNoContent:
+		return nil, false, nil
+	case http.StatusGone:
+		return nil, true, nil
+	}
+	return nil, false, fmt.Errorf("%s/lease: %s", cc.url, resp.Status)
+}
+
+// completeWork - report the result of processing a work item to the coordinator
+func (cc *coordinatorClient) completeWork(res workResult) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	resp, err := cc.post("/complete", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s/complete: %s", cc.url, resp.Status)
+	}
+	return nil
+}
+
+// itemPath - return the path rel names under root, refusing absolute paths and
+// paths that leave root, since work items come from over the network
+func itemPath(root, rel string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if len(rel) == 0 || filepath.IsAbs(clean) || len(filepath.VolumeName(clean)) > 0 ||
+		clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("work item path outside of %s: %s", root, rel)
+	}
+	return filepath.Join(root, clean), nil
+}
+
+// runWorker - the work subcommand, process files leased from the coordinator
+// until it reports that the batch is finished or can not be reached for workerGiveUp
+func runWorker(opts *options, p *caire.Processor, coordinatorURL string) int {
+	cc := newCoordinatorClient(coordinatorURL, opts.token)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	processed, failed := 0, 0
+	var fatal error
+	wg.Add(opts.numWorkers)
+	for i := 0; i < opts.numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			p := resizer.CloneProcessor(p)
+			lastContact := time.Now()
+			for {
+				mu.Lock()
+				stopped := fatal != nil
+				mu.Unlock()
+				if stopped {
+					return
+				}
+				// files are only leased during the active hours, the coordinator keeps them until then
+				if !opts.activeHours.contains(time.Now()) {
+					opts.activeHours.wait(nil)
+					lastContact = time.Now()
+				}
+				opts.stats.recordCall("coordinator")
+				item, finished, err := cc.leaseWork()
+				if finished {
+					return
+				}
+				if err != nil {
+					if time.Since(lastContact) > workerGiveUp {
+						logs.error(logEntry{Action: "lease"}.withErr(err), "Giving up on the coordinator: %v\n", err)
+						return
+					}
+					time.Sleep(workerRetryInterval)
+					continue
+				}
+				lastContact = time.Now()
+				if item == nil {
+					time.Sleep(workerRetryInterval)
+					continue
+				}
+
+				srcname, err := itemPath(opts.source, item.Source)
+				var dstname string
+				if err == nil {
+					dstname, err = itemPath(opts.dest, item.Dest)
+				}
+				var fileOpts *options
+				if err == nil {
+					fileOpts, err = opts.forFile(srcname)
+				}
+				if err == nil {
+					err = opts.scanner.check(opts.source, srcname)
+				}
+				if err == nil && !isBackfilled(fileOpts, dstname) && !isUpToDate(fileOpts, dstname, srcname) && !(opts.skipCompliant && isCompliant(fileOpts, dstname, srcname)) {
+					if err = os.MkdirAll(filepath.Dir(dstname), opts.dirMode); err != nil {
+						err = applyPolicy("mkdir", withReason(reasonMkdir, err))
+					} else {
+						err = process(p, fileOpts, dstname, srcname)
+						opts.ledger.recordProcessed(srcname, dstname, fileOpts.params(), "", err)
+						if err == nil {
+							tagOutput(fileOpts, outputName(opts, dstname))
+							opts.notifier.photoReady(opts.roster, srcname, outputName(opts, dstname))
+						}
+					}
+				}
+				res := workResult{ID: item.ID}
+				if err != nil {
+					res.Error, res.Reason = err.Error(), reasonOf(err)
+				}
+
+				mu.Lock()
+				processed++
+				if len(res.Error) > 0 {
+					failed++
+				}
+				if fatal == nil && isFatal(err) {
+					fatal = err
+					logs.error(logEntry{Action: "stop", File: item.Source}.withErr(err), "Stopping the worker at %s: %v\n", item.Source, err)
+				}
+				mu.Unlock()
+				// a result that can not be reported is processed again once its lease expires
+				opts.stats.recordCall("coordinator")
+				if err := cc.completeWork(res); err != nil {
+					logs.warn(logEntry{Action: "complete", File: item.Source}.withErr(err), "Unable to report %s: %v\n", item.Source, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	logs.info(logEntry{Action: "summary"}, "work: %d processed, %d failed\n", processed, failed)
+	opts.stats.print()
+	if err := opts.telemetry.write(opts.fileMode); err != nil {
+		logs.warn(logEntry{Action: "telemetry"}.withErr(err), "Unable to write telemetry: %v\n", err)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
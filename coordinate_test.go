@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLeaseTime - a lease short enough for the tests to let it run out
+const testLeaseTime = 20 * time.Millisecond
+
+// newTestCoordinator - return a coordinator of n items, all of them pending
+func newTestCoordinator(n int) *coordinator {
+	co := &coordinator{leaseTime: testLeaseTime, leased: make(map[int]*workItem), finished: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		co.items = append(co.items, &workItem{ID: i, Source: fmt.Sprintf("IMG_%04d.jpg", i), Dest: fmt.Sprintf("IMG_%04d.jpg", i)})
+		co.pending = append(co.pending, i)
+	}
+	return co
+}
+
+// lease - POST /lease and return the status code and the item handed out, if any
+func lease(t *testing.T, co *coordinator) (int, *workItem) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	co.handleLease(rec, httptest.NewRequest(http.MethodPost, "/lease", nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var item workItem
+	if err := json.NewDecoder(rec.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, &item
+}
+
+// complete - POST res to /complete and return the status code
+func complete(t *testing.T, co *coordinator, res workResult) int {
+	t.Helper()
+	body, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	co.handleComplete(rec, httptest.NewRequest(http.MethodPost, "/complete", strings.NewReader(string(body))))
+	return rec.Code
+}
+
+// isFinished - return true if the batch of co has finished
+func isFinished(co *coordinator) bool {
+	select {
+	case <-co.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestCoordinatorLeaseExpiry(t *testing.T) {
+	co := newTestCoordinator(1)
+	code, item := lease(t, co)
+	if code != http.StatusOK || item.ID != 0 {
+		t.Fatalf("first lease: got %d %v, want item 0", code, item)
+	}
+	if code, _ := lease(t, co); code != http.StatusNoContent {
+		t.Errorf("lease while item 0 is leased: got %d, want %d", code, http.StatusNoContent)
+	}
+
+	time.Sleep(2 * testLeaseTime)
+	code, item = lease(t, co)
+	if code != http.StatusOK || item.ID != 0 {
+		t.Fatalf("lease after expiry: got %d %v, want item 0 again", code, item)
+	}
+	if got := co.items[0].attempts; got != 2 {
+		t.Errorf("item 0 was leased %d times, want 2", got)
+	}
+
+	if code := complete(t, co, workResult{ID: 0}); code != http.StatusOK {
+		t.Fatalf("complete: got %d", code)
+	}
+	if code, _ := lease(t, co); code != http.StatusGone {
+		t.Errorf("lease after the batch: got %d, want %d", code, http.StatusGone)
+	}
+	if st := co.status(); st.Done != 1 || st.Failed != 0 || !isFinished(co) {
+		t.Errorf("got %+v, finished %v, want 1 done and the batch finished", st, isFinished(co))
+	}
+}
+
+func TestCoordinatorGivesUp(t *testing.T) {
+	co := newTestCoordinator(2)
+	for attempt := 1; attempt <= maxLeaseAttempts; attempt++ {
+		leased := make(map[int]bool)
+		for i := 0; i < 2; i++ {
+			code, item := lease(t, co)
+			if code != http.StatusOK {
+				t.Fatalf("attempt %d: got %d, want an item", attempt, code)
+			}
+			leased[item.ID] = true
+		}
+		if !leased[0] || !leased[1] {
+			t.Fatalf("attempt %d: got %v, want items 0 and 1", attempt, leased)
+		}
+		// item 1 is finished on its last attempt, item 0 never is
+		if attempt == maxLeaseAttempts {
+			complete(t, co, workResult{ID: 1})
+		}
+		time.Sleep(2 * testLeaseTime)
+	}
+
+	if code, _ := lease(t, co); code != http.StatusGone {
+		t.Errorf("lease after %d expired leases: got %d, want %d", maxLeaseAttempts, code, http.StatusGone)
+	}
+	if st := co.status(); st.Done != 1 || st.Failed != 1 || st.Pending != 0 || st.Leased != 0 || !isFinished(co) {
+		t.Errorf("got %+v, finished %v, want 1 done, 1 failed and the batch finished", st, isFinished(co))
+	}
+}
+
+func TestCoordinatorLateComplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		release bool // whether the item is handed out again before the late result
+	}{
+		{name: "pending again", release: false},
+		{name: "leased again", release: true},
+	}
+	for _, tt := range tests {
+		co := newTestCoordinator(1)
+		lease(t, co)
+		time.Sleep(2 * testLeaseTime)
+		if tt.release {
+			if code, item := lease(t, co); code != http.StatusOK || item.ID != 0 {
+				t.Fatalf("%s: got %d %v, want item 0 again", tt.name, code, item)
+			}
+		} else {
+			co.mu.Lock()
+			co.expireLeases()
+			co.mu.Unlock()
+		}
+
+		// the first worker's result arrives after its lease ran out
+		if code := complete(t, co, workResult{ID: 0}); code != http.StatusOK {
+			t.Fatalf("%s: late complete got %d", tt.name, code)
+		}
+		if st := co.status(); st.Done != 1 || st.Pending != 0 || st.Leased != 0 || !isFinished(co) {
+			t.Errorf("%s: got %+v, finished %v, want 1 done and the batch finished", tt.name, st, isFinished(co))
+		}
+		// a second result for the same item is ignored
+		if code := complete(t, co, workResult{ID: 0, Error: "too late"}); code != http.StatusOK {
+			t.Errorf("%s: second complete got %d", tt.name, code)
+		}
+		if st := co.status(); st.Done != 1 || st.Failed != 0 {
+			t.Errorf("%s: got %+v after a second result, want 1 done", tt.name, st)
+		}
+	}
+
+	co := newTestCoordinator(1)
+	for _, id := range []int{-1, 1} {
+		if code := complete(t, co, workResult{ID: id}); code != http.StatusNotFound {
+			t.Errorf("complete of item %d: got %d, want %d", id, code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestItemPath(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "archive")
+	tests := []struct {
+		rel     string
+		want    string
+		wantErr bool
+	}{
+		{rel: "IMG_0001.jpg", want: filepath.Join(root, "IMG_0001.jpg")},
+		{rel: "dept1/IMG_0001.jpg", want: filepath.Join(root, "dept1", "IMG_0001.jpg")},
+		{rel: "dept1/../IMG_0001.jpg", want: filepath.Join(root, "IMG_0001.jpg")},
+		{rel: "", wantErr: true},
+		{rel: ".", wantErr: true},
+		{rel: "..", wantErr: true},
+		{rel: "../IMG_0001.jpg", wantErr: true},
+		{rel: "dept1/../../IMG_0001.jpg", wantErr: true},
+		{rel: "/etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := itemPath(root, tt.rel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("itemPath(%q) = %s, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("itemPath(%q): %v", tt.rel, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("itemPath(%q) = %s, want %s", tt.rel, got, tt.want)
+		}
+	}
+}
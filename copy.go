@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// runStats - how many files took the pass-through copy path and how many were
+// resized or converted, and how long each took, reported separately since copies
+// are bound by I/O and resizes by CPU; a nil runStats records nothing
+type runStats struct {
+	mu                   sync.Mutex
+	copies               int
+	copyBytes            int64
+	copyTime             time.Duration
+	verified, mismatched int
+	resizes              int
+	resizeTime           time.Duration
+	bytesRead            int64
+	bytesWritten         int64
+	calls                map[string]int // requests to remote services, by service
+	remote               map[string]*transferred
+	scanned              int            // files the walk found, selected or not
+	skipped              map[reason]int // files or outputs left alone, by reason
+}
+
+// recordCopy - count a copy of n bytes that took d
+func (s *runStats) recordCopy(n int64, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.copies++
+	s.copyBytes += n
+	s.copyTime += d
+}
+
+// recordVerify - count a sampled copy that was checked, and whether it matched
+func (s *runStats) recordVerify(ok bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verified++
+	if !ok {
+		s.mismatched++
+	}
+}
+
+// recordResize - count a resized or converted image that took d
+func (s *runStats) recordResize(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resizes++
+	s.resizeTime += d
+}
+
+// print - output the copy and resize throughput and the resources used; times
+// are summed over the workers, so the rates are those of a single worker
+func (s *runStats) print() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rate := "-"
+	if secs := s.copyTime.Seconds(); secs > 0 {
+		rate = formatBytes(int64(float64(s.copyBytes)/secs)) + "/s"
+	}
+	copies := fmt.Sprintf("copies: %d files, %s in %v, %s per worker", s.copies, formatBytes(s.copyBytes), s.copyTime.Round(time.Millisecond), rate)
+	if s.verified > 0 {
+		copies += fmt.Sprintf(", %d verified, %d mismatched", s.verified, s.mismatched)
+	}
+	logs.info(logEntry{Action: "summary"}, "%s\n", copies)
+	each := "-"
+	if s.resizes > 0 {
+		each = (s.resizeTime / time.Duration(s.resizes)).Round(time.Millisecond).String()
+	}
+	logs.info(logEntry{Action: "summary"}, "resizes: %d files in %v, %s each\n", s.resizes, s.resizeTime.Round(time.Millisecond), each)
+	for _, line := range s.usageLines() {
+		logs.info(logEntry{Action: "summary"}, "%s\n", line)
+	}
+}
+
+// copyOutput - copy srcname to dstname unchanged, encrypted when --encrypt is given
+// and without its metadata when --strip-metadata is given,
+// and compare the hashes of a sample of --verify-copies percent of the copies
+func copyOutput(opts *options, srcname, dstname string) error {
+	start := time.Now()
+	if err := opts.trash.keep(outputName(opts, dstname)); err != nil {
+		return withReason(reasonWrite, err)
+	}
+	var n int64
+	var err error
+	if opts.stripMetadata {
+		n, err = copyStripped(opts, srcname, dstname)
+	} else if opts.encryptor != nil {
+		n, err = opts.encryptor.copy(srcname, dstname, opts.fileMode)
+	} else {
+		n, err = copy(srcname, dstname, opts.fileMode)
+	}
+	if err != nil {
+		return withReason(reasonWrite, err)
+	}
+	opts.stats.recordCopy(n, time.Since(start))
+	opts.stats.recordIO(fileSize(srcname), n)
+	opts.telemetry.recordCopy()
+
+	// encrypted and stripped copies differ from their source by design
+	if opts.encryptor != nil || opts.stripMetadata || opts.verifyCopies <= 0 || randomFloat()*100 >= opts.verifyCopies {
+		return nil
+	}
+	want, err := hashFile(opts.hashAlgorithm, srcname)
+	if err != nil {
+		return withReason(reasonStat, err)
+	}
+	got, err := hashFile(opts.hashAlgorithm, dstname)
+	if err != nil {
+		return withReason(reasonWrite, err)
+	}
+	opts.stats.recordVerify(got == want)
+	if got != want {
+		return withReason(reasonCopyMismatch, fmt.Errorf("%s does not match its source %s", dstname, srcname))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// diffEntry - a destination file that a batch run would write, and its source
+type diffEntry struct {
+	dest, source string
+}
+
+// runDiff - the --diff mode, compare the source and destination directories without
+// changing either and list, like rsync's itemized changes, the destination files a
+// batch run would create (+) or change (~) and those no source leads to (-); the
+// outputs of --encrypt are only checked for existence.  Return 1 if there are any.
+func runDiff(opts *options, encrypted bool) int {
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+	conflicts := newConflictResolver(opts.conflictStrategy)
+
+	// overwrite and newest-wins reuse a name; only the last source given it is written
+	byDest := make(map[string]*diffEntry)
+	var entries []*diffEntry
+	for path := range paths {
+		fileOpts, err := opts.forFile(path)
+		if err != nil {
+			log.Printf("Unable to apply profile to %s: %v\n", path, err)
+			continue
+		}
+		destFile, release, ok := conflicts.resolve(destName(fileOpts, path), path)
+		release()
+		if !ok {
+			continue
+		}
+		if encrypted {
+			destFile += encSuffix
+		}
+		key := strings.ToLower(destFile)
+		if e, ok := byDest[key]; ok {
+			e.source = path
+			continue
+		}
+		e := &diffEntry{dest: destFile, source: path}
+		byDest[key] = e
+		entries = append(entries, e)
+	}
+	if err := <-errc; err != nil {
+		log.Printf("Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+
+	var lines []string
+	missing, changed, current, orphans := 0, 0, 0, 0
+	for _, e := range entries {
+		switch {
+		case !fileExists(e.dest):
+			missing++
+			lines = append(lines, fmt.Sprintf("+ %s (from %s)", e.dest, e.source))
+		case encrypted:
+			current++
+		case !isCompliant(opts, e.dest, e.source):
+			changed++
+			lines = append(lines, fmt.Sprintf("~ %s (from %s)", e.dest, e.source))
+		default:
+			current++
+		}
+	}
+
+	err := filepath.Walk(opts.dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == trashDir {
+			return filepath.SkipDir
+		}
+		name := path
+		if encrypted {
+			name = strings.TrimSuffix(path, encSuffix)
+		}
+		if !info.Mode().IsRegular() || len(resizer.FormatFromExt(name)) == 0 {
+			return nil
+		}
+		if _, ok := byDest[strings.ToLower(path)]; !ok {
+			orphans++
+			lines = append(lines, fmt.Sprintf("- %s", path))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error walking %s: %v\n", opts.dest, err)
+		return 1
+	}
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	fmt.Println(equalsLine)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Printf("diff: %d missing, %d would change, %d orphaned, %d up to date\n", missing, changed, orphans, current)
+	if missing+changed+orphans > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// sourceFormat - return the format of srcname, from its content when
+// --fix-extensions is given and otherwise from its extension
+func sourceFormat(opts *options, srcname string) string {
+	return opts.imageResizer(nil).SourceFormat(srcname)
+}
+
+// defaultAlpha - the default for --alpha
+const defaultAlpha = "flatten:#ffffff"
+
+// defaultMaxMegapixels - the default for --max-megapixels; decoding takes at least 4 bytes per pixel
+const defaultMaxMegapixels = 100
+
+// tooLarge - return err tagged with ERR_TOO_LARGE when the resizer refused the image
+func tooLarge(err error) error {
+	if e, ok := err.(*resizer.TooLargeError); ok {
+		return withReason(reasonTooLarge, fmt.Errorf("%v by --max-megapixels", e))
+	}
+	return err
+}
+
+// decodeImage - read and decode the image file at path, also returning its format,
+// refusing images of more than maxPixels pixels
+func decodeImage(path string, maxPixels int) (image.Image, string, error) {
+	img, format, err := resizer.DecodeFile(path, maxPixels)
+	return img, format, tooLarge(err)
+}
+
+// decodeReader - decode the image in r like decodeImage
+func decodeReader(r io.Reader, maxPixels int) (image.Image, string, error) {
+	img, format, err := resizer.DecodeReader(r, maxPixels)
+	return img, format, tooLarge(err)
+}
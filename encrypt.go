@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encrypted file layout: encMagic, a salt for the key and a nonce, followed by the
+// AES-256-GCM sealed image; the magic and salt are authenticated along with it
+const (
+	encMagic     = "PIRENC1\x00"
+	encSaltSize  = 16
+	encSuffix    = ".enc"
+	manifestName = "manifest.jsonl"
+)
+
+// scrypt parameters for deriving the key from a passphrase
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// manifestEntry - one line of the manifest written next to encrypted outputs, so
+// that an archive can be checked without decrypting it and verified after
+type manifestEntry struct {
+	File   string `json:"file"`
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"` // of the decrypted image
+}
+
+// encryptor - encrypts outputs with a key derived once per run from a passphrase
+type encryptor struct {
+	aead cipher.AEAD
+	salt []byte
+	dest string
+
+	mu       sync.Mutex
+	manifest *os.File
+}
+
+// readPassphrase - return the passphrase in the first line of path
+func readPassphrase(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	passphrase := strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r")
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%s: empty passphrase", path)
+	}
+	return []byte(passphrase), nil
+}
+
+// newAEAD - return AES-256-GCM keyed from passphrase and salt
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newEncryptor - return an encryptor using the passphrase in passphraseFile whose
+// manifest is appended to in dest
+func newEncryptor(passphraseFile, dest string, mode os.FileMode) (*encryptor, error) {
+	passphrase, err := readPassphrase(passphraseFile)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, encSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := os.OpenFile(filepath.Join(dest, manifestName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{aead: aead, salt: salt, dest: dest, manifest: manifest}, nil
+}
+
+// seal - return plaintext encrypted in the encrypted file layout
+func (e *encryptor) seal(plaintext []byte) ([]byte, error) {
+	header := append([]byte(encMagic), e.salt...)
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(append(header, nonce...), nonce, plaintext, header), nil
+}
+
+// sealWriter - collects an output in memory so that only its encrypted form is
+// ever written to the destination
+type sealWriter struct {
+	bytes.Buffer
+	e       *encryptor
+	dstname string
+	mode    os.FileMode
+}
+
+// create - return a writer for the output dstname, which is written as dstname.enc on Close
+func (e *encryptor) create(dstname string, mode os.FileMode) io.WriteCloser {
+	return &sealWriter{e: e, dstname: dstname, mode: mode}
+}
+
+// Close - encrypt and write the output and add it to the manifest
+func (w *sealWriter) Close() error {
+	sealed, err := w.e.seal(w.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(w.dstname+encSuffix, sealed, w.mode); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(w.e.dest, w.dstname)
+	if err != nil {
+		rel = filepath.Base(w.dstname)
+	}
+	sum := sha256.Sum256(w.Bytes())
+	entry, err := json.Marshal(manifestEntry{
+		File:   filepath.ToSlash(rel) + encSuffix,
+		Name:   filepath.ToSlash(rel),
+		Size:   w.Len(),
+		SHA256: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return err
+	}
+	w.e.mu.Lock()
+	defer w.e.mu.Unlock()
+	_, err = w.e.manifest.Write(append(entry, '\n'))
+	return err
+}
+
+// openDest - set up the encryptor of --encrypt and the trash of --trash for
+// opts.dest, which must exist; every destination written to needs its own, as
+// the manifest and trash are kept in it
+func (opts *options) openDest() error {
+	if len(opts.passphraseFile) > 0 {
+		var err error
+		if opts.encryptor, err = newEncryptor(opts.passphraseFile, opts.dest, opts.fileMode); err != nil {
+			return err
+		}
+	}
+	if opts.trashOutputs {
+		opts.trash = newTrash(opts.dest, opts.trashDays, opts.dirMode)
+	}
+	return nil
+}
+
+// close - close the manifest of e, once the run writing to its destination is over
+func (e *encryptor) close() error {
+	if e == nil {
+		return nil
+	}
+	return e.manifest.Close()
+}
+
+// createOutput - open dstname for writing, encrypted when --encrypt is given
+func createOutput(opts *options, dstname string) (io.WriteCloser, error) {
+	if err := opts.trash.keep(outputName(opts, dstname)); err != nil {
+		return nil, err
+	}
+	if opts.encryptor != nil {
+		return opts.encryptor.create(dstname, opts.fileMode), nil
+	}
+	return os.OpenFile(dstname, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.fileMode)
+}
+
+// copy - copy srcname to dstname unchanged but encrypted, like copy()
+func (e *encryptor) copy(srcname, dstname string, mode os.FileMode) (int64, error) {
+	source, err := os.Open(srcname)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	w := e.create(dstname, mode)
+	n, err := io.Copy(w, source)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// outputName - return the name that the output dstname is written to on disk
+func outputName(opts *options, dstname string) string {
+	if opts.encryptor != nil {
+		return dstname + encSuffix
+	}
+	return dstname
+}
+
+// openSealed - decrypt data in the encrypted file layout with passphrase
+func openSealed(data, passphrase []byte) ([]byte, error) {
+	headerSize := len(encMagic) + encSaltSize
+	if len(data) < headerSize || string(data[:len(encMagic)]) != encMagic {
+		return nil, errors.New("not an encrypted photo")
+	}
+	header := data[:headerSize]
+	aead, err := newAEAD(passphrase, header[len(encMagic):])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize+aead.NonceSize() {
+		return nil, errors.New("truncated encrypted photo")
+	}
+	nonce := data[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[headerSize+aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or damaged file")
+	}
+	return plaintext, nil
+}
+
+// runDecrypt - the decrypt subcommand, write the decrypted form of each .enc file
+// given next to it, or into the directory given by -d
+func runDecrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	passphraseFile := fs.String("passphrase-file", "", "file whose first line is the passphrase given to --encrypt")
+	dest := fs.String("d", "", "directory to write the decrypted photos to. Default: next to each encrypted photo")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s decrypt --passphrase-file FILE [-d DIR] photo.jpg.enc...\n", pgmName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(*passphraseFile) == 0 || fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		log.Fatalf("Unable to read passphrase: %v\n", err)
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		if !strings.HasSuffix(name, encSuffix) {
+			log.Printf("Unable to decrypt %s: name does not end in %s\n", name, encSuffix)
+			status = 1
+			continue
+		}
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			data, err = openSealed(data, passphrase)
+		}
+		if err != nil {
+			log.Printf("Unable to decrypt %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		out := strings.TrimSuffix(name, encSuffix)
+		if len(*dest) > 0 {
+			out = filepath.Join(*dest, filepath.Base(out))
+		}
+		if err := ioutil.WriteFile(out, data, 0600); err != nil {
+			log.Printf("Unable to write %s: %v\n", out, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("decrypted: %s\n", out)
+	}
+	return status
+}
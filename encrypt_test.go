@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// newTestEncryptor - return an encryptor with passphrase whose manifest is in a
+// temporary directory
+func newTestEncryptor(t *testing.T, passphrase string) *encryptor {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(file, []byte(passphrase+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := newEncryptor(file, dir, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.close() })
+	return e
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	e := newTestEncryptor(t, "correct horse")
+	for _, plaintext := range [][]byte{{}, []byte("x"), bytes.Repeat([]byte("photo"), 10000)} {
+		sealed, err := e.seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(sealed, []byte(encMagic)) {
+			t.Errorf("sealed %d bytes do not start with the magic", len(plaintext))
+		}
+		if len(plaintext) > 0 && bytes.Contains(sealed, plaintext) {
+			t.Errorf("sealed %d bytes contain the plaintext", len(plaintext))
+		}
+		got, err := openSealed(sealed, []byte("correct horse"))
+		if err != nil {
+			t.Errorf("openSealed of %d bytes: %v", len(plaintext), err)
+			continue
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("openSealed returned %d bytes, want the %d sealed", len(got), len(plaintext))
+		}
+	}
+
+	// every output gets its own nonce
+	first, _ := e.seal([]byte("photo"))
+	second, _ := e.seal([]byte("photo"))
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same plaintext twice gave the same output")
+	}
+}
+
+func TestOpenSealedRefusesDamage(t *testing.T) {
+	e := newTestEncryptor(t, "correct horse")
+	sealed, err := e.seal([]byte("a photo of someone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerSize := len(encMagic) + encSaltSize
+	flipped := func(i int) []byte {
+		data := append([]byte{}, sealed...)
+		data[i] ^= 0x01
+		return data
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+	}{
+		{"wrong passphrase", sealed, "correct horse battery"},
+		{"empty", nil, "correct horse"},
+		{"magic only", sealed[:len(encMagic)], "correct horse"},
+		{"no nonce", sealed[:headerSize], "correct horse"},
+		{"part of the nonce", sealed[:headerSize+4], "correct horse"},
+		{"no tag", sealed[:headerSize+e.aead.NonceSize()], "correct horse"},
+		{"last byte missing", sealed[:len(sealed)-1], "correct horse"},
+		{"other magic", flipped(0), "correct horse"},
+		{"other salt", flipped(len(encMagic)), "correct horse"},
+		{"other ciphertext", flipped(len(sealed) - 1), "correct horse"},
+		{"not encrypted", []byte("\xff\xd8\xff\xe0 a plain JPEG, long enough to have a header"), "correct horse"},
+	}
+	for _, tt := range tests {
+		if got, err := openSealed(tt.data, []byte(tt.passphrase)); err == nil {
+			t.Errorf("%s: openSealed returned %q, want an error", tt.name, got)
+		}
+	}
+}
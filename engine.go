@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// validEngine - return true if engine is one of resizer.Engines
+func validEngine(engine string) bool {
+	return contains(resizer.Engines, engine)
+}
+
+// validScaler - return true if scaler is one of resizer.Scalers
+func validScaler(scaler string) bool {
+	return contains(resizer.Scalers, scaler)
+}
+
+// validSeams - return true if seams is one of resizer.SeamDirections
+func validSeams(seams string) bool {
+	return contains(resizer.SeamDirections, seams)
+}
+
+// contains - return true if s is one of values
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// imageResizer - return the Resizer that writes images with the settings of opts,
+// resizing them with p; p may be nil for only its Plan and Target
+func (opts *options) imageResizer(p *caire.Processor) *resizer.Resizer {
+	return &resizer.Resizer{
+		Options: resizer.Options{
+			Size:            opts.size,
+			Format:          opts.format,
+			DPI:             opts.dpi,
+			Quality:         opts.quality,
+			Background:      opts.background,
+			MaxPixels:       opts.maxPixels,
+			PreserveColor:   opts.preserveColor,
+			FixExtensions:   opts.fixExtensions,
+			Engine:          opts.engine,
+			Scaler:          opts.scaler,
+			Seams:           opts.seams,
+			MaxCarve:        opts.maxCarve,
+			AspectTolerance: opts.aspectTolerance,
+			CropMargin:      opts.cropMargin,
+			Square:          opts.square,
+			Aspect:          opts.aspect,
+			Alpha:           opts.alpha,
+		},
+		Processor: p,
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// defaultSampleSize - the default for --sample
+const defaultSampleSize = 20
+
+// formatBytes - return n as a human readable size such as 1.5 GB
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// runEstimate - the estimate subcommand, process a sample of the source files
+// into a temporary directory and extrapolate how long the whole batch would take
+// and how much it would write
+func runEstimate(opts *options, p *caire.Processor, sampleSize int) int {
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+	var files []string
+	var totalBytes int64
+	for path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			totalBytes += info.Size()
+		}
+		files = append(files, path)
+	}
+	if err := <-errc; err != nil {
+		logs.error(logEntry{Action: "walk", File: opts.source}.withErr(err), "Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Printf("estimate: no files to process\n")
+		return 0
+	}
+
+	// take evenly spaced files so that every part of the tree is represented
+	if sampleSize > len(files) {
+		sampleSize = len(files)
+	}
+	sample := make([]string, sampleSize)
+	for i := range sample {
+		sample[i] = files[i*len(files)/sampleSize]
+	}
+
+	tmp, err := newScratch(opts.tmpDir)
+	if err != nil {
+		logs.error(logEntry{Action: "estimate"}.withErr(err), "Unable to create temporary directory: %v\n", err)
+		return 1
+	}
+	defer tmp.cleanup()
+	// the workers' directories are created up front, so that a failure ends the
+	// run here rather than in a worker
+	dirs := make([]string, opts.numWorkers)
+	for i := range dirs {
+		if dirs[i], err = tmp.worker(i); err != nil {
+			logs.error(logEntry{Action: "estimate"}.withErr(err), "Unable to create temporary directory: %v\n", err)
+			return 1
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var sampleIn, sampleOut int64
+	work := make(chan int)
+	start := time.Now()
+	wg.Add(opts.numWorkers)
+	for i := 0; i < opts.numWorkers; i++ {
+		go func(dir string) {
+			defer wg.Done()
+			sampleOpts := *opts
+			sampleOpts.dest = dir
+			p := resizer.CloneProcessor(p)
+			for n := range work {
+				srcname := sample[n]
+				fileOpts, err := sampleOpts.forFile(srcname)
+				if err != nil {
+					logs.error(logEntry{Action: "profile", File: srcname}.withErr(err), "Unable to apply profile to %s: %v\n", srcname, err)
+					continue
+				}
+				// number the outputs so that sources sharing a name do not overwrite each other
+				dstname := filepath.Join(dir, fmt.Sprintf("%d_%s", n, filepath.Base(destName(fileOpts, srcname))))
+				if err := process(p, fileOpts, dstname, srcname); err != nil {
+					logs.error(logEntry{Action: "estimate", File: srcname, Dest: dstname}.withErr(err), "Unable to process %s: %v\n", srcname, err)
+				}
+				mu.Lock()
+				if info, err := os.Stat(srcname); err == nil {
+					sampleIn += info.Size()
+				}
+				if info, err := os.Stat(dstname); err == nil {
+					sampleOut += info.Size()
+				}
+				mu.Unlock()
+				os.Remove(dstname)
+			}
+		}(dirs[i])
+	}
+	for n := range sample {
+		work <- n
+	}
+	close(work)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	perFile := elapsed / time.Duration(sampleSize)
+	runtime := perFile * time.Duration(len(files))
+	var output int64
+	if sampleIn > 0 {
+		output = int64(float64(totalBytes) * float64(sampleOut) / float64(sampleIn))
+	}
+	fmt.Println(equalsLine)
+	fmt.Printf("files:             %d, %s\n", len(files), formatBytes(totalBytes))
+	fmt.Printf("sampled:           %d files in %v with %d threads\n", sampleSize, elapsed.Round(time.Millisecond), opts.numWorkers)
+	fmt.Printf("estimated runtime: %v\n", runtime.Round(time.Second))
+	fmt.Printf("estimated output:  %s\n", formatBytes(output))
+	return 0
+}
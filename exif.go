@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// EXIF tags used by exifDate, exifText and gen-testdata
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagSoftware         = 0x0131
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// exifTimeLayout - the layout of EXIF date and time values
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// errNoExif - the image has no EXIF data or no date in it
+var errNoExif = errors.New("no EXIF date")
+
+// readExif - return the TIFF structure in the APP1 Exif segment of a JPEG file
+func readExif(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xff, 0xd8} {
+		return nil, errNoExif
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xff {
+			return nil, errNoExif
+		}
+		// the image data starts at SOS, after which no more metadata follows
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, errNoExif
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return nil, errNoExif
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, errNoExif
+		}
+		if marker[1] == 0xe1 && strings.HasPrefix(string(segment), "Exif\x00\x00") {
+			return segment[6:], nil
+		}
+	}
+}
+
+// exifTags - the entries of the IFDs of a TIFF structure from readExif
+type exifTags struct {
+	tiff  []byte
+	order binary.ByteOrder
+}
+
+// parseExif - return the EXIF data of the JPEG photo at path
+func parseExif(path string) (*exifTags, error) {
+	tiff, err := readExif(path)
+	if err != nil || len(tiff) < 8 {
+		return nil, errNoExif
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return &exifTags{tiff, binary.LittleEndian}, nil
+	case "MM":
+		return &exifTags{tiff, binary.BigEndian}, nil
+	}
+	return nil, errNoExif
+}
+
+// ifd - return the value or offset of each entry of the IFD at offset
+func (x *exifTags) ifd(offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int(offset)+2 > len(x.tiff) {
+		return entries
+	}
+	n := int(x.order.Uint16(x.tiff[offset:]))
+	for i := 0; i < n; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(x.tiff) {
+			break
+		}
+		entries[x.order.Uint16(x.tiff[start:])] = x.tiff[start : start+12]
+	}
+	return entries
+}
+
+// ifd0 - return the entries of the first IFD, which describes the image
+func (x *exifTags) ifd0() map[uint16][]byte {
+	return x.ifd(x.order.Uint32(x.tiff[4:]))
+}
+
+// ascii - return the string value of an entry
+func (x *exifTags) ascii(entry []byte) string {
+	count := x.order.Uint32(entry[4:])
+	value := entry[8:12]
+	if count > 4 {
+		offset := x.order.Uint32(entry[8:])
+		if uint64(offset)+uint64(count) > uint64(len(x.tiff)) {
+			return ""
+		}
+		value = x.tiff[offset : offset+count]
+	}
+	return strings.TrimRight(string(value), "\x00 ")
+}
+
+// exifDate - return when a JPEG photo was taken according to its EXIF data,
+// preferring DateTimeOriginal over the DateTime the file was last edited
+func exifDate(path string) (time.Time, error) {
+	x, err := parseExif(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ifd0 := x.ifd0()
+	candidates := []string{}
+	if entry, ok := ifd0[exifTagExifIFD]; ok {
+		if original, ok := x.ifd(x.order.Uint32(entry[8:]))[exifTagDateTimeOriginal]; ok {
+			candidates = append(candidates, x.ascii(original))
+		}
+	}
+	if entry, ok := ifd0[exifTagDateTime]; ok {
+		candidates = append(candidates, x.ascii(entry))
+	}
+	for _, value := range candidates {
+		if t, err := time.ParseInLocation(exifTimeLayout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errNoExif
+}
+
+// exifText - return the text values of the given IFD0 tags of a JPEG photo,
+// such as its camera model; tags it does not have are left out
+func exifText(path string, tags ...uint16) map[uint16]string {
+	values := make(map[uint16]string)
+	x, err := parseExif(path)
+	if err != nil {
+		return values
+	}
+	ifd0 := x.ifd0()
+	for _, tag := range tags {
+		if entry, ok := ifd0[tag]; ok {
+			values[tag] = x.ascii(entry)
+		}
+	}
+	return values
+}
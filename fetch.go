@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// fetchBackoff - how long to wait before the first retry of a download; it
+// doubles for every retry after that
+const fetchBackoff = time.Second
+
+// urlFetcher - downloads the images of --urls, up to workers at a time, so that
+// they can be processed like the files of a source directory
+type urlFetcher struct {
+	urls    []string
+	workers int
+	retries int // how many times a download that failed transiently is tried again
+	client  *http.Client
+	stats   *runStats
+
+	mu    sync.Mutex
+	taken map[string]bool // local names already given to a URL
+}
+
+// readURLList - return the http and https URLs listed one per line in name, or
+// stdin when name is -, ignoring blank lines and lines starting with #
+func readURLList(name string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return nil, fmt.Errorf("%s:%d: not an http or https URL: %s", name, n, line)
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no URLs", name)
+	}
+	return urls, nil
+}
+
+// newURLFetcher - return a fetcher of urls whose requests each take at most
+// timeout, counting them in stats
+func newURLFetcher(urls []string, workers, retries int, timeout time.Duration, stats *runStats) *urlFetcher {
+	if workers < 1 {
+		workers = 1
+	}
+	return &urlFetcher{urls: urls, workers: workers, retries: retries, client: &http.Client{Timeout: timeout}, stats: stats, taken: make(map[string]bool)}
+}
+
+// localName - return where under root to download u, its host name and path, with
+// a number added when another URL, such as one with another query, took it
+func (f *urlFetcher) localName(root string, u *url.URL) string {
+	rel := strings.TrimPrefix(path.Clean("/"+u.Hostname()+"/"+u.Path), "/")
+	if !strings.Contains(rel, "/") {
+		rel += "/index"
+	}
+	base := filepath.Join(root, filepath.FromSlash(rel))
+	ext := filepath.Ext(base)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := base
+	for n := 2; f.taken[name]; n++ {
+		name = strings.TrimSuffix(base, ext) + "_" + strconv.Itoa(n) + ext
+	}
+	f.taken[name] = true
+	return name
+}
+
+// transient - return true if a download that failed with err, or with the
+// status code when err is nil, may succeed when it is tried again
+func transient(status int, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// get - download rawURL to local, trying again after a growing pause when it
+// fails transiently, and return the Last-Modified time of the response
+func (f *urlFetcher) get(rawURL, local string) (time.Time, error) {
+	wait := fetchBackoff
+	for attempt := 0; ; attempt++ {
+		modTime, status, err := f.getOnce(rawURL, local)
+		if err == nil && status == http.StatusOK {
+			return modTime, nil
+		}
+		retry := attempt < f.retries && transient(status, err)
+		if err == nil {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+		}
+		if !retry {
+			os.Remove(local)
+			return time.Time{}, err
+		}
+		logs.warn(logEntry{Action: "fetch", File: rawURL}.withErr(err), "Unable to download %s, trying again in %s: %v\n", rawURL, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// getOnce - send one request for rawURL and write the body of a 200 response to local
+func (f *urlFetcher) getOnce(rawURL, local string) (time.Time, int, error) {
+	f.stats.recordCall("fetch")
+	resp, err := f.client.Get(rawURL)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, resp.StatusCode, nil
+	}
+	out, err := os.Create(local)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return time.Time{}, 0, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return modTime, resp.StatusCode, out.Close()
+}
+
+// fetch - download rawURL under root and return its local path, named with the
+// extension of its format when the URL's name has none that is supported
+func (f *urlFetcher) fetch(root, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	local := f.localName(root, u)
+	if err := os.MkdirAll(filepath.Dir(local), 0700); err != nil {
+		return "", err
+	}
+	modTime, err := f.get(rawURL, local)
+	if err != nil {
+		return "", err
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(local, modTime, modTime)
+	}
+	if len(resizer.FormatFromExt(local)) == 0 {
+		if format := resizer.ContentFormat(local); len(format) > 0 {
+			named := resizer.ReplaceExt(local, format)
+			if err := os.Rename(local, named); err != nil {
+				return "", err
+			}
+			local = named
+		}
+	}
+	return local, nil
+}
+
+// fetchURLs - download the --urls with the fetcher's workers in place of walking
+// the source directory, and send the local path of each download that the filter
+// accepts; the walk fails once every URL has been tried if any could not be
+func (r *remoteSync) fetchURLs(done <-chan struct{}, filter *fileFilter) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	urls := make(chan string)
+	go func() {
+		defer close(urls)
+		for _, u := range r.urls.urls {
+			select {
+			case urls <- u:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	var fatal error
+	for i := 0; i < r.urls.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range urls {
+				local, err := r.urls.fetch(r.localSource, u)
+				if err != nil {
+					err = applyPolicy("source", withReason(reasonFetch, err))
+					logs.error(logEntry{Action: "fetch", File: u}.withErr(err), "Unable to download %s: %v\n", u, err)
+					mu.Lock()
+					failed++
+					if fatal == nil && isFatal(err) {
+						fatal = err
+					}
+					mu.Unlock()
+					if isFatal(err) {
+						return
+					}
+					continue
+				}
+				info, err := os.Stat(local)
+				if err != nil || !filter.accepts(local, info) {
+					os.Remove(local)
+					continue
+				}
+				select {
+				case paths <- local:
+				case <-done:
+					os.Remove(local)
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(paths)
+		if fatal != nil {
+			errc <- fatal
+			return
+		}
+		if failed > 0 {
+			errc <- fmt.Errorf("%d of %d URLs could not be downloaded", failed, len(r.urls.urls))
+			return
+		}
+		errc <- nil
+	}()
+	return paths, errc
+}
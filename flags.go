@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// flagAliases maps an alias flag name to the name of the flag it shares a value with
+var flagAliases = make(map[string]string)
+
+// aliasFlag - register alias as another name for the already defined flag named name
+func aliasFlag(name, alias string) {
+	f := flag.Lookup(name)
+	if f == nil {
+		panic("aliasFlag: undefined flag: " + name)
+	}
+	flag.Var(f.Value, alias, f.Usage)
+	flagAliases[alias] = name
+}
+
+// flagDisplayName - return a flag name with one dash for short flags and two for long ones
+func flagDisplayName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// printFlags - output all flags, listing each flag's aliases on the same line
+func printFlags(w io.Writer) {
+	aliases := make(map[string][]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if name, ok := flagAliases[f.Name]; ok {
+			aliases[name] = append(aliases[name], flagDisplayName(f.Name))
+		}
+	})
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := flagAliases[f.Name]; ok {
+			return
+		}
+		names := append([]string{flagDisplayName(f.Name)}, aliases[f.Name]...)
+		line := "  " + strings.Join(names, ", ")
+		typeName, usage := flag.UnquoteUsage(f)
+		if len(typeName) > 0 {
+			line += " " + typeName
+		}
+		line += "\n    \t" + usage
+		if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "0s" && f.DefValue != "false" {
+			line += fmt.Sprintf(" (default: %q)", f.DefValue)
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// isFlagSet - return true if the named flag, or one of its aliases, was given on the command line
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name || flagAliases[f.Name] == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// stringList - a flag that can be given more than once, collecting every value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// photoAge - a maximum age of a photo in calendar years, months and days
+type photoAge struct {
+	years, months, days int
+}
+
+// parsePhotoAge - parse an age such as 5y, 18m, 6w or 90d
+func parsePhotoAge(s string) (photoAge, error) {
+	invalid := fmt.Errorf("invalid photo age: %s", s)
+	if len(s) < 2 {
+		return photoAge{}, invalid
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return photoAge{}, invalid
+	}
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "y":
+		return photoAge{years: n}, nil
+	case "m":
+		return photoAge{months: n}, nil
+	case "w":
+		return photoAge{days: n * 7}, nil
+	case "d":
+		return photoAge{days: n}, nil
+	}
+	return photoAge{}, invalid
+}
+
+// cutoff - return the time before which a photo is older than a
+func (a photoAge) cutoff(now time.Time) time.Time {
+	return now.AddDate(-a.years, -a.months, -a.days)
+}
+
+// stalePhoto - a photo that is older than the freshness policy allows
+type stalePhoto struct {
+	path  string
+	taken time.Time
+	from  string // exif or mtime
+}
+
+// photoDate - return when the photo at path was taken, from its EXIF data or
+// otherwise its modification time
+func photoDate(path string, info os.FileInfo) (time.Time, string) {
+	if t, err := exifDate(path); err == nil {
+		return t, "exif"
+	}
+	return info.ModTime(), "mtime"
+}
+
+// moveFile - move src to dst, copying when they are on different file systems
+func moveFile(src, dst string, mode os.FileMode) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if _, err := copy(src, dst, mode); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// runFreshness - the freshness subcommand, list the photos in the source directory
+// that are older than maxAge and move them to the recapture directory, if given
+func runFreshness(opts *options, maxAge photoAge, recapture string) int {
+	cutoff := maxAge.cutoff(clock())
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, 0)
+	var stale []stalePhoto
+	checked := 0
+	for path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		checked++
+		if taken, from := photoDate(path, info); taken.Before(cutoff) {
+			stale = append(stale, stalePhoto{path: path, taken: taken, from: from})
+		}
+	}
+	if err := <-errc; err != nil {
+		logs.error(logEntry{Action: "walk", File: opts.source}.withErr(err), "Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+
+	sort.Slice(stale, func(i, j int) bool { return stale[i].taken.Before(stale[j].taken) })
+	fmt.Println(equalsLine)
+	for _, s := range stale {
+		fmt.Printf("%-16s %s (%s)  %s\n", photoID(s.path), s.taken.Format("2006-01-02"), s.from, s.path)
+		if len(recapture) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(opts.source, s.path)
+		if err != nil {
+			rel = filepath.Base(s.path)
+		}
+		dst := filepath.Join(recapture, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), opts.dirMode); err != nil {
+			logs.error(logEntry{Action: "recapture", File: s.path, Dest: dst, Reason: reasonMkdir}.withErr(err), "[%s] Unable to create recapture directory: %v\n", reasonMkdir, err)
+			continue
+		}
+		if err := moveFile(s.path, dst, opts.fileMode); err != nil {
+			logs.error(logEntry{Action: "recapture", File: s.path, Dest: dst, Reason: reasonWrite}.withErr(err), "[%s] Unable to move %s to the recapture queue: %v\n", reasonWrite, s.path, err)
+		}
+	}
+	fmt.Printf("freshness: %d photos checked, %d taken before %s\n", checked, len(stale), cutoff.Format("2006-01-02"))
+	if len(stale) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// backgroundTolerance - how far, averaged over the color channels, the background may be from the wanted color
+const backgroundTolerance = 40
+
+// backgroundUniformity - the max standard deviation of the background's luminance
+const backgroundUniformity = 20
+
+// minSharpness - the min variance of the Laplacian of the face, blurrier images have lower values
+const minSharpness = 100
+
+// verdict - the result of validating a single file in --gate mode, written as one line of JSON
+type verdict struct {
+	Path     string   `json:"path"`
+	Verdict  string   `json:"verdict"`
+	Reasons  []reason `json:"reasons,omitempty"`
+	Messages []string `json:"messages,omitempty"`
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	Faces    int      `json:"faces"`
+}
+
+// checkDimensions - return true if a w x h image satisfies size; an exact
+// width x height is required when both are given without --fit
+func checkDimensions(size resizer.Size, w, h int) bool {
+	switch {
+	case size.Percent > 0:
+		return true
+	case size.Width > 0 && size.Height > 0 && !size.Fit:
+		return w == size.Width && h == size.Height
+	}
+	_, _, needsResizing := size.Target(w, h)
+	return !needsResizing
+}
+
+// checkBackground - return true if the top corners of img, where the background
+// shows on either side of the head, are uniform and close to the wanted color
+func checkBackground(img image.Image, want color.Color) bool {
+	b := img.Bounds()
+	cw, ch := b.Dx()/5, b.Dy()/5
+	corners := []image.Rectangle{
+		image.Rect(b.Min.X, b.Min.Y, b.Min.X+cw, b.Min.Y+ch),
+		image.Rect(b.Max.X-cw, b.Min.Y, b.Max.X, b.Min.Y+ch),
+	}
+
+	var n, sumR, sumG, sumB, sumL, sumL2 float64
+	for _, corner := range corners {
+		for y := corner.Min.Y; y < corner.Max.Y; y++ {
+			for x := corner.Min.X; x < corner.Max.X; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				fr, fg, fb := float64(r>>8), float64(g>>8), float64(b>>8)
+				l := 0.299*fr + 0.587*fg + 0.114*fb
+				n++
+				sumR, sumG, sumB = sumR+fr, sumG+fg, sumB+fb
+				sumL, sumL2 = sumL+l, sumL2+l*l
+			}
+		}
+	}
+	if n == 0 {
+		return false
+	}
+
+	wr, wg, wb, _ := want.RGBA()
+	distance := (math.Abs(sumR/n-float64(wr>>8)) + math.Abs(sumG/n-float64(wg>>8)) + math.Abs(sumB/n-float64(wb>>8))) / 3
+	mean := sumL / n
+	deviation := math.Sqrt(math.Max(sumL2/n-mean*mean, 0))
+	return distance <= backgroundTolerance && deviation <= backgroundUniformity
+}
+
+// sharpness - return the variance of the Laplacian of img's luminance within r
+func sharpness(img image.Image, r image.Rectangle) float64 {
+	r = r.Intersect(img.Bounds())
+	if r.Dx() < 3 || r.Dy() < 3 {
+		return 0
+	}
+	gray := resizer.Grayscale(resizer.ToNRGBA(img).SubImage(r))
+	w, h := r.Dx(), r.Dy()
+
+	var n, sum, sum2 float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := 4*float64(gray[i]) - float64(gray[i-1]) - float64(gray[i+1]) - float64(gray[i-w]) - float64(gray[i+w])
+			n++
+			sum, sum2 = sum+l, sum2+l*l
+		}
+	}
+	mean := sum / n
+	return sum2/n - mean*mean
+}
+
+// checkFile - validate a single image against the size, format and background
+// in opts and require exactly one face that is in focus
+func checkFile(path string, opts *options, fd *resizer.FaceDetector) verdict {
+	v := verdict{Path: path, Verdict: "pass"}
+	img, format, err := decodeImage(path, opts.maxPixels)
+	if err != nil {
+		code := reasonOf(err)
+		if code != reasonTooLarge {
+			code = reasonDecode
+		}
+		v.Verdict = "fail"
+		v.Reasons = []reason{code}
+		v.Messages = []string{opts.messages.message(opts.lang, code)}
+		return v
+	}
+	v.Width, v.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	if len(opts.format) > 0 && format != opts.format {
+		v.Reasons = append(v.Reasons, reasonFormat)
+	}
+	if !checkDimensions(opts.size, v.Width, v.Height) {
+		v.Reasons = append(v.Reasons, reasonDimensions)
+	}
+	faces := fd.Detect(img)
+	v.Faces = len(faces)
+	if v.Faces == 0 {
+		v.Reasons = append(v.Reasons, reasonNoFace)
+	} else if v.Faces > 1 {
+		v.Reasons = append(v.Reasons, reasonMultipleFaces)
+	} else if sharpness(img, faces[0].Add(img.Bounds().Min)) < minSharpness {
+		v.Reasons = append(v.Reasons, reasonBlurry)
+	}
+	if v.Faces == 1 && opts.headMin > 0 {
+		if head := headFraction(faces[0], v.Height); head < opts.headMin || head > opts.headMax {
+			v.Reasons = append(v.Reasons, reasonHeadSize)
+		}
+	}
+	if opts.background != nil && !checkBackground(img, opts.background) {
+		v.Reasons = append(v.Reasons, reasonBackground)
+	}
+
+	if len(v.Reasons) > 0 {
+		v.Verdict = "fail"
+	}
+	for _, code := range v.Reasons {
+		v.Messages = append(v.Messages, opts.messages.message(opts.lang, code))
+	}
+	return v
+}
+
+// runGate - validate every matching file under the source directory, writing
+// one JSON verdict per line to output; return 1 if any file fails
+func runGate(opts *options, classifier, output string) int {
+	fd, err := resizer.NewFaceDetector(classifier)
+	if err != nil {
+		logs.error(logEntry{Action: "gate", File: classifier}.withErr(err), "Unable to load classification file: %s ; %s\n", classifier, err)
+		return 1
+	}
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.fileMode)
+	if err != nil {
+		logs.error(logEntry{Action: "gate", File: output}.withErr(err), "Unable to create verdict file: %v\n", err)
+		return 1
+	}
+	defer out.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := walkFiles(done, opts, opts.maxAge)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	enc := json.NewEncoder(out)
+	passed, failed := 0, 0
+	wg.Add(opts.numWorkers)
+	for i := 0; i < opts.numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				var v verdict
+				if fileOpts, err := opts.forFile(path); err != nil {
+					logs.error(logEntry{Action: "profile", File: path}.withErr(err), "Unable to apply profile to %s: %v\n", path, err)
+					v = verdict{Path: path, Verdict: "fail", Reasons: []reason{reasonProfile},
+						Messages: []string{opts.messages.message(opts.lang, reasonProfile)}}
+				} else {
+					v = checkFile(path, fileOpts, fd)
+				}
+				mu.Lock()
+				if err := enc.Encode(v); err != nil {
+					logs.error(logEntry{Action: "gate", File: path, Dest: output}.withErr(err), "Unable to write verdict for %s: %v\n", path, err)
+				}
+				if v.Verdict == "pass" {
+					passed++
+				} else {
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-errc; err != nil {
+		logs.error(logEntry{Action: "walk", File: opts.source}.withErr(err), "Error walking %s: %v\n", opts.source, err)
+		return 1
+	}
+	// the verdicts are only complete, and can only be signed, once the file is closed
+	if err := out.Close(); err != nil {
+		logs.error(logEntry{Action: "gate", File: output}.withErr(err), "Unable to write verdict file: %v\n", err)
+		return 1
+	}
+	if err := signFile(opts.signingKey, output, opts.fileMode); err != nil {
+		logs.error(logEntry{Action: "sign", File: output}.withErr(err), "Unable to sign verdict file: %v\n", err)
+		return 1
+	}
+	fmt.Printf("gate: %d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsBackoff - how long the GCS client waits between retries of transient errors
+var gcsBackoff = gax.Backoff{Initial: time.Second, Max: 30 * time.Second, Multiplier: 2}
+
+// newGCSClient - return a client with the service account key in the file
+// credentials, or with the application default credentials when it is empty.
+// Transient errors are retried with gcsBackoff for every call, uploads included,
+// since writing an output again is harmless.
+func newGCSClient(credentials string) (*storage.Client, error) {
+	var opts []option.ClientOption
+	if len(credentials) > 0 {
+		opts = append(opts, option.WithCredentialsFile(credentials))
+	}
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.SetRetry(storage.WithBackoff(gcsBackoff), storage.WithPolicy(storage.RetryAlways))
+	return client, nil
+}
+
+// gcsPageSize - the most objects one listing request returns, so that a request
+// is counted for each page the iterator fetches
+const gcsPageSize = 1000
+
+// gcsObject - return the remoteObject of attrs
+func gcsObject(attrs *storage.ObjectAttrs) remoteObject {
+	return remoteObject{size: attrs.Size, modTime: attrs.Updated}
+}
+
+// listGCS - call found with every object under the prefix of a GCS bucket.  The
+// top level of the prefix is listed first, and then the "subdirectories" it has
+// are listed in parallel by up to workers goroutines, as one listing returns at
+// most gcsPageSize objects per request.
+func (b *bucket) listGCS(ctx context.Context, workers int, found func(key string, info remoteObject) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var prefixes []string
+	it := b.gcs.Objects(ctx, &storage.Query{Prefix: b.prefix, Delimiter: "/"})
+	b.call()
+	for n := 1; ; n++ {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %v", b, err)
+		}
+		if n%gcsPageSize == 0 {
+			b.call()
+		}
+		if len(attrs.Prefix) > 0 {
+			prefixes = append(prefixes, attrs.Prefix)
+			continue
+		}
+		if err := found(attrs.Name, gcsObject(attrs)); err != nil {
+			return err
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	queue := make(chan string, len(prefixes))
+	for _, prefix := range prefixes {
+		queue <- prefix
+	}
+	close(queue)
+	if workers > len(prefixes) {
+		workers = len(prefixes)
+	}
+	objects := make(chan *storage.ObjectAttrs)
+	errc := make(chan error, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for prefix := range queue {
+				it := b.gcs.Objects(ctx, &storage.Query{Prefix: prefix})
+				b.call()
+				for n := 1; ; n++ {
+					attrs, err := it.Next()
+					if err == iterator.Done {
+						break
+					}
+					if err != nil {
+						errc <- fmt.Errorf("unable to list %s%s: %v", b, prefix[len(b.prefix):], err)
+						return
+					}
+					if n%gcsPageSize == 0 {
+						b.call()
+					}
+					select {
+					case objects <- attrs:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(objects)
+	}()
+	for attrs := range objects {
+		if err := found(attrs.Name, gcsObject(attrs)); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-errc:
+		return err
+	default:
+		return nil
+	}
+}
+
+// downloadGCS - write the object key of a GCS bucket to w
+func (b *bucket) downloadGCS(ctx context.Context, key string, w io.Writer) error {
+	b.call()
+	r, err := b.gcs.Object(key).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// uploadGCS - write the file f to the object key of a GCS bucket
+func (b *bucket) uploadGCS(ctx context.Context, key string, f *os.File, contentType string) error {
+	b.call()
+	w := b.gcs.Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
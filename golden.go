@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// defaultGoldenDistance - the default for --golden-distance, out of the 64 bits of a perceptual hash
+const defaultGoldenDistance = 6
+
+// dhash - return the difference hash of img: each bit records whether a cell of
+// a 9x8 grid over the image is brighter than its right neighbor, so that small
+// changes in resampling or compression change few bits
+func dhash(img image.Image) uint64 {
+	const cols, rows = 9, 8
+	b := img.Bounds()
+	var cells [rows][cols]float64
+	for r := 0; r < rows; r++ {
+		// every cell is at least one pixel, even in images smaller than the grid
+		y0, y1 := b.Min.Y+r*b.Dy()/rows, b.Min.Y+(r+1)*b.Dy()/rows
+		if y1 == y0 {
+			y1++
+		}
+		for c := 0; c < cols; c++ {
+			x0, x1 := b.Min.X+c*b.Dx()/cols, b.Min.X+(c+1)*b.Dx()/cols
+			if x1 == x0 {
+				x1++
+			}
+			var sum, n float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+					n++
+				}
+			}
+			cells[r][c] = sum / n
+		}
+	}
+
+	var hash uint64
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols-1; c++ {
+			hash <<= 1
+			if cells[r][c] > cells[r][c+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// compareGolden - compare the output destFile to the file with the same name in
+// the golden directory, returning an error tagged with a FAIL_GOLDEN_ code when
+// they differ in size or look more than opts.goldenDistance bits apart
+func compareGolden(opts *options, destFile string) error {
+	rel, err := filepath.Rel(opts.dest, destFile)
+	if err != nil {
+		return err
+	}
+	goldenFile := filepath.Join(opts.golden, rel)
+	if _, err := os.Stat(goldenFile); err != nil {
+		return withReason(reasonGoldenMissing, fmt.Errorf("no golden file for %s", rel))
+	}
+
+	golden, _, err := decodeImage(goldenFile, opts.maxPixels)
+	if err != nil {
+		return withReason(reasonDecode, err)
+	}
+	output, _, err := decodeImage(destFile, opts.maxPixels)
+	if err != nil {
+		return withReason(reasonDecode, err)
+	}
+	if gs, ds := golden.Bounds().Size(), output.Bounds().Size(); gs != ds {
+		return withReason(reasonGoldenDimensions, fmt.Errorf("%s is %dx%d, golden is %dx%d", rel, ds.X, ds.Y, gs.X, gs.Y))
+	}
+	if d := bits.OnesCount64(dhash(golden) ^ dhash(output)); d > opts.goldenDistance {
+		return withReason(reasonGoldenDiffers, fmt.Errorf("%s differs from golden by %d bits, more than %d", rel, d, opts.goldenDistance))
+	}
+	return nil
+}
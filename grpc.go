@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcChunkSize - largest part of a resized image sent in one ResizeReply
+const grpcChunkSize = 64 << 10
+
+// wireCodec - encodes the messages of proto/resizer.proto in the protobuf wire
+// format by hand with protowire, so that no generated code is needed and clients
+// generated from the .proto file can call the Resizer service; the server only
+// decodes requests and encodes replies
+type wireCodec struct{}
+
+// Marshal - encode v, which must be a reply
+func (wireCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(interface{ marshal() []byte })
+	if !ok {
+		return nil, fmt.Errorf("unable to marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+// Unmarshal - decode data into v, which must be a request
+func (wireCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(interface{ unmarshal(b []byte) error })
+	if !ok {
+		return fmt.Errorf("unable to unmarshal into %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+// Name - the content subtype of the codec, the same as protobuf's
+func (wireCodec) Name() string {
+	return "proto"
+}
+
+// resizeRequest - the ResizeRequest message: an image, either as its bytes or as
+// its path relative to the source directory, and what to resize it to
+type resizeRequest struct {
+	image   []byte // field 1
+	path    string // field 2
+	maxSize string // field 3
+	preset  string // field 4
+	format  string // field 5
+	quality int32  // field 6
+}
+
+// unmarshal - decode the request from the protobuf wire format, skipping unknown fields
+func (m *resizeRequest) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			// the codec's buffer may be reused once the message is decoded
+			m.image = append([]byte(nil), v...)
+		case num >= 2 && num <= 5 && typ == protowire.BytesType:
+			var v string
+			v, n = protowire.ConsumeString(b)
+			switch num {
+			case 2:
+				m.path = v
+			case 3:
+				m.maxSize = v
+			case 4:
+				m.preset = v
+			case 5:
+				m.format = v
+			}
+		case num == 6 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.quality = int32(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// resizeReply - the ResizeReply message: a part of the resized image, with its
+// dimensions and format in the first part
+type resizeReply struct {
+	data   []byte // field 1
+	width  int32  // field 2
+	height int32  // field 3
+	format string // field 4
+}
+
+// marshal - encode the reply in the protobuf wire format
+func (m *resizeReply) marshal() []byte {
+	var b []byte
+	if len(m.data) > 0 {
+		b = protowire.AppendBytes(protowire.AppendTag(b, 1, protowire.BytesType), m.data)
+	}
+	if m.width != 0 {
+		b = protowire.AppendVarint(protowire.AppendTag(b, 2, protowire.VarintType), uint64(m.width))
+	}
+	if m.height != 0 {
+		b = protowire.AppendVarint(protowire.AppendTag(b, 3, protowire.VarintType), uint64(m.height))
+	}
+	if len(m.format) > 0 {
+		b = protowire.AppendString(protowire.AppendTag(b, 4, protowire.BytesType), m.format)
+	}
+	return b
+}
+
+// resizerService - the Resizer service of proto/resizer.proto, served by a *server
+var resizerService = grpc.ServiceDesc{
+	ServiceName: "photoidresizer.Resizer",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Resize", Handler: handleResizeStream, ServerStreams: true},
+	},
+	Metadata: "proto/resizer.proto",
+}
+
+// newGRPCServer - return a gRPC server of the Resizer service backed by s
+func newGRPCServer(s *server) *grpc.Server {
+	gs := grpc.NewServer(grpc.ForceServerCodec(wireCodec{}), grpc.MaxRecvMsgSize(maxUploadBytes+grpcChunkSize))
+	gs.RegisterService(&resizerService, s)
+	return gs
+}
+
+// handleResizeStream - the Resize call, resize the image of the request as
+// resizePhoto would, to the size, preset, format and quality of the request or
+// of the command line, and stream it back in parts of grpcChunkSize
+func handleResizeStream(srv interface{}, stream grpc.ServerStream) error {
+	s := srv.(*server)
+	req := &resizeRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	var img image.Image
+	var err error
+	switch {
+	case len(req.image) > 0 && len(req.path) > 0:
+		return status.Error(codes.InvalidArgument, "only one of image and path can be given")
+	case len(req.image) > 0:
+		img, _, err = decodeReader(bytes.NewReader(req.image), s.opts.maxPixels)
+	case len(req.path) > 0 && len(s.opts.source) > 0:
+		var srcname string
+		if srcname, err = s.findPhoto(req.path); err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		img, _, err = decodeImage(srcname, s.opts.maxPixels)
+	case len(req.path) > 0:
+		return status.Error(codes.FailedPrecondition, "paths need the server to be started with -s")
+	default:
+		return status.Error(codes.InvalidArgument, "an image or path is required")
+	}
+	if reasonOf(err) == reasonTooLarge {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.InvalidArgument, string(reasonDecode)+": "+err.Error())
+	}
+
+	size, err := s.requestedSize(req.preset, req.maxSize, false)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	format := "jpeg"
+	if len(req.format) > 0 {
+		if format, err = resizer.ParseFormat(req.format); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	quality := s.opts.quality
+	if req.quality != 0 {
+		if req.quality < 1 || req.quality > 100 {
+			return status.Errorf(codes.InvalidArgument, "invalid quality: %d", req.quality)
+		}
+		quality = int(req.quality)
+	}
+	data, bounds, err := s.encodeResized(img, size, format, quality)
+	if err != nil {
+		logs.error(logEntry{Action: "grpc"}.withErr(err), "Unable to resize for a Resize call: %v\n", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	reply := &resizeReply{width: int32(bounds.Dx()), height: int32(bounds.Dy()), format: format}
+	for first := true; first || len(data) > 0; first = false {
+		n := len(data)
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+		reply.data, data = data[:n], data[n:]
+		if err := stream.SendMsg(reply); err != nil {
+			return err
+		}
+		reply = &resizeReply{}
+	}
+	return nil
+}
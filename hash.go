@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// hashAlgorithms - the algorithms --hash can choose for hashing sources and outputs,
+// from the most widely checkable to the fastest
+var hashAlgorithms = []string{"sha256", "blake3", "xxhash"}
+
+// validHashAlgorithm - return true if algorithm is one of hashAlgorithms
+func validHashAlgorithm(algorithm string) bool {
+	for _, a := range hashAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// newHash - return a new hash of the algorithm; SHA-256 uses the CPU's SHA
+// instructions and BLAKE3 its vector instructions where there are any
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "blake3":
+		return blake3.New()
+	case "xxhash":
+		return xxhash.New()
+	}
+	return sha256.New()
+}
+
+// hashFile - return the hex hash of the file at path; hashes other than SHA-256
+// are prefixed with the name of their algorithm, such as blake3:, so that hashes
+// recorded with different algorithms never match
+func hashFile(algorithm, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash(algorithm)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if algorithm != "sha256" {
+		sum = algorithm + ":" + sum
+	}
+	return sum, nil
+}
+
+// hashFiles - return the hashes of paths by path, hashing threads files at a time;
+// files that can not be read are left out
+func hashFiles(algorithm string, paths []string, threads int) map[string]string {
+	if threads < 1 {
+		threads = 1
+	}
+	sums := make(map[string]string, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan string)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range next {
+				if sum, err := hashFile(algorithm, path); err == nil {
+					mu.Lock()
+					sums[path] = sum
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, path := range paths {
+		next <- path
+	}
+	close(next)
+	wg.Wait()
+	return sums
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// hashCacheEntry - one line of a --cache-file, a source content that was
+// processed with the settings params
+type hashCacheEntry struct {
+	Time   time.Time `json:"time"`
+	Hash   string    `json:"hash"` // as returned by hashFile
+	Params string    `json:"params"`
+}
+
+// hashCache - an append-only JSON lines file of the source contents processed
+// by earlier runs and the settings they were processed with, so that a re-run
+// only processes new or changed photos wherever their outputs went; a nil
+// hashCache remembers nothing
+type hashCache struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	seen  map[string]bool // by hash and params
+	force bool            // process everything, recording it as usual
+}
+
+// hashCacheKey - return the key of a source content processed with params
+func hashCacheKey(hash, params string) string {
+	return hash + "\n" + params
+}
+
+// openHashCache - open the cache at path for appending, creating it if needed,
+// and read what earlier runs processed; with force, sources are processed
+// whether they were or not
+func openHashCache(path string, mode os.FileMode, force bool) (*hashCache, error) {
+	c := &hashCache{seen: make(map[string]bool), force: force}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var e hashCacheEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && len(e.Hash) > 0 {
+				c.seen[hashCacheKey(e.Hash, e.Params)] = true
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	c.f, c.enc = f, json.NewEncoder(f)
+	return c, nil
+}
+
+// processed - return true if a source with the content hash was processed with
+// params before, and --force is not given
+func (c *hashCache) processed(hash, params string) bool {
+	if c == nil || c.force || len(hash) == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seen[hashCacheKey(hash, params)]
+}
+
+// record - remember that a source with the content hash was processed with params
+func (c *hashCache) record(hash, params string) {
+	if c == nil || len(hash) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := hashCacheKey(hash, params)
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	if err := c.enc.Encode(hashCacheEntry{Time: clock(), Hash: hash, Params: params}); err != nil {
+		logs.warn(logEntry{Action: "cache"}.withErr(err), "Unable to write to the cache file: %v\n", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// activeHours - the time of day during which photos are processed, such as
+// 22:00-06:00; the zero value is active all day
+type activeHours struct {
+	set        bool
+	start, end int // minutes after midnight, the end is not included
+}
+
+// parseClock - parse a time of day such as 22:00 into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseActiveHours - parse a window such as 22:00-06:00, which may span midnight
+func parseActiveHours(s string) (activeHours, error) {
+	if len(s) == 0 {
+		return activeHours{}, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return activeHours{}, fmt.Errorf("invalid active hours, expected HH:MM-HH:MM: %s", s)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return activeHours{}, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return activeHours{}, err
+	}
+	return activeHours{set: start != end, start: start, end: end}, nil
+}
+
+// contains - return true if t is within the active hours
+func (a activeHours) contains(t time.Time) bool {
+	if !a.set {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if a.start < a.end {
+		return m >= a.start && m < a.end
+	}
+	return m >= a.start || m < a.end
+}
+
+// next - return when the active hours next begin after t
+func (a activeHours) next(t time.Time) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), a.start/60, a.start%60, 0, 0, t.Location())
+	if !start.After(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+// wait - block until the active hours, return false if done is closed first
+func (a activeHours) wait(done <-chan struct{}) bool {
+	for !a.contains(clock()) {
+		resume := a.next(clock())
+		logs.info(logEntry{Action: "wait"}, "outside of --active-hours, waiting until %s\n", resume.Format("2006-01-02 15:04"))
+		// wake up at least hourly, so that clock changes are noticed
+		wait := resume.Sub(clock())
+		if wait > time.Hour {
+			wait = time.Hour
+		}
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultLanguage - used when a message is not available in the requested language
+const defaultLanguage = "en"
+
+// embeddedMessages - the built-in message catalogs, one JSON file per language
+// mapping reason codes to messages that can be shown to the person who took the photo
+//
+//go:embed messages/*.json
+var embeddedMessages embed.FS
+
+// catalog - user-facing messages keyed by language and then by reason code
+type catalog map[string]map[reason]string
+
+// merge - add the messages in the JSON document data to the language lang,
+// replacing any that already exist
+func (c catalog) merge(lang string, data []byte) error {
+	messages := make(map[reason]string)
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+	lang = strings.ToLower(lang)
+	if c[lang] == nil {
+		c[lang] = make(map[reason]string)
+	}
+	for code, msg := range messages {
+		c[lang][code] = msg
+	}
+	return nil
+}
+
+// loadCatalog - return the built-in catalogs with the JSON files in overrideDir,
+// if given, merged on top so that sites can reword messages or add languages
+func loadCatalog(overrideDir string) (catalog, error) {
+	c := make(catalog)
+	entries, err := embeddedMessages.ReadDir("messages")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := embeddedMessages.ReadFile(path.Join("messages", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.merge(strings.TrimSuffix(entry.Name(), ".json"), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(overrideDir) == 0 {
+		return c, nil
+	}
+	files, err := filepath.Glob(filepath.Join(overrideDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.merge(strings.TrimSuffix(filepath.Base(file), ".json"), data); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// message - return the message for code in lang, falling back from a regional
+// language such as es-MX to es and then to English; the code itself is
+// returned when there is no message for it at all
+func (c catalog) message(lang string, code reason) string {
+	lang = strings.ToLower(strings.Replace(lang, "_", "-", -1))
+	candidates := []string{lang}
+	if i := strings.Index(lang, "-"); i > 0 {
+		candidates = append(candidates, lang[:i])
+	}
+	candidates = append(candidates, defaultLanguage)
+
+	for _, l := range candidates {
+		if msg, ok := c[l][code]; ok {
+			return msg
+		}
+	}
+	return string(code)
+}
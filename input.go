@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputEntry - one row of a --manifest: a source, the name of its output and
+// the settings it overrides, named like those of a directory profile
+type inputEntry struct {
+	source   string            // path of the source under the source directory
+	output   string            // name of the output under the destination, empty to name it after the source
+	settings map[string]string // nil when the row overrides nothing
+}
+
+// inputManifest - the files of a run given by a CSV or JSON file, such as an HR
+// export, processed in its order instead of walking the source directory; a
+// nil manifest walks the source directory
+type inputManifest struct {
+	file     string
+	entries  []inputEntry
+	bySource map[string]*inputEntry
+}
+
+// loadManifest - read the manifest file, a JSON array of objects when its
+// extension is .json and otherwise a CSV file with a header, checking every
+// entry so that mistakes are found before the run.  The source of each entry,
+// and its output, must stay under the source directory and destination.
+func loadManifest(file, source string) (*inputManifest, error) {
+	var rows []map[string]string
+	var err error
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		rows, err = readManifestJSON(file)
+	} else {
+		rows, err = readManifestCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	m := &inputManifest{file: file, bySource: make(map[string]*inputEntry)}
+	sources, outputs := make(map[string]bool), make(map[string]bool)
+	for i, row := range rows {
+		e := inputEntry{settings: make(map[string]string)}
+		for key, value := range row {
+			switch {
+			case len(value) == 0:
+			case key == "source":
+				e.source = value
+			case key == "output":
+				e.output = value
+			case profileKeys[key]:
+				e.settings[key] = value
+			default:
+				return nil, fmt.Errorf("%s: entry %d: unknown setting: %s", file, i+1, key)
+			}
+		}
+		if len(e.settings) == 0 {
+			e.settings = nil
+		} else if _, err := applyProfile(&options{}, e.settings); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", file, i+1, err)
+		}
+		if len(e.source) == 0 {
+			return nil, fmt.Errorf("%s: entry %d: no source", file, i+1)
+		}
+		rel := filepath.Clean(filepath.FromSlash(e.source))
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s: entry %d: source must be under the source directory: %s", file, i+1, e.source)
+		}
+		e.source = filepath.Join(source, rel)
+		if sources[e.source] {
+			return nil, fmt.Errorf("%s: entry %d: source given twice: %s", file, i+1, rel)
+		}
+		sources[e.source] = true
+		if len(e.output) > 0 {
+			e.output = filepath.Clean(filepath.FromSlash(e.output))
+			if filepath.IsAbs(e.output) || e.output == ".." || strings.HasPrefix(e.output, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("%s: entry %d: output must be under the destination: %s", file, i+1, e.output)
+			}
+			if outputs[strings.ToLower(e.output)] {
+				return nil, fmt.Errorf("%s: entry %d: output given twice: %s", file, i+1, e.output)
+			}
+			outputs[strings.ToLower(e.output)] = true
+		}
+		m.entries = append(m.entries, e)
+	}
+	if len(m.entries) == 0 {
+		return nil, fmt.Errorf("%s: no entries", file)
+	}
+	for i := range m.entries {
+		m.bySource[m.entries[i].source] = &m.entries[i]
+	}
+	return m, nil
+}
+
+// readManifestCSV - return the rows of a CSV manifest, keyed by the lower case
+// names of its header
+func readManifestCSV(file string) ([]map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, name := range header {
+			if i < len(record) {
+				row[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readManifestJSON - return the rows of a JSON manifest, whose values may be
+// strings or numbers such as a quality of 90
+func readManifestJSON(file string) ([]map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(objects))
+	for i, obj := range objects {
+		row := make(map[string]string)
+		for key, value := range obj {
+			switch v := value.(type) {
+			case string:
+				row[strings.ToLower(key)] = strings.TrimSpace(v)
+			case float64:
+				row[strings.ToLower(key)] = fmt.Sprint(v)
+			case nil:
+			default:
+				return nil, fmt.Errorf("entry %d: %s must be a string or number", i+1, key)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// entry - return the entry of the source path, or nil when it has none
+func (m *inputManifest) entry(path string) *inputEntry {
+	if m == nil {
+		return nil
+	}
+	return m.bySource[path]
+}
+
+// walk - send the source of every entry, in the order of the manifest, in place
+// of walking the source directory; sources that are not regular files are
+// reported, and the walk fails once the others have been sent
+func (m *inputManifest) walk(done <-chan struct{}) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		missing := 0
+		for _, e := range m.entries {
+			info, err := os.Stat(e.source)
+			if err == nil && !info.Mode().IsRegular() {
+				err = errors.New("not a regular file")
+			}
+			if err != nil {
+				err = applyPolicy("source", withReason(reasonStat, err))
+				logs.error(logEntry{Action: "manifest", File: e.source}.withErr(err), "Unable to process %s of %s: %v\n", e.source, m.file, err)
+				if isFatal(err) {
+					errc <- err
+					return
+				}
+				missing++
+				continue
+			}
+			select {
+			case paths <- e.source:
+			case <-done:
+				errc <- errors.New("walk canceled")
+				return
+			}
+		}
+		if missing > 0 {
+			errc <- fmt.Errorf("%d of the %d entries of %s could not be processed", missing, len(m.entries), m.file)
+			return
+		}
+		errc <- nil
+	}()
+	return paths, errc
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted - the exit status of a run that was stopped by SIGINT or
+// SIGTERM, as shells report for an interrupted command
+const exitInterrupted = 130
+
+// interruptHandler - what SIGINT and SIGTERM do: a batch run stops handing out
+// files and reports on those that were processed, and when it does not finish
+// within the grace period, or on a second signal, or when no batch is running,
+// the outputs being written are removed, the cleanups run and the program exits
+type interruptHandler struct {
+	mu       sync.Mutex
+	once     sync.Once
+	stop     func()        // stops the running batch, nil when none is
+	grace    time.Duration // that the batch is given to finish its files
+	stopped  bool
+	writing  map[string]int // outputs that are being written, by name
+	cleanups []func()
+}
+
+var interrupts = &interruptHandler{writing: make(map[string]int)}
+
+// listen - start handling the signals, once
+func (h *interruptHandler) listen() {
+	h.once.Do(func() {
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go h.handle(signals)
+	})
+}
+
+// own - leave the signals to the caller, as the daemon does so that a signal
+// between its batches ends it cleanly; listen installs no handler after this
+func (h *interruptHandler) own() {
+	h.once.Do(func() {})
+}
+
+// markStopped - record that a signal stopped the run, for the caller that owns
+// the signals
+func (h *interruptHandler) markStopped() {
+	h.mu.Lock()
+	h.stopped = true
+	h.mu.Unlock()
+}
+
+// handle - stop the batch gracefully on the first signal, and abort on the
+// second or once the grace period is over
+func (h *interruptHandler) handle(signals <-chan os.Signal) {
+	sig := <-signals
+	h.mu.Lock()
+	stop, grace := h.stop, h.grace
+	h.stopped = true
+	h.mu.Unlock()
+	if stop == nil {
+		h.abort()
+	}
+	logs.warn(logEntry{Action: "interrupt"}, "stopping on %s, once the files being processed are done or within %v\n", sig, grace)
+	stop()
+	timer := time.NewTimer(grace)
+	select {
+	case sig = <-signals:
+		logs.warn(logEntry{Action: "interrupt"}, "aborting on %s\n", sig)
+	case <-timer.C:
+		logs.warn(logEntry{Action: "interrupt"}, "aborting, the files being processed were not done within %v\n", grace)
+	}
+	h.abort()
+}
+
+// abort - remove the outputs being written, which would be incomplete, run the
+// cleanups and exit
+func (h *interruptHandler) abort() {
+	h.mu.Lock()
+	for name := range h.writing {
+		os.Remove(name)
+		logs.warn(logEntry{Action: "interrupt", Dest: name}, "removed the incomplete output %s\n", name)
+	}
+	cleanups := h.cleanups
+	h.mu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	os.Exit(exitInterrupted)
+}
+
+// onAbort - run fn when the program exits because of a signal
+func (h *interruptHandler) onAbort(fn func()) {
+	h.mu.Lock()
+	h.cleanups = append(h.cleanups, fn)
+	h.mu.Unlock()
+	h.listen()
+}
+
+// graceful - call stop on the first signal rather than exiting, giving the
+// batch grace to finish the files it is processing; the returned func is
+// called once the batch has ended
+func (h *interruptHandler) graceful(stop func(), grace time.Duration) func() {
+	h.mu.Lock()
+	h.stop, h.grace = stop, grace
+	h.mu.Unlock()
+	h.listen()
+	return func() {
+		h.mu.Lock()
+		h.stop = nil
+		h.mu.Unlock()
+	}
+}
+
+// interrupted - return true if a signal stopped the run
+func (h *interruptHandler) interrupted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stopped
+}
+
+// output - note that name is being written until the returned func is called,
+// so that an abort removes it
+func (h *interruptHandler) output(name string) func() {
+	h.mu.Lock()
+	h.writing[name]++
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		if h.writing[name]--; h.writing[name] <= 0 {
+			delete(h.writing, name)
+		}
+		h.mu.Unlock()
+	}
+}
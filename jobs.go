@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// maxJobRequestBytes - largest body a POST /jobs request may have
+const maxJobRequestBytes = 1 << 20
+
+// job states
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobRequest - the body of POST /jobs; source and dest are relative to the
+// --jobs-root, and the other values override the flags the server was started with
+type jobRequest struct {
+	Source  string `json:"source"`
+	Dest    string `json:"dest"`
+	MaxSize string `json:"max_size,omitempty"`
+	Preset  string `json:"preset,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+}
+
+// job - a batch run submitted to the serve subcommand, returned by GET /jobs/{id}
+type job struct {
+	ID        string      `json:"id"`
+	State     string      `json:"state"`
+	Source    string      `json:"source"`
+	Dest      string      `json:"dest"`
+	Submitted time.Time   `json:"submitted"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Summary   *runSummary `json:"summary,omitempty"` // once the job has finished
+	Error     string      `json:"error,omitempty"`
+
+	opts *options
+}
+
+// jobQueue - the jobs submitted to the serve subcommand, kept in memory and run
+// one at a time, each with the server's workers
+type jobQueue struct {
+	mu     sync.Mutex
+	root   string
+	jobs   map[string]*job
+	order  []string // IDs in the order they were submitted
+	queued chan *job
+}
+
+// newJobQueue - return a queue of jobs whose directories are under root
+func newJobQueue(root string) *jobQueue {
+	return &jobQueue{root: root, jobs: make(map[string]*job), queued: make(chan *job, 1024)}
+}
+
+// resolve - return the directory rel names under the root, with its symbolic
+// links resolved, or an error when they lead outside of the root
+func (q *jobQueue) resolve(rel string) (string, error) {
+	if len(strings.TrimSpace(rel)) == 0 {
+		return "", errors.New("a source and dest are required")
+	}
+	dir := filepath.Join(q.root, filepath.Clean(string(filepath.Separator)+filepath.FromSlash(rel)))
+	if !pathWithin(dir, q.root) {
+		return "", fmt.Errorf("%s leads outside of the jobs root", rel)
+	}
+	return resolvePath(dir), nil
+}
+
+// jobOptions - return the options of a batch run of req, the server's options
+// with the request's directories and overrides
+func (q *jobQueue) jobOptions(opts *options, req jobRequest) (*options, error) {
+	source, err := q.resolve(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := q.resolve(req.Dest)
+	if err != nil {
+		return nil, err
+	}
+	if !dirExists(source) {
+		return nil, fmt.Errorf("source directory does not exist: %s", req.Source)
+	}
+	jo := *opts
+	jo.source, jo.dest = source, dest
+	if jo.nestedDest, err = nestedDest(source, dest); err != nil {
+		return nil, err
+	}
+	jo.profiles = newProfileCache(source)
+	jo.stats = &runStats{}
+	// the encryptor and trash are set up in the job's destination when it runs
+	jo.encryptor, jo.trash = nil, nil
+	// the roster, summaries and report describe the server's directories, not the job's
+	jo.roster, jo.summaryFile, jo.summaryFD, jo.report = nil, "", 0, ""
+
+	size := jo.size
+	switch {
+	case len(req.MaxSize) > 0 && len(req.Preset) > 0:
+		return nil, errors.New("only one of max_size and preset can be given")
+	case len(req.MaxSize) > 0:
+		if size, err = resizer.ParseSize(req.MaxSize, jo.size.Fit); err != nil {
+			return nil, err
+		}
+	case len(req.Preset) > 0:
+		pr, ok := presets[req.Preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset: %s", req.Preset)
+		}
+		size = resizer.Size{Width: pr.width, Height: pr.height}
+	}
+	if jo.size, err = jo.aspect.ApplyTo(size); err != nil {
+		return nil, err
+	}
+	if len(req.MaxSize) > 0 || len(req.Preset) > 0 {
+		jo.sizes = nil
+	}
+	if !jo.size.IsSet() && len(jo.sizes) == 0 {
+		return nil, errors.New("a max_size or preset is required")
+	}
+	if len(req.Format) > 0 {
+		if jo.format, err = resizer.ParseFormat(req.Format); err != nil {
+			return nil, err
+		}
+	}
+	if req.Quality != 0 {
+		if req.Quality < 1 || req.Quality > 100 {
+			return nil, fmt.Errorf("invalid quality: %d", req.Quality)
+		}
+		jo.quality = req.Quality
+	}
+	return &jo, nil
+}
+
+// submit - queue a job for req and return it
+func (q *jobQueue) submit(opts *options, req jobRequest) (*job, error) {
+	jo, err := q.jobOptions(opts, req)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := &job{ID: strconv.Itoa(len(q.order) + 1), State: jobQueued, Source: req.Source, Dest: req.Dest, Submitted: clock(), opts: jo}
+	select {
+	case q.queued <- j:
+	default:
+		return nil, errors.New("too many jobs are queued")
+	}
+	q.jobs[j.ID] = j
+	q.order = append(q.order, j.ID)
+	return j, nil
+}
+
+// status - return a copy of the job id, safe to encode while it runs
+func (q *jobQueue) status(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// list - return a copy of every job, in the order they were submitted
+func (q *jobQueue) list() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]job, 0, len(q.order))
+	for _, id := range q.order {
+		jobs = append(jobs, *q.jobs[id])
+	}
+	return jobs
+}
+
+// run - run the queued jobs one after the other, forever
+func (q *jobQueue) run(p *caire.Processor) {
+	for j := range q.queued {
+		started := clock()
+		q.mu.Lock()
+		j.State, j.Started = jobRunning, &started
+		q.mu.Unlock()
+		logs.info(logEntry{Action: "job", File: j.opts.source, Dest: j.opts.dest}, "job %s: processing %s into %s\n", j.ID, j.Source, j.Dest)
+
+		summary := &runSummary{}
+		j.opts.summaryTo = summary
+		err := os.MkdirAll(j.opts.dest, j.opts.dirMode)
+		if err == nil {
+			err = j.opts.openDest()
+		}
+		if err == nil {
+			done := make(chan struct{})
+			paths, errc := walkFiles(done, j.opts, j.opts.maxAge)
+			err = processAll(done, paths, errc, j.opts, p)
+			close(done)
+			if cerr := j.opts.encryptor.close(); err == nil {
+				err = cerr
+			}
+		}
+
+		finished := clock()
+		q.mu.Lock()
+		j.State, j.Finished = jobDone, &finished
+		if len(summary.Version) > 0 {
+			j.Summary = summary
+		}
+		if err != nil {
+			j.State, j.Error = jobFailed, err.Error()
+			logs.error(logEntry{Action: "job", File: j.opts.source}.withErr(err), "job %s failed: %v\n", j.ID, err)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// writeResults - write every file in the job's destination directory to w as a
+// zip archive
+func (q *jobQueue) writeResults(w io.Writer, j job) error {
+	zw := zip.NewWriter(w)
+	err := filepath.Walk(j.opts.dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(j.opts.dest, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		zf, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(zf, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// handleJobs - POST /jobs submits a job and GET /jobs lists them; GET /jobs/{id}
+// returns the state of a job and GET /jobs/{id}/results the files in its
+// destination directory as a zip archive once it has finished
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	switch {
+	case len(id) == 0 && r.Method == http.MethodPost:
+		var req jobRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestBytes)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		j, err := s.jobs.submit(s.opts, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+		return
+	case r.Method != http.MethodGet:
+		http.Error(w, "POST a job or GET its state", http.StatusMethodNotAllowed)
+		return
+	case len(id) == 0:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.jobs.list())
+		return
+	}
+
+	id, results := strings.TrimSuffix(id, "/results"), strings.HasSuffix(id, "/results")
+	j, ok := s.jobs.status(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no job %s", id), http.StatusNotFound)
+		return
+	}
+	if !results {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(j)
+		return
+	}
+	if j.State != jobDone && j.State != jobFailed {
+		http.Error(w, fmt.Sprintf("job %s is %s", id, j.State), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"job-%s.zip\"", j.ID))
+	if err := s.jobs.writeResults(w, j); err != nil {
+		logs.error(logEntry{Action: "job", File: j.opts.dest}.withErr(err), "Unable to send the results of job %s: %v\n", j.ID, err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// writeTestPNG - write a w x h PNG of a single color to path
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForJob - return the job id once it has finished
+func waitForJob(t *testing.T, q *jobQueue, id string) job {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := q.status(id); ok && (j.State == jobDone || j.State == jobFailed) {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return job{}
+}
+
+func TestJobEncryptsOutputs(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(root, "in", "10042.png"), 120, 60)
+	writeTestPNG(t, filepath.Join(root, "in", "10043.png"), 20, 10)
+	passphrase := filepath.Join(t.TempDir(), "passphrase")
+	if err := ioutil.WriteFile(passphrase, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{
+		match:            "png",
+		numWorkers:       2,
+		stats:            &runStats{},
+		size:             resizer.Size{Width: 40},
+		engine:           "scale",
+		scaler:           "bilinear",
+		seams:            "both",
+		quality:          resizer.DefaultQuality,
+		alpha:            resizer.Alpha{Mode: "flatten", Color: color.White},
+		fileMode:         0644,
+		dirMode:          0755,
+		conflictStrategy: "overwrite",
+		layout:           "flatten",
+		windowsNames:     "ignore",
+		hashAlgorithm:    "sha256",
+		passphraseFile:   passphrase,
+	}
+	p := resizer.NewProcessor("facefinder")
+	p.FaceDetect = false
+	s := &server{opts: opts, p: p, jobs: newJobQueue(root)}
+	go s.jobs.run(p)
+
+	rec := httptest.NewRecorder()
+	s.handleJobs(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"source": "in", "dest": "out"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs: %d %s", rec.Code, rec.Body)
+	}
+	if j := waitForJob(t, s.jobs, "1"); j.State != jobDone {
+		t.Fatalf("job failed: %s", j.Error)
+	}
+
+	out := filepath.Join(root, "out")
+	for _, name := range []string{"10042.png", "10043.png"} {
+		if fileExists(filepath.Join(out, name)) {
+			t.Errorf("%s was written in cleartext", name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(out, name+encSuffix))
+		if err != nil {
+			t.Errorf("%s was not written encrypted: %v", name, err)
+			continue
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s%s decodes as an image", name, encSuffix)
+		}
+		plain, err := openSealed(data, []byte("correct horse"))
+		if err != nil {
+			t.Errorf("%s%s: %v", name, encSuffix, err)
+			continue
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(plain)); err != nil {
+			t.Errorf("%s%s does not decrypt to an image: %v", name, encSuffix, err)
+		}
+	}
+	if !fileExists(filepath.Join(out, manifestName)) {
+		t.Errorf("no %s in the job's destination", manifestName)
+	}
+}
+
+func TestJobQueueResolve(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "in"), filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	q := newJobQueue(root)
+	real := resolvePath(root)
+
+	tests := []struct {
+		rel     string
+		want    string
+		wantErr bool
+	}{
+		{rel: "in", want: filepath.Join(real, "in")},
+		{rel: "in/new/out", want: filepath.Join(real, "in", "new", "out")},
+		{rel: "../../in", want: filepath.Join(real, "in")},
+		{rel: "alias/out", want: filepath.Join(real, "in", "out")},
+		{rel: "escape", wantErr: true},
+		{rel: "escape/out", wantErr: true},
+		{rel: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := q.resolve(tt.rel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolve(%q) = %s, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolve(%q): %v", tt.rel, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolve(%q) = %s, want %s", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestJobsRequireToken(t *testing.T) {
+	s := &server{opts: &options{}, jobs: newJobQueue(t.TempDir())}
+	handler := requireToken("s3cret", s.handleJobs)
+	for _, tt := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		if len(tt.auth) > 0 {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET /jobs with %q: got %d, want %d", tt.auth, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// kioskFrameInterval - frames arriving faster than this are dropped rather than analyzed
+const kioskFrameInterval = 200 * time.Millisecond
+
+// kioskStableFrames - how many well framed frames in a row are needed before a photo is captured
+const kioskStableFrames = 5
+
+// frameReader - splits a stream of concatenated JPEG images, such as MJPEG
+// over HTTP or the output of ffmpeg -f mpjpeg, into individual frames
+type frameReader struct {
+	r *bufio.Reader
+}
+
+// next - return the next complete JPEG image in the stream
+func (fr *frameReader) next() ([]byte, error) {
+	soi := false
+	for {
+		// skip everything, such as multipart headers, up to the SOI marker
+		var prev byte
+		for !soi {
+			b, err := fr.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			soi = prev == 0xff && b == 0xd8
+			prev = b
+		}
+		frame, err := fr.frame()
+		// a damaged or cut off frame is dropped, and the stream picks up at the
+		// next SOI, which may be the one that cut it off
+		switch err {
+		case errBadFrame:
+			soi = false
+		case errCutOff:
+			soi = true
+		default:
+			return frame, err
+		}
+	}
+}
+
+var (
+	// errBadFrame - a frame whose segments do not follow the JPEG structure
+	errBadFrame = errors.New("damaged JPEG frame")
+	// errCutOff - a frame that was followed by the SOI of the next before its EOI
+	errCutOff = errors.New("JPEG frame cut off")
+)
+
+// frame - return the JPEG image whose SOI marker has just been read
+//
+// The segments up to SOS are skipped by their length, as readExif does, since
+// metadata such as an EXIF thumbnail can contain an EOI of its own.  Only in
+// the compressed data, where a 0xff is always followed by 0x00 or a restart
+// marker, does the first other marker end the scan.  Progressive frames have
+// several scans, so parsing goes on until EOI.
+func (fr *frameReader) frame() ([]byte, error) {
+	frame := bytes.NewBuffer([]byte{0xff, 0xd8})
+	marker, err := fr.marker()
+	for {
+		if err != nil {
+			return nil, err
+		}
+		frame.Write([]byte{0xff, marker})
+		switch {
+		case marker == 0xd9:
+			return frame.Bytes(), nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// standalone markers have no length
+			marker, err = fr.marker()
+			continue
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(fr.r, length[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return nil, errBadFrame
+		}
+		frame.Write(length[:])
+		if _, err := io.CopyN(frame, fr.r, int64(n-2)); err != nil {
+			return nil, err
+		}
+		if marker == 0xda {
+			marker, err = fr.scan(frame)
+		} else {
+			marker, err = fr.marker()
+		}
+	}
+}
+
+// marker - read the marker that starts the next segment and return its type
+func (fr *frameReader) marker() (byte, error) {
+	b, err := fr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, errBadFrame
+	}
+	// any number of 0xff may pad the space between segments
+	for b == 0xff {
+		if b, err = fr.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	switch b {
+	case 0x00:
+		return 0, errBadFrame
+	case 0xd8:
+		return 0, errCutOff
+	}
+	return b, nil
+}
+
+// scan - copy the compressed data of a scan to frame and return the type of
+// the marker that ends it
+func (fr *frameReader) scan(frame *bytes.Buffer) (byte, error) {
+	for {
+		b, err := fr.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0xff {
+			frame.WriteByte(b)
+			continue
+		}
+		for b == 0xff {
+			if b, err = fr.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+		if b == 0x00 || b >= 0xd0 && b <= 0xd7 {
+			frame.Write([]byte{0xff, b})
+			continue
+		}
+		if b == 0xd8 {
+			return 0, errCutOff
+		}
+		return b, nil
+	}
+}
+
+// openStream - return the stream of frames from a URL or, when source is -, from stdin
+func openStream(source string) (io.ReadCloser, error) {
+	if source == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// wellFramed - return true if the only face is horizontally centered, fully
+// inside the frame and takes up between a fifth and three fifths of its height
+func wellFramed(bounds image.Rectangle, faces []resizer.Face) bool {
+	if len(faces) != 1 {
+		return false
+	}
+	f := faces[0].Rectangle
+	centerX := (f.Min.X + f.Max.X) / 2
+	return f.In(bounds) &&
+		centerX > bounds.Min.X+bounds.Dx()/3 && centerX < bounds.Max.X-bounds.Dx()/3 &&
+		f.Dy()*5 >= bounds.Dy() && f.Dy()*5 <= bounds.Dy()*3
+}
+
+// captureName - return a name in dir for a capture taken at t, with the
+// milliseconds in it so that people stepping up within a second of each other
+// get their own file, and a counter should the name be taken nonetheless
+func captureName(dir string, t time.Time) string {
+	base := fmt.Sprintf("capture-%s-%03d", t.Format("20060102-150405"), t.Nanosecond()/int(time.Millisecond))
+	name := filepath.Join(dir, base+".jpg")
+	for i := 1; fileExists(name); i++ {
+		name = filepath.Join(dir, fmt.Sprintf("%s_%d.jpg", base, i))
+	}
+	return name
+}
+
+// capture - save frame into the source directory, run it through the resize
+// pipeline and validate the result; rejected outputs are removed from the destination
+func capture(frame []byte, opts *options, p *caire.Processor, fd *resizer.FaceDetector) {
+	srcname := captureName(opts.source, clock())
+	if err := ioutil.WriteFile(srcname, frame, opts.fileMode); err != nil {
+		logs.error(logEntry{Action: "capture", File: srcname, Reason: reasonWrite}.withErr(err), "[%s] Unable to save capture: %v\n", reasonWrite, err)
+		return
+	}
+	logs.info(logEntry{Action: "capture", File: srcname}, "captured: %s\n", srcname)
+
+	// a profile in the source directory applies to captures too
+	opts, err := opts.forFile(srcname)
+	if err != nil {
+		logs.error(logEntry{Action: "profile", File: srcname}.withErr(err), "Unable to apply profile to %s: %v\n", srcname, err)
+		return
+	}
+	dstname := destName(opts, srcname)
+	// --routes can send captures to a subdirectory of the destination
+	if err := os.MkdirAll(filepath.Dir(dstname), opts.dirMode); err != nil {
+		logs.error(logEntry{Action: "mkdir", File: srcname, Dest: dstname, Reason: reasonMkdir}.withErr(err),
+			"[%s] Unable to create destination directory: %v\n", reasonMkdir, err)
+		return
+	}
+	if err := process(p, opts, dstname, srcname); err != nil {
+		logs.error(logEntry{Action: "capture", File: srcname}.withErr(err), "Unable to process capture %s: %v\n", srcname, err)
+		os.Remove(dstname)
+		return
+	}
+
+	v := checkFile(dstname, opts, fd)
+	if v.Verdict == "pass" {
+		logs.info(logEntry{Action: "accept", File: srcname, Dest: dstname}, "accepted: %s\n", dstname)
+		return
+	}
+	os.Remove(dstname)
+	logs.info(logEntry{Action: "reject", File: srcname}, "rejected: %s\n", strings.Join(v.Messages, " "))
+}
+
+// runKiosk - watch a camera stream for a well framed face, capturing a photo each
+// time someone steps up to the camera; return when the stream ends
+func runKiosk(opts *options, p *caire.Processor, classifier, source string) int {
+	fd, err := resizer.NewFaceDetector(classifier)
+	if err != nil {
+		logs.error(logEntry{Action: "kiosk", File: classifier}.withErr(err), "Unable to load classification file: %s ; %s\n", classifier, err)
+		return 1
+	}
+
+	stable := 0
+	waitForLeave := false
+	var lastFrame time.Time
+	for {
+		stream, err := openStream(source)
+		if err != nil {
+			logs.warn(logEntry{Action: "stream"}.withErr(err), "Unable to open camera stream: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		fr := &frameReader{r: bufio.NewReader(stream)}
+		for {
+			frame, err := fr.next()
+			if err != nil {
+				break
+			}
+			if time.Since(lastFrame) < kioskFrameInterval {
+				continue
+			}
+			lastFrame = time.Now()
+
+			img, _, err := decodeReader(bytes.NewReader(frame), opts.maxPixels)
+			if err != nil {
+				continue
+			}
+			faces := fd.Detect(img)
+			if waitForLeave {
+				// only capture the next person once the previous one has stepped away
+				waitForLeave = len(faces) > 0
+				continue
+			}
+			if !wellFramed(img.Bounds(), faces) || sharpness(img, faces[0].Add(img.Bounds().Min)) < minSharpness {
+				stable = 0
+				continue
+			}
+			if stable++; stable < kioskStableFrames {
+				continue
+			}
+			capture(frame, opts, p, fd)
+			stable = 0
+			waitForLeave = true
+		}
+		stream.Close()
+
+		// stdin can not be reopened; a snapshot URL ends after every image so it is polled
+		if source == "-" {
+			return 0
+		}
+		time.Sleep(kioskFrameInterval)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJPEG - return a w x h JPEG with an APP1 segment holding a thumbnail,
+// whose own EOI marker must not end the frame
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var thumb, full bytes.Buffer
+	if err := jpeg.Encode(&thumb, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&full, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	payload := append([]byte("Exif\x00\x00"), thumb.Bytes()...)
+	app1 := []byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	data := append([]byte{}, full.Bytes()[:2]...)
+	data = append(data, app1...)
+	data = append(data, payload...)
+	return append(data, full.Bytes()[2:]...)
+}
+
+func TestFrameReader(t *testing.T) {
+	first, second := testJPEG(t, 64, 48), testJPEG(t, 32, 40)
+	var stream bytes.Buffer
+	for _, frame := range [][]byte{first, second} {
+		stream.WriteString("--frame\r\nContent-Type: image/jpeg\r\n\r\n")
+		stream.Write(frame)
+		stream.WriteString("\r\n")
+	}
+
+	fr := &frameReader{r: bufio.NewReader(&stream)}
+	for i, want := range [][]byte{first, second} {
+		got, err := fr.next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("frame %d: got %d bytes, want %d", i, len(got), len(want))
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+			t.Errorf("frame %d does not decode: %v", i, err)
+		}
+	}
+	if _, err := fr.next(); err != io.EOF {
+		t.Errorf("got %v after the last frame, want EOF", err)
+	}
+}
+
+func TestFrameReaderSkipsDamagedFrame(t *testing.T) {
+	good := testJPEG(t, 16, 16)
+	var stream bytes.Buffer
+	// a frame with garbage where its first segment should be
+	stream.Write([]byte{0xff, 0xd8, 0x12, 0x34})
+	// a frame cut off in its compressed data, before its EOI, by the next one
+	stream.Write(good[:len(good)-3])
+	stream.Write(good)
+
+	fr := &frameReader{r: bufio.NewReader(&stream)}
+	got, err := fr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, good) {
+		t.Errorf("got %d bytes, want the %d bytes of the frame after the damaged one", len(got), len(good))
+	}
+}
+
+func TestCaptureName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kiosk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	at := time.Date(2024, 5, 6, 7, 8, 9, 250*int(time.Millisecond), time.UTC)
+
+	seen := make(map[string]bool)
+	for _, ts := range []time.Time{at, at.Add(500 * time.Millisecond), at, at} {
+		name := captureName(dir, ts)
+		if seen[name] {
+			t.Fatalf("%s was returned twice", name)
+		}
+		seen[name] = true
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"capture-20240506-070809-250.jpg", "capture-20240506-070809-750.jpg", "capture-20240506-070809-250_1.jpg", "capture-20240506-070809-250_2.jpg"} {
+		if !seen[filepath.Join(dir, want)] {
+			t.Errorf("%s was not returned, got %v", want, seen)
+		}
+	}
+}
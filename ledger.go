@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// ledgerEntry - one line of the ledger, recording something that happened to a photo
+type ledgerEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // processed, renamed, failed, approved, rejected or published
+	Source string    `json:"source,omitempty"`
+	Path   string    `json:"path"`
+	Reason reason    `json:"reason,omitempty"`
+	Note   string    `json:"note,omitempty"`
+	Params string    `json:"params,omitempty"` // the settings a processed photo was written with
+	SHA256 string    `json:"sha256,omitempty"` // of the source, recorded with --follow-renames
+	Hash   string    `json:"hash,omitempty"`   // of the source instead of SHA256, with another --hash
+	Width  int       `json:"width,omitempty"`  // of a processed photo's output
+	Height int       `json:"height,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+}
+
+// sourceHash - return the hash of the source recorded in e, of whichever algorithm
+func (e ledgerEntry) sourceHash() string {
+	if len(e.Hash) > 0 {
+		return e.Hash
+	}
+	return e.SHA256
+}
+
+// withSourceHash - return e recording hash, as returned by hashFile, as the hash of
+// its source
+func (e ledgerEntry) withSourceHash(hash string) ledgerEntry {
+	if strings.Contains(hash, ":") {
+		e.Hash = hash
+	} else {
+		e.SHA256 = hash
+	}
+	return e
+}
+
+// ledger - an append-only JSON lines file recording what happened to every photo,
+// shared by batch runs and the review subcommands; a nil ledger records nothing
+type ledger struct {
+	mu     sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	params map[string]string      // the settings each output was last written with, by path
+	hashes map[string]ledgerEntry // the last output written from each source content, by hash
+}
+
+// readLedger - return the entries of the ledger at path, skipping lines that are
+// not entries; a ledger that does not exist has none
+func readLedger(path string) ([]ledgerEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []ledgerEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var e ledgerEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// openLedger - open the ledger at path for appending, creating it if needed, and
+// read the settings that earlier runs wrote each output with
+func openLedger(path string, mode os.FileMode) (*ledger, error) {
+	params := make(map[string]string)
+	hashes := make(map[string]ledgerEntry)
+	entries, err := readLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Event != "processed" && e.Event != "renamed" {
+			continue
+		}
+		if len(e.Params) > 0 {
+			params[filepath.Clean(e.Path)] = e.Params
+		}
+		if hash := e.sourceHash(); len(hash) > 0 {
+			hashes[hash] = e
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &ledger{f: f, enc: json.NewEncoder(f), params: params, hashes: hashes}, nil
+}
+
+// params - return the settings that decide what an output looks like, which the
+// ledger records so that outputs are processed again when they change
+func (opts *options) params() string {
+	background := "none"
+	if opts.background != nil {
+		c := color.NRGBAModel.Convert(opts.background).(color.NRGBA)
+		background = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	params := fmt.Sprintf("size=%dx%d percent=%g fit=%t format=%s dpi=%d background=%s",
+		opts.size.Width, opts.size.Height, opts.size.Percent, opts.size.Fit, opts.format, opts.dpi, background)
+	if opts.engine != "carve" {
+		params += " engine=" + opts.engine
+	}
+	if opts.engine == "smart" && opts.aspectTolerance != resizer.DefaultAspectTolerance {
+		params += fmt.Sprintf(" aspect-tolerance=%g", opts.aspectTolerance)
+	}
+	if opts.engine == "face-crop" && opts.cropMargin != defaultCropMargin {
+		params += fmt.Sprintf(" margin=%g", opts.cropMargin)
+	}
+	if opts.aspect.W > 0 {
+		params += " aspect=" + opts.aspect.String()
+		if opts.aspect.Pad {
+			params += " aspect-mode=pad"
+		}
+	}
+	if opts.seams != "both" {
+		params += " seams=" + opts.seams
+	}
+	if opts.maxCarve > 0 {
+		params += fmt.Sprintf(" max-carve=%g", opts.maxCarve)
+	}
+	if opts.scaler != "seam" {
+		params += " scaler=" + opts.scaler
+	}
+	if opts.quality != resizer.DefaultQuality {
+		params += fmt.Sprintf(" quality=%d", opts.quality)
+	}
+	if opts.stripMetadata {
+		params += " strip-metadata=true"
+	}
+	if opts.keepMetadata {
+		params += " keep-metadata=true"
+	}
+	if opts.preserveColor {
+		params += " preserve-color=true"
+	}
+	if alpha := opts.alpha.String(); alpha != defaultAlpha {
+		params += " alpha=" + alpha
+	}
+	return params
+}
+
+// paramsChanged - return true if the ledger shows that dest was last written with
+// other settings than params; outputs it has no settings for have not changed
+func (l *ledger) paramsChanged(dest, params string) bool {
+	if l == nil {
+		return false
+	}
+	recorded, ok := l.params[filepath.Clean(dest)]
+	return ok && recorded != params
+}
+
+// record - append e to the ledger, stamped with the current time
+func (l *ledger) record(e ledgerEntry) error {
+	if l == nil {
+		return nil
+	}
+	e.Time = clock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(e)
+}
+
+// recordProcessed - record the outcome of processing source, whose hash is hash
+// if known, into dest with the settings params, along with the dimensions and size
+// of dest; a file that was copied because it could not be resized still counts as
+// processed
+func (l *ledger) recordProcessed(source, dest, params, hash string, err error) {
+	if l == nil {
+		return
+	}
+	e := ledgerEntry{Event: "processed", Source: source, Path: dest, Params: params}.withSourceHash(hash)
+	if config, _, cerr := imageConfig(dest); cerr == nil {
+		e.Width, e.Height, e.Bytes = config.Width, config.Height, fileSize(dest)
+	}
+	if err != nil {
+		e.Reason, e.Note = reasonOf(err), err.Error()
+		if e.Reason != reasonFallbackCopy {
+			e.Event = "failed"
+		}
+	}
+	if err := l.record(e); err != nil {
+		logs.warn(logEntry{Action: "ledger"}.withErr(err), "Unable to write to ledger: %v\n", err)
+	}
+}
+
+// Close - close the ledger file
+func (l *ledger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
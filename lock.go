@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// output locks: a lock file next to an output, created exclusively, which works
+// across machines on network file systems where flock does not
+const (
+	lockWait  = 2 * time.Minute        // how long to wait for another instance to finish writing
+	lockStale = 10 * time.Minute       // locks older than this were left behind by an instance that died
+	lockRetry = 250 * time.Millisecond // how often a held lock is checked
+)
+
+// lockName - return the name of the lock file of the output dstname
+func lockName(dstname string) string {
+	return filepath.Join(filepath.Dir(dstname), "."+filepath.Base(dstname)+".lock")
+}
+
+// lockOutput - take the advisory lock on the output dstname shared by every
+// instance writing to the destination, waiting up to lockWait for it; the
+// returned function releases it
+func lockOutput(dstname string, mode os.FileMode) (func(), error) {
+	name := lockName(dstname)
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err == nil {
+			fmt.Fprintf(f, "%s %d %s\n", host, os.Getpid(), time.Now().Format(time.RFC3339))
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, withReason(reasonWrite, err)
+		}
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, withReason(reasonLocked, fmt.Errorf("%s is still being written by another instance, see %s", dstname, name))
+		}
+		time.Sleep(lockRetry)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// log levels, in the order of logLevels
+const (
+	levelInfo = iota
+	levelWarn
+	levelError
+)
+
+// logLevels - the levels of --log-level, from the most to the least verbose
+var logLevels = []string{"info", "warn", "error"}
+
+// logFormats - the formats of --log-format: text for people, json for log aggregation
+var logFormats = []string{"text", "json"}
+
+// parseLogLevel - return the level named s, or -1 if there is none
+func parseLogLevel(s string) int {
+	for i, name := range logLevels {
+		if name == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// validLogFormat - return true if format is one of logFormats
+func validLogFormat(format string) bool {
+	for _, f := range logFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// logEntry - what happened to a file, one line of --log-format json; only the
+// fields that apply are set
+type logEntry struct {
+	Time       string  `json:"time"`
+	Level      string  `json:"level"`
+	Action     string  `json:"action"`
+	File       string  `json:"file,omitempty"`
+	Dest       string  `json:"dest,omitempty"`
+	Reason     reason  `json:"reason,omitempty"`
+	DurationMS float64 `json:"duration_ms,omitempty"`
+	OldWidth   int     `json:"old_width,omitempty"`
+	OldHeight  int     `json:"old_height,omitempty"`
+	NewWidth   int     `json:"new_width,omitempty"`
+	NewHeight  int     `json:"new_height,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// withErr - return e with the error and reason code of err
+func (e logEntry) withErr(err error) logEntry {
+	if err != nil {
+		e.Error = err.Error()
+		if len(e.Reason) == 0 {
+			e.Reason = reasonOf(err)
+		}
+	}
+	return e
+}
+
+// since - return e with the time elapsed since start
+func (e logEntry) since(start time.Time) logEntry {
+	e.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	return e
+}
+
+// logger - writes what happens during a run either as the text it always has, with
+// info on stdout and warnings and errors on stderr, or as JSON lines on stdout
+type logger struct {
+	mu     sync.Mutex
+	json   bool
+	level  int
+	stderr bool // write info and JSON lines to stderr too, as stdout carries the image of --pipe
+}
+
+// stdout - return where info and JSON lines are written
+func (l *logger) stdout() io.Writer {
+	if l.stderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// logs - the logger of the run, set up by main from --log-format and --log-level
+var logs = &logger{level: levelInfo}
+
+// info - log e at the info level; text is the line(s) written in the text format,
+// where an empty format writes nothing, and the message of the JSON format
+func (l *logger) info(e logEntry, format string, args ...interface{}) {
+	l.write(levelInfo, e, format, args...)
+}
+
+// warn - log e at the warn level, like info
+func (l *logger) warn(e logEntry, format string, args ...interface{}) {
+	l.write(levelWarn, e, format, args...)
+}
+
+// error - log e at the error level, like info
+func (l *logger) error(e logEntry, format string, args ...interface{}) {
+	l.write(levelError, e, format, args...)
+}
+
+// write - log e at level, unless level is below --log-level
+func (l *logger) write(level int, e logEntry, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	text := fmt.Sprintf(format, args...)
+	if !l.json {
+		switch {
+		case len(text) == 0:
+		case level >= levelWarn:
+			log.Print(text)
+		default:
+			fmt.Fprint(l.stdout(), text)
+		}
+		return
+	}
+
+	e.Time = clock().Format(time.RFC3339)
+	e.Level = logLevels[level]
+	if len(e.Message) == 0 {
+		e.Message = logMessage(text, e.Reason)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stdout().Write(append(line, '\n'))
+}
+
+// logMessage - return text as a one line message, without the separator lines
+// and reason code of the text format
+func logMessage(text string, code reason) string {
+	var parts []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(code) > 0 {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "["+string(code)+"]"))
+		}
+		if len(line) > 0 && line != equalsLine {
+			parts = append(parts, line)
+		}
+	}
+	return strings.Join(parts, " ")
+}
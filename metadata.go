@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// pngSignature - the first bytes of every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// errBadSegments - the file ends in the middle of a segment or chunk
+var errBadSegments = errors.New("truncated or malformed image header")
+
+// jpegSegment - a marker segment of a JPEG file before its image data, where
+// data is everything after the segment's length
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments - return the segments of the JPEG data before the image data and
+// the offset of the start of scan marker where the image data begins
+func jpegSegments(data []byte) ([]jpegSegment, int, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, errors.New("not a JPEG file")
+	}
+	var segments []jpegSegment
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, 0, errBadSegments
+		}
+		marker := data[i+1]
+		if marker == 0xda {
+			return segments, i, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, 0, errBadSegments
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+}
+
+// isJPEGMetadata - return true if s describes the photo rather than how to decode
+// it: EXIF, GPS and XMP in APP1, IPTC in APP13, comments and the other application
+// segments, except JFIF in APP0, ICC color profiles in APP2 and Adobe's APP14
+func isJPEGMetadata(s jpegSegment) bool {
+	switch {
+	case s.marker == 0xfe:
+		return true
+	case s.marker == 0xe0, s.marker == 0xee:
+		return false
+	case s.marker == 0xe2:
+		return !bytes.HasPrefix(s.data, []byte("ICC_PROFILE\x00"))
+	}
+	return s.marker >= 0xe1 && s.marker <= 0xef
+}
+
+// writeJPEGSegment - append s to buf as a marker segment
+func writeJPEGSegment(buf *bytes.Buffer, s jpegSegment) {
+	buf.Write([]byte{0xff, s.marker})
+	binary.Write(buf, binary.BigEndian, uint16(len(s.data)+2))
+	buf.Write(s.data)
+}
+
+// stripJPEG - return the JPEG data without its metadata segments
+func stripJPEG(data []byte) ([]byte, error) {
+	segments, sos, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	for _, s := range segments {
+		if !isJPEGMetadata(s) {
+			writeJPEGSegment(&buf, s)
+		}
+	}
+	buf.Write(data[sos:])
+	return buf.Bytes(), nil
+}
+
+// pngMetadataChunks - the PNG chunks holding text, EXIF and modification times
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNG - return the PNG data without its metadata chunks
+func stripPNG(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errBadSegments
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, errBadSegments
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			buf.Write(data[i:end])
+		}
+		i = end
+	}
+	return buf.Bytes(), nil
+}
+
+// stripMetadata - return the image data without EXIF, GPS, IPTC, XMP, comments
+// and other metadata; formats other than JPEG and PNG are returned unchanged
+func stripMetadata(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return stripPNG(data)
+	}
+	return data, nil
+}
+
+// copyStripped - copy srcname to dstname like copyOutput, without its metadata
+func copyStripped(opts *options, srcname, dstname string) (int64, error) {
+	data, err := ioutil.ReadFile(srcname)
+	if err != nil {
+		return 0, err
+	}
+	if data, err = stripMetadata(data); err != nil {
+		return 0, err
+	}
+	f, err := createOutput(opts, dstname)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		f.Close()
+		return int64(n), err
+	}
+	return int64(n), f.Close()
+}
+
+// isExifOrXMP - return true if s is an APP1 segment holding EXIF or XMP data
+func isExifOrXMP(s jpegSegment) bool {
+	return s.marker == 0xe1 && (bytes.HasPrefix(s.data, []byte("Exif\x00\x00")) ||
+		bytes.HasPrefix(s.data, []byte("http://ns.adobe.com/xap/1.0/\x00")))
+}
+
+// encodeWithMetadata - write img to w as a JPEG like resizer.Encode, with the EXIF
+// and XMP segments of the JPEG file srcname, if it has any, after the JFIF header
+func encodeWithMetadata(w io.Writer, img image.Image, dpi, quality int, srcname string) error {
+	var kept []jpegSegment
+	if data, err := ioutil.ReadFile(srcname); err == nil {
+		if segments, _, err := jpegSegments(data); err == nil {
+			for _, s := range segments {
+				if isExifOrXMP(s) {
+					kept = append(kept, s)
+				}
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return resizer.Encode(w, img, "jpeg", dpi, quality)
+	}
+
+	var encoded bytes.Buffer
+	if err := resizer.Encode(&encoded, img, "jpeg", dpi, quality); err != nil {
+		return err
+	}
+	data := encoded.Bytes()
+	// EXIF belongs right after SOI or a JFIF APP0 segment
+	at := 2
+	if len(data) > 6 && data[2] == 0xff && data[3] == 0xe0 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:at])
+	for _, s := range kept {
+		writeJPEGSegment(&buf, s)
+	}
+	buf.Write(data[at:])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
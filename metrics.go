@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets - the upper bounds, in seconds, of the buckets of the
+// processing latency histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics - the counters, gauges and histograms that /metrics exposes in the
+// Prometheus text format, for alerting on batches that fail or slow down;
+// a nil metrics records nothing
+type metrics struct {
+	mu          sync.Mutex
+	outputs     map[string]int64 // by result: written, skipped, fallback or failed
+	failures    map[reason]int64
+	batches     map[string]int64 // by result: ok or failed
+	lastBatch   time.Time        // end of the last batch that had no failures
+	inProgress  int64
+	latency     []int64 // per bucket of latencyBuckets, not cumulative
+	latencySum  float64
+	latencyN    int64
+	queueLength func() int // jobs waiting to run, nil without --jobs-root
+}
+
+// newMetrics - return empty metrics
+func newMetrics() *metrics {
+	return &metrics{outputs: make(map[string]int64), failures: make(map[reason]int64), batches: make(map[string]int64),
+		latency: make([]int64, len(latencyBuckets)+1)}
+}
+
+// recordResult - count the outcome of one output of a source
+func (m *metrics) recordResult(r result) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := reasonOf(r.err)
+	switch {
+	case r.err != nil && code == reasonFallbackCopy:
+		m.outputs["fallback"]++
+	case r.err != nil:
+		m.outputs["failed"]++
+		if len(code) == 0 {
+			code = "UNKNOWN"
+		}
+		m.failures[code]++
+	case len(r.skip) > 0:
+		m.outputs["skipped"]++
+	default:
+		m.outputs["written"]++
+	}
+}
+
+// recordBatch - count a finished batch, which failed when err is not nil
+func (m *metrics) recordBatch(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.batches["failed"]++
+		return
+	}
+	m.batches["ok"]++
+	m.lastBatch = clock()
+}
+
+// start - count an image whose processing started, returning the func that
+// counts it as done and observes how long it took
+func (m *metrics) start() func() {
+	if m == nil {
+		return func() {}
+	}
+	began := time.Now()
+	m.mu.Lock()
+	m.inProgress++
+	m.mu.Unlock()
+	return func() {
+		seconds := time.Since(began).Seconds()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inProgress--
+		i := sort.SearchFloat64s(latencyBuckets, seconds)
+		m.latency[i]++
+		m.latencySum += seconds
+		m.latencyN++
+	}
+}
+
+// write - output the metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	labeled := func(name, label string, values map[string]int64) {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+		}
+	}
+
+	header("photo_id_resizer_outputs_total", "counter", "Outputs of sources handled, by result: written, skipped, fallback or failed.")
+	labeled("photo_id_resizer_outputs_total", "result", m.outputs)
+	header("photo_id_resizer_failures_total", "counter", "Outputs that failed, by reason code.")
+	failures := make(map[string]int64, len(m.failures))
+	for code, n := range m.failures {
+		failures[string(code)] = n
+	}
+	labeled("photo_id_resizer_failures_total", "reason", failures)
+	header("photo_id_resizer_batches_total", "counter", "Batches that finished, by result: ok or failed.")
+	labeled("photo_id_resizer_batches_total", "result", m.batches)
+	header("photo_id_resizer_last_success_timestamp_seconds", "gauge", "Time the last batch without failures finished, 0 for never.")
+	last := int64(0)
+	if !m.lastBatch.IsZero() {
+		last = m.lastBatch.Unix()
+	}
+	fmt.Fprintf(w, "photo_id_resizer_last_success_timestamp_seconds %d\n", last)
+	header("photo_id_resizer_in_progress", "gauge", "Images being processed.")
+	fmt.Fprintf(w, "photo_id_resizer_in_progress %d\n", m.inProgress)
+	header("photo_id_resizer_queue_depth", "gauge", "Jobs waiting to run.")
+	queued := 0
+	if m.queueLength != nil {
+		queued = m.queueLength()
+	}
+	fmt.Fprintf(w, "photo_id_resizer_queue_depth %d\n", queued)
+
+	header("photo_id_resizer_processing_seconds", "histogram", "Time taken to process an image.")
+	cumulative := int64(0)
+	for i, bound := range latencyBuckets {
+		cumulative += m.latency[i]
+		fmt.Fprintf(w, "photo_id_resizer_processing_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "photo_id_resizer_processing_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyN)
+	fmt.Fprintf(w, "photo_id_resizer_processing_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "photo_id_resizer_processing_seconds_count %d\n", m.latencyN)
+}
+
+// handleMetrics - serve GET /metrics
+func (m *metrics) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
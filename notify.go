@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// notifyTimeout - how long posting a notification may take
+const notifyTimeout = 10 * time.Second
+
+// notification - the JSON posted to --notify; Slack incoming webhooks show its text
+type notification struct {
+	Text     string `json:"text"`
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+	Source   string `json:"source"`
+	Dest     string `json:"dest"`
+}
+
+// notifier - posts a notification to a webhook as soon as the photo of a roster
+// entry with a priority has been written; a nil notifier notifies nobody
+type notifier struct {
+	url    string
+	client *http.Client
+	stats  *runStats
+}
+
+// newNotifier - return a notifier posting to url, counting its requests in stats
+func newNotifier(url string, stats *runStats) *notifier {
+	return &notifier{url: url, client: &http.Client{Timeout: notifyTimeout}, stats: stats}
+}
+
+// photoReady - notify that source was written to dest, if its roster entry has a priority
+func (n *notifier) photoReady(ro *roster, source, dest string) {
+	if n == nil {
+		return
+	}
+	priority := ro.priorityOf(source)
+	if priority <= 0 {
+		return
+	}
+	id := photoID(source)
+	body, err := json.Marshal(notification{
+		Text:     fmt.Sprintf("Priority photo ready: %s (%s)", id, dest),
+		ID:       id,
+		Priority: priority,
+		Source:   source,
+		Dest:     dest,
+	})
+	if err != nil {
+		logs.warn(logEntry{Action: "notify", File: source}.withErr(err), "Unable to notify %s: %v\n", n.url, err)
+		return
+	}
+	n.stats.recordCall("notify")
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logs.warn(logEntry{Action: "notify", File: source}.withErr(err), "Unable to notify %s: %v\n", n.url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logs.warn(logEntry{Action: "notify", File: source, Error: resp.Status}, "Unable to notify %s: %s\n", n.url, resp.Status)
+	}
+}
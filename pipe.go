@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// runPipe - the --pipe mode, process the one image read from stdin as a batch
+// run would and write its output to stdout, for shell pipelines and sidecars;
+// the image is kept in a temporary directory under --tmp-dir meanwhile
+func runPipe(opts *options, p *caire.Processor) int {
+	dir, err := ioutil.TempDir(opts.tmpDir, pgmName)
+	if err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to create a temporary directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	opts.source, opts.dest = filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := os.Mkdir(opts.source, 0700); err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to create a temporary directory: %v\n", err)
+		return 1
+	}
+
+	// the format of the image, and so the name process() sees, comes from its content
+	srcname := filepath.Join(opts.source, "stdin")
+	in, err := os.OpenFile(srcname, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = io.Copy(in, os.Stdin)
+		if cerr := in.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to read the image from stdin: %v\n", err)
+		return 1
+	}
+	format := resizer.ContentFormat(srcname)
+	if len(format) == 0 {
+		err = withReason(reasonUnsupported, errors.New("unsupported image format"))
+		logs.error(logEntry{Action: "pipe", Reason: reasonUnsupported}.withErr(err), "Unable to read the image from stdin: %v\n", err)
+		return 1
+	}
+	named := resizer.ReplaceExt(srcname, format)
+	if err := os.Rename(srcname, named); err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "%v\n", err)
+		return 1
+	}
+
+	dstname := destName(opts, named)
+	if err := os.MkdirAll(filepath.Dir(dstname), opts.dirMode); err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to create a temporary directory: %v\n", err)
+		return 1
+	}
+	if err := process(p, opts, dstname, named); err != nil && reasonOf(err) != reasonFallbackCopy {
+		return 1
+	}
+	out, err := os.Open(outputName(opts, dstname))
+	if err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to read the output: %v\n", err)
+		return 1
+	}
+	defer out.Close()
+	if _, err := io.Copy(os.Stdout, out); err != nil {
+		logs.error(logEntry{Action: "pipe"}.withErr(err), "Unable to write the output to stdout: %v\n", err)
+		return 1
+	}
+	return 0
+}
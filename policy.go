@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// condition - a problem that can end the run, fail only the file it happens to, or
+// only be warned about, depending on the policy; lenient and strict are what it
+// does by default and with --strict
+type condition struct {
+	lenient, strict string
+	actions         []string // the actions it allows
+	description     string
+}
+
+// conditions - the problems whose handling --strict and --policy decide
+var conditions = map[string]condition{
+	"regex":      {"warn", "fatal", []string{"fatal", "warn"}, "-m or -x is not a valid regular expression; warn matches it as plain text"},
+	"classifier": {"fatal", "fatal", []string{"fatal", "warn"}, "the -f classification file is missing; warn resizes without face detection"},
+	"source":     {"fail", "fatal", []string{"fatal", "fail"}, "a source file can not be found when it is processed"},
+	"mkdir":      {"fail", "fatal", []string{"fatal", "fail"}, "a destination subdirectory can not be created"},
+	"output":     {"fail", "fatal", []string{"fatal", "fail"}, "a destination file can not be created"},
+	"decode":     {"warn", "fail", []string{"fatal", "fail", "warn"}, "a source can not be decoded or resized; warn copies it unchanged with " + string(reasonFallbackCopy)},
+}
+
+// policy - the action of each condition in this run
+var policy = make(map[string]string)
+
+// setPolicy - set the action of every condition, the strict ones when strict is
+// true, and then those of overrides such as mkdir=fail
+func setPolicy(strict bool, overrides []string) error {
+	for name, c := range conditions {
+		policy[name] = c.lenient
+		if strict {
+			policy[name] = c.strict
+		}
+	}
+	for _, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		c, ok := conditions[parts[0]]
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("invalid policy: %s", override)
+		}
+		allowed := false
+		for _, action := range c.actions {
+			allowed = allowed || action == parts[1]
+		}
+		if !allowed {
+			return fmt.Errorf("%s can only be %s", parts[0], strings.Join(c.actions, " or "))
+		}
+		policy[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// fatalError - an error that the policy of condition makes end the run; the
+// workers stop taking files, the ones being processed are finished and the run
+// is reported on before the program exits
+type fatalError struct {
+	condition string
+	err       error
+}
+
+func (e *fatalError) Error() string {
+	return fmt.Sprintf("%v, which --policy %s makes fatal", e.err, e.condition)
+}
+
+func (e *fatalError) Unwrap() error {
+	return e.err
+}
+
+// isFatal - return true if err is to end the run, see applyPolicy
+func isFatal(err error) bool {
+	var fe *fatalError
+	return errors.As(err, &fe)
+}
+
+// applyPolicy - return err for the file it happened to to fail or be warned about,
+// or as a fatalError that ends the run when the policy of condition is fatal
+func applyPolicy(name string, err error) error {
+	if policy[name] == "fatal" {
+		return &fatalError{condition: name, err: err}
+	}
+	return err
+}
+
+// fallbackCopy - handle err, a source that could not be decoded, resized or written
+// in its format, by the decode policy: when it warns, copy the source unchanged and
+// return err as FALLBACK_COPY, otherwise fail the file or end the run
+func fallbackCopy(opts *options, srcname, dstname string, err error) error {
+	if err = applyPolicy("decode", err); policy["decode"] != "warn" {
+		return err
+	}
+	copyOutput(opts, srcname, dstname)
+	return withReason(reasonFallbackCopy, err)
+}
+
+// listPolicies - the list-policies subcommand, output what each condition does by
+// default and with --strict
+func listPolicies(args []string) int {
+	names := make([]string, 0, len(conditions))
+	for name := range conditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%-12s %-8s %-8s %s\n", "condition", "default", "strict", "description")
+	for _, name := range names {
+		c := conditions[name]
+		fmt.Printf("%-12s %-8s %-8s %s\n", name, c.lenient, c.strict, c.description)
+	}
+	return 0
+}
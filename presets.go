@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// preset - a named set of output settings for a common photo ID standard
+type preset struct {
+	description string
+	width       int    // exact output width in pixels
+	height      int    // exact output height in pixels
+	dpi         int    // pixel density stamped into JPEG output, 0 for none
+	format      string // output format
+	background  string // color transparent areas are flattened onto, empty for none
+	headMin     int    // smallest head height as a percent of the photo's height, 0 for no rule
+	headMax     int    // largest head height as a percent of the photo's height
+}
+
+// presets - all of the built-in presets, selected with --preset
+var presets = map[string]preset{
+	"us-passport": {
+		description: "US passport and visa, 2x2 inches",
+		width:       600,
+		height:      600,
+		dpi:         300,
+		format:      "jpeg",
+		background:  "#ffffff",
+		headMin:     50,
+		headMax:     69,
+	},
+	"eu-visa": {
+		description: "Schengen visa and most EU documents, 35x45 mm",
+		width:       413,
+		height:      531,
+		dpi:         300,
+		format:      "jpeg",
+		background:  "#f0f0f0",
+		headMin:     71,
+		headMax:     80,
+	},
+	"eu-id": {
+		description: "EU national identity cards and residence permits, 35x45 mm",
+		width:       413,
+		height:      531,
+		dpi:         300,
+		format:      "jpeg",
+		background:  "#ffffff",
+		headMin:     71,
+		headMax:     80,
+	},
+	"cr80-badge": {
+		description: "CR80 employee badge, 1x1.25 inches",
+		width:       300,
+		height:      375,
+		dpi:         300,
+		format:      "jpeg",
+		background:  "#ffffff",
+		headMin:     50,
+		headMax:     70,
+	},
+	"ad-thumbnail": {
+		description: "Active Directory / Exchange thumbnailPhoto",
+		width:       96,
+		height:      96,
+		format:      "jpeg",
+		background:  "#ffffff",
+	},
+	"linkedin": {
+		description: "LinkedIn profile photo",
+		width:       400,
+		height:      400,
+		format:      "jpeg",
+	},
+}
+
+// millimetersToPixels - return the number of pixels that mm millimeters take at
+// dpi pixels per inch, 0 for 0
+func millimetersToPixels(mm float64, dpi int) int {
+	return int(math.Round(mm / 25.4 * float64(dpi)))
+}
+
+// gcd - greatest common divisor, used to reduce an aspect ratio
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// aspect - return the preset's aspect ratio, such as 3:4
+func (p preset) aspect() string {
+	d := gcd(p.width, p.height)
+	return fmt.Sprintf("%d:%d", p.width/d, p.height/d)
+}
+
+// listPresets - the list-presets subcommand, output the built-in presets
+func listPresets(args []string) int {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := presets[name]
+		fmt.Printf("%s\n    %s\n", name, p.description)
+		fmt.Printf("    size: %dx%d  aspect: %s  format: %s", p.width, p.height, p.aspect(), p.format)
+		if p.dpi > 0 {
+			fmt.Printf("  dpi: %d", p.dpi)
+		}
+		if len(p.background) > 0 {
+			fmt.Printf("  background: %s", p.background)
+		}
+		if p.headMin > 0 {
+			fmt.Printf("  head: %d-%d%%", p.headMin, p.headMax)
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+// headPerFace - how much taller a head, from the chin to the top of the hair, is
+// than the face that the detector finds, which leaves out the forehead and hair
+const headPerFace = 1.3
+
+// framingMargin - return the margin that face-crop keeps around a face for the
+// head to fill the middle of headMin to headMax percent of a w x h photo's height
+func framingMargin(headMin, headMax float64, w, h int) float64 {
+	// crops narrower than a square are heightened to their aspect ratio, see resizer.CropToFace
+	fill := math.Min(float64(w)/float64(h), 1) * headPerFace * 100 / ((headMin + headMax) / 2)
+	return math.Max(math.Round((fill-1)/2*1000)/10, 0)
+}
+
+// headFraction - return the height of the head around f as a percent of the height h
+func headFraction(f resizer.Face, h int) float64 {
+	return float64(f.Dy()) * headPerFace * 100 / float64(h)
+}
+
+// lookupPreset - return the named preset or exit with the list of valid names
+func lookupPreset(name string) preset {
+	p, ok := presets[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "\nUnknown preset: %s\n\n", name)
+		listPresets(nil)
+		os.Exit(1)
+	}
+	return p
+}
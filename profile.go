@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// profileName - the name of a file in a source subdirectory that overrides
+// settings for every file in that subtree
+const profileName = ".photo_id_resizer.yaml"
+
+// profileKeys - the settings a profile can override, named after their flags
+var profileKeys = map[string]bool{
+	"preset":     true,
+	"max-size":   true,
+	"fit":        true,
+	"max-width":  true,
+	"max-height": true,
+	"format":     true,
+	"quality":    true,
+	"engine":     true,
+	"scaler":     true,
+}
+
+// profileCache - the profiles found under a source directory, each read once
+type profileCache struct {
+	root string
+	mu   sync.Mutex
+	dirs map[string]map[string]string // settings of each directory, nil when it has no profile
+}
+
+// newProfileCache - return a cache of the profiles under root
+func newProfileCache(root string) *profileCache {
+	return &profileCache{root: filepath.Clean(root), dirs: make(map[string]map[string]string)}
+}
+
+// load - return the settings in dir's profile, or nil if it has none
+func (pc *profileCache) load(dir string) (map[string]string, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if settings, ok := pc.dirs[dir]; ok {
+		return settings, nil
+	}
+	settings, err := loadProfileFile(filepath.Join(dir, profileName))
+	if os.IsNotExist(err) {
+		pc.dirs[dir] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pc.dirs[dir] = settings
+	return settings, nil
+}
+
+// loadProfileFile - return the settings in the profile file, which need not be
+// named profileName, such as those that --routes refer to
+func loadProfileFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseFlatYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for key := range settings {
+		if !profileKeys[key] {
+			return nil, fmt.Errorf("%s: unknown setting: %s", file, key)
+		}
+	}
+	return settings, nil
+}
+
+// settings - return the directories with profiles that apply to path and their
+// settings, from the source directory down to path's own directory, so that
+// deeper profiles take precedence
+func (pc *profileCache) settings(path string) (dirs []string, profiles []map[string]string, err error) {
+	var parents []string
+	for dir := filepath.Dir(filepath.Clean(path)); ; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if dir == pc.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for i := len(parents) - 1; i >= 0; i-- {
+		settings, err := pc.load(parents[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if settings != nil {
+			dirs = append(dirs, parents[i])
+			profiles = append(profiles, settings)
+		}
+	}
+	return dirs, profiles, nil
+}
+
+// applyProfile - return a copy of opts with the settings of a profile applied;
+// like on the command line, a size or format given with a preset takes precedence
+func applyProfile(opts *options, settings map[string]string) (*options, error) {
+	o := *opts
+	if name, ok := settings["preset"]; ok {
+		pr, found := presets[name]
+		if !found {
+			return nil, fmt.Errorf("unknown preset: %s", name)
+		}
+		o.size = resizer.Size{Width: pr.width, Height: pr.height}
+		o.format, o.dpi, o.background = pr.format, pr.dpi, nil
+		if len(pr.background) > 0 {
+			o.background, _ = resizer.ParseColor(pr.background)
+		}
+		o.headMin, o.headMax = float64(pr.headMin), float64(pr.headMax)
+	}
+
+	var err error
+	switch {
+	case len(settings["max-size"]) > 0:
+		o.size, err = resizer.ParseSize(settings["max-size"], false)
+	case len(settings["fit"]) > 0:
+		o.size, err = resizer.ParseSize(settings["fit"], true)
+	case len(settings["max-width"]) > 0 || len(settings["max-height"]) > 0:
+		var w, h int
+		if w, err = resizer.ParseDimension(settings["max-width"]); err == nil {
+			h, err = resizer.ParseDimension(settings["max-height"])
+		}
+		o.size = resizer.Size{Width: w, Height: h}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if o.headMin > 0 && !o.marginSet && o.size.Width > 0 && o.size.Height > 0 {
+		// frame the head as the preset requires, for the size the profile leaves
+		o.cropMargin = framingMargin(o.headMin, o.headMax, o.size.Width, o.size.Height)
+	}
+	if format, ok := settings["format"]; ok {
+		if o.format, err = resizer.ParseFormat(format); err != nil {
+			return nil, err
+		}
+	}
+	if quality, ok := settings["quality"]; ok {
+		if o.quality, err = strconv.Atoi(quality); err != nil || o.quality < 1 || o.quality > 100 {
+			return nil, fmt.Errorf("invalid quality: %s", quality)
+		}
+	}
+	if engine, ok := settings["engine"]; ok {
+		if !validEngine(engine) {
+			return nil, fmt.Errorf("invalid engine: %s", engine)
+		}
+		o.engine = engine
+	}
+	if scaler, ok := settings["scaler"]; ok {
+		if !validScaler(scaler) {
+			return nil, fmt.Errorf("invalid scaler: %s", scaler)
+		}
+		o.scaler = scaler
+	}
+	return &o, nil
+}
+
+// sizeStep - one of the --sizes, written to the subdirectory name
+type sizeStep struct {
+	name string
+	size resizer.Size
+}
+
+// parseSizes - parse a comma separated list of sizes as accepted by --max-size,
+// such as 96x96,240x240,648x648
+func parseSizes(spec string) ([]sizeStep, error) {
+	var steps []sizeStep
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(spec, ",") {
+		name := strings.ToLower(strings.TrimSpace(s))
+		size, err := resizer.ParseSize(name, false)
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("size given twice: %s", name)
+		}
+		seen[name] = true
+		steps = append(steps, sizeStep{name: name, size: size})
+	}
+	return steps, nil
+}
+
+// ladder - return a copy of opts for each of the --sizes, with that size and writing
+// to a subdirectory of the destination named after it, or only opts without --sizes
+func (opts *options) ladder() []*options {
+	if len(opts.sizes) == 0 {
+		return []*options{opts}
+	}
+	ladder := make([]*options, 0, len(opts.sizes))
+	for _, step := range opts.sizes {
+		copied := *opts
+		copied.size = step.size
+		copied.dest = filepath.Join(opts.dest, step.name)
+		ladder = append(ladder, &copied)
+	}
+	return ladder
+}
+
+// forFile - return the options for path, with the profiles of the directories
+// it is in applied, followed by the first of the --routes it matches and its
+// --manifest entry; opts itself is returned when there are none
+func (opts *options) forFile(path string) (*options, error) {
+	o := opts
+	if opts.profiles != nil {
+		dirs, profiles, err := opts.profiles.settings(path)
+		if err != nil {
+			return nil, withReason(reasonProfile, err)
+		}
+		for i, settings := range profiles {
+			if o, err = applyProfile(o, settings); err != nil {
+				return nil, withReason(reasonProfile, fmt.Errorf("%s: %v", filepath.Join(dirs[i], profileName), err))
+			}
+		}
+	}
+	if rt := routeFor(opts.routes, path); rt != nil {
+		var err error
+		if rt.settings != nil {
+			if o, err = applyProfile(o, rt.settings); err != nil {
+				return nil, withReason(reasonProfile, fmt.Errorf("%s: %v", rt.profile, err))
+			}
+		} else {
+			copied := *o
+			o = &copied
+		}
+		o.dest = filepath.Join(o.dest, rt.dest)
+		logs.info(logEntry{Action: "route", File: path, Dest: o.dest}, "    routed to %s by %s %s\n", o.dest, rt.field, rt.match)
+	}
+	if e := opts.manifest.entry(path); e != nil && (e.settings != nil || len(e.output) > 0) {
+		var err error
+		if e.settings != nil {
+			if o, err = applyProfile(o, e.settings); err != nil {
+				return nil, withReason(reasonProfile, fmt.Errorf("%s: %v", opts.manifest.file, err))
+			}
+		} else {
+			copied := *o
+			o = &copied
+		}
+		o.manifestName = e.output
+	}
+	return o, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// resolvePath - return path as an absolute path with symbolic links resolved, so
+// that two names of the same directory compare equal; the parts of path that do
+// not exist yet are kept as given
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	var missing []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return abs
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+	}
+}
+
+// pathWithin - return true if path is dir or is inside of it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(resolvePath(dir), resolvePath(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)))
+}
+
+// nestedDest - return dest relative to source when it is inside of it, so that
+// the walk can leave out the outputs, or an error when source is dest or inside
+// of it, as outputs could then overwrite sources
+func nestedDest(source, dest string) (string, error) {
+	if pathWithin(source, dest) {
+		return "", fmt.Errorf("the source directory %s is the destination directory %s or inside of it", source, dest)
+	}
+	if !pathWithin(dest, source) {
+		return "", nil
+	}
+	return filepath.Rel(resolvePath(source), resolvePath(dest))
+}
+
+// checkReadonlySource - the --assert-readonly-source interlock, return an error if
+// the source and destination overlap, if any modifiers, descriptions of the requested
+// features that move or write files in the source directory, are given, or if any
+// of the outputs, files or directories keyed by their flag, are inside the source
+func checkReadonlySource(source, dest string, modifiers []string, outputs map[string]string) error {
+	if len(dest) > 0 {
+		switch {
+		case pathWithin(dest, source) && pathWithin(source, dest):
+			return fmt.Errorf("the source and destination are the same directory: %s", source)
+		case pathWithin(dest, source):
+			return fmt.Errorf("the destination %s is inside the source %s", dest, source)
+		case pathWithin(source, dest):
+			return fmt.Errorf("the source %s is inside the destination %s", source, dest)
+		}
+	}
+	if len(modifiers) > 0 {
+		return errors.New(modifiers[0])
+	}
+	flags := make([]string, 0, len(outputs))
+	for name, path := range outputs {
+		if len(path) > 0 && pathWithin(path, source) {
+			flags = append(flags, name)
+		}
+	}
+	if len(flags) > 0 {
+		sort.Strings(flags)
+		return fmt.Errorf("%s %s is inside the source", flags[0], outputs[flags[0]])
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// reason - a stable, machine-readable code explaining why a file was skipped,
+// failed or was handled differently than requested.  Codes are never renamed
+// so that scripts can rely on them; SKIP_ codes are files left alone on purpose,
+// ERR_ codes are processing errors, FAIL_ codes are --gate rule violations or
+// differences from --golden outputs and
+// FALLBACK_ codes are files written differently than requested.
+type reason string
+
+const (
+	reasonSkipRegex      reason = "SKIP_REGEX"
+	reasonSkipIrregular  reason = "SKIP_NOT_REGULAR"
+	reasonSkipAge        reason = "SKIP_AGE"
+	reasonSkipConflict   reason = "SKIP_CONFLICT"
+	reasonSkipShard      reason = "SKIP_SHARD"
+	reasonSkipCompliant  reason = "SKIP_COMPLIANT"
+	reasonSkipInProgress reason = "SKIP_IN_PROGRESS"
+	reasonSkipExists     reason = "SKIP_EXISTS"
+	reasonSkipUpToDate   reason = "SKIP_UP_TO_DATE"
+	reasonSkipCached     reason = "SKIP_CACHED"
+	reasonSkipResumed    reason = "SKIP_RESUMED"
+
+	reasonStat         reason = "ERR_STAT"
+	reasonDecode       reason = "ERR_DECODE"
+	reasonUnsupported  reason = "ERR_UNSUPPORTED_FORMAT"
+	reasonResize       reason = "ERR_RESIZE"
+	reasonEncode       reason = "ERR_ENCODE"
+	reasonWrite        reason = "ERR_WRITE"
+	reasonMkdir        reason = "ERR_MKDIR"
+	reasonLeaseExpired reason = "ERR_LEASE_EXPIRED"
+	reasonProfile      reason = "ERR_PROFILE"
+	reasonTooLarge     reason = "ERR_TOO_LARGE"
+	reasonCopyMismatch reason = "ERR_COPY_MISMATCH"
+	reasonWindowsName  reason = "ERR_WINDOWS_NAME"
+	reasonLocked       reason = "ERR_LOCKED"
+	reasonSourceEdited reason = "ERR_SOURCE_CHANGED"
+	reasonTransparent  reason = "ERR_TRANSPARENT"
+	reasonScanFailed   reason = "ERR_SCAN"
+	reasonScanRejected reason = "ERR_SCAN_REJECTED"
+	reasonFetch        reason = "ERR_FETCH"
+
+	reasonFormat        reason = "FAIL_FORMAT"
+	reasonDimensions    reason = "FAIL_DIMENSIONS"
+	reasonNoFace        reason = "FAIL_NO_FACE"
+	reasonMultipleFaces reason = "FAIL_MULTIPLE_FACES"
+	reasonBackground    reason = "FAIL_BACKGROUND"
+	reasonBlurry        reason = "FAIL_BLURRY"
+	reasonHeadSize      reason = "FAIL_HEAD_SIZE"
+
+	reasonGoldenMissing    reason = "FAIL_GOLDEN_MISSING"
+	reasonGoldenDimensions reason = "FAIL_GOLDEN_DIMENSIONS"
+	reasonGoldenDiffers    reason = "FAIL_GOLDEN_DIFFERS"
+
+	reasonFallbackCopy reason = "FALLBACK_COPY"
+)
+
+// reasonDescriptions - a short explanation of every reason code, output by list-reasons
+var reasonDescriptions = map[reason]string{
+	reasonSkipRegex:      "file name is excluded by -x or does not match -m",
+	reasonSkipIrregular:  "not a regular file",
+	reasonSkipAge:        "file is older than -a or --max-age allows",
+	reasonSkipConflict:   "a newer source has the same destination name, see --conflict-strategy",
+	reasonSkipShard:      "file is handled by another --shard",
+	reasonSkipCompliant:  "destination file already has the target size and format, see --skip-compliant",
+	reasonSkipInProgress: "file is still being uploaded, or disappeared while waiting for --settle",
+	reasonSkipExists:     "destination file already exists, see --missing-only",
+	reasonSkipUpToDate:   "destination file is newer than the source, see --newer-only",
+	reasonSkipCached:     "a source with the same content was processed with the same settings before, see --cache-file",
+	reasonSkipResumed:    "source was completed by the interrupted run being resumed, see --resume",
+
+	reasonStat:         "source file could not be opened",
+	reasonDecode:       "file is not a readable image",
+	reasonUnsupported:  "image format can not be written",
+	reasonResize:       "image could not be resized",
+	reasonEncode:       "resized image could not be encoded",
+	reasonWrite:        "destination file could not be written",
+	reasonMkdir:        "destination directory could not be created",
+	reasonProfile:      "a .photo_id_resizer.yaml profile that applies to the file is invalid",
+	reasonLeaseExpired: "no worker finished the file within --lease, however many times it was handed out",
+	reasonTooLarge:     "image has more pixels than --max-megapixels allows, so it was not decoded",
+	reasonCopyMismatch: "a copy sampled by --verify-copies does not have the same checksum as its source, in the --hash algorithm",
+	reasonWindowsName:  "the destination name is a reserved device name or otherwise not allowed on Windows, see --windows-names",
+	reasonLocked:       "another instance held the --lock-outputs lock on the destination file for too long",
+	reasonSourceEdited: "source file changed after the --snapshot at the start of the run; it is processed anyway unless --snapshot-changes is skip",
+	reasonTransparent:  "image has transparent pixels and is written as JPEG with --alpha error",
+	reasonScanFailed:   "source could not be scanned with --scan-command or --scan-icap, so it was not decoded",
+	reasonScanRejected: "the --scan-command or --scan-icap scanner rejected the source, which was not decoded and was moved to the --quarantine, if given",
+	reasonFetch:        "image could not be downloaded from its --urls URL, after any retries",
+
+	reasonFormat:        "image is not in the --format or preset format",
+	reasonDimensions:    "image does not have the configured size",
+	reasonNoFace:        "no face was found",
+	reasonMultipleFaces: "more than one face was found",
+	reasonBackground:    "background beside the head is not uniform or not close to the preset's color",
+	reasonBlurry:        "face is out of focus",
+	reasonHeadSize:      "head is smaller or larger than the preset's share of the photo's height",
+
+	reasonGoldenMissing:    "there is no file with the output's name in the --golden directory",
+	reasonGoldenDimensions: "output does not have the same size as its --golden file",
+	reasonGoldenDiffers:    "output looks different from its --golden file, see --golden-distance",
+
+	reasonFallbackCopy: "image could not be resized, so the original was copied instead",
+}
+
+// reasonError - an error tagged with the reason code that classifies it
+type reasonError struct {
+	code reason
+	err  error
+}
+
+func (e *reasonError) Error() string {
+	return fmt.Sprintf("[%s] %v", e.code, e.err)
+}
+
+func (e *reasonError) Unwrap() error {
+	return e.err
+}
+
+// withReason - tag err with a reason code, nil stays nil
+func withReason(code reason, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &reasonError{code: code, err: err}
+}
+
+// reasonOf - return the reason code err was tagged with, or an empty string
+func reasonOf(err error) reason {
+	var re *reasonError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return ""
+}
+
+// listReasons - the list-reasons subcommand, output every reason code
+func listReasons(args []string) int {
+	codes := make([]string, 0, len(reasonDescriptions))
+	for code := range reasonDescriptions {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("%-24s %s\n", code, reasonDescriptions[reason(code)])
+	}
+	return 0
+}
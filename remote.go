@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// remoteSchemes - the URL schemes that -s and -d accept in place of a directory
+var remoteSchemes = []string{"s3://", "gs://", "sftp://", "webdav://", "webdavs://"}
+
+// isRemoteURL - return true if s names a bucket rather than a local directory
+func isRemoteURL(s string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket - a bucket and key prefix of Amazon S3, given as s3://bucket/prefix, of
+// Google Cloud Storage, given as gs://bucket/prefix, or a server and directory
+// of SFTP, given as sftp://user@host/path, or of WebDAV, given as
+// webdavs://user@host/path, whose keys are paths relative to /; exactly one
+// client is set
+type bucket struct {
+	scheme string
+	name   string
+	prefix string // empty or ends with a slash
+	s3     *s3.Client
+	gcs    *storage.BucketHandle
+	sftp   *sftpPool
+	dav    *webdavClient
+	stats  *runStats // that requests and transfers are counted in, nil for none
+}
+
+// parseBucketURL - split s into its scheme, bucket name and prefix
+func parseBucketURL(s string) (*bucket, error) {
+	for _, scheme := range remoteSchemes {
+		if !strings.HasPrefix(s, scheme) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(s, scheme), "/", 2)
+		b := &bucket{scheme: scheme, name: parts[0]}
+		if len(parts) == 2 && len(parts[1]) > 0 {
+			b.prefix = strings.TrimSuffix(parts[1], "/") + "/"
+		}
+		if len(b.name) == 0 {
+			break
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid bucket URL: %s", s)
+}
+
+// String - return the bucket and prefix as a URL
+func (b *bucket) String() string {
+	return b.scheme + b.name + "/" + b.prefix
+}
+
+// service - return the name that requests to the bucket are counted under
+func (b *bucket) service() string {
+	switch {
+	case b.s3 != nil:
+		return "s3"
+	case b.sftp != nil:
+		return "sftp"
+	case b.dav != nil:
+		return "webdav"
+	}
+	return "gcs"
+}
+
+// call - count a request made to the bucket
+func (b *bucket) call() {
+	b.stats.recordCall(b.service())
+}
+
+// list - call found with every object under the prefix, from one goroutine
+func (b *bucket) list(ctx context.Context, workers int, found func(key string, info remoteObject) error) error {
+	switch {
+	case b.s3 != nil:
+		return b.listS3(ctx, found)
+	case b.sftp != nil:
+		return b.listSFTP(ctx, found)
+	case b.dav != nil:
+		return b.listWebDAV(ctx, found)
+	}
+	return b.listGCS(ctx, workers, found)
+}
+
+// download - write the object key to w
+func (b *bucket) download(ctx context.Context, key string, w io.Writer) error {
+	switch {
+	case b.s3 != nil:
+		return b.downloadS3(ctx, key, w)
+	case b.sftp != nil:
+		return b.downloadSFTP(ctx, key, w)
+	case b.dav != nil:
+		return b.downloadWebDAV(ctx, key, w)
+	}
+	return b.downloadGCS(ctx, key, w)
+}
+
+// upload - write the file f to the object key
+func (b *bucket) upload(ctx context.Context, key string, f *os.File, contentType string) error {
+	switch {
+	case b.s3 != nil:
+		return b.uploadS3(ctx, key, f, contentType)
+	case b.sftp != nil:
+		return b.uploadSFTP(ctx, key, f)
+	case b.dav != nil:
+		return b.uploadWebDAV(ctx, key, f, contentType)
+	}
+	return b.uploadGCS(ctx, key, f, contentType)
+}
+
+// remoteObject - the os.FileInfo of an object listed in the source bucket, so
+// that the usual filters apply to it
+type remoteObject struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (o remoteObject) Name() string       { return o.name }
+func (o remoteObject) Size() int64        { return o.size }
+func (o remoteObject) Mode() os.FileMode  { return 0644 }
+func (o remoteObject) ModTime() time.Time { return o.modTime }
+func (o remoteObject) IsDir() bool        { return false }
+func (o remoteObject) Sys() interface{}   { return nil }
+
+// remoteSync - the buckets of -s and -d given as s3://, gs://, sftp:// or
+// WebDAV URLs.  The rest
+// of the program sees local directories under --tmp-dir in their place: each
+// source object is downloaded shortly before a worker processes it and removed
+// after, and each output is uploaded as soon as it is written and then removed,
+// so that only the files being processed are ever kept locally.  A nil
+// remoteSync is used when both directories are local.
+type remoteSync struct {
+	source      *bucket // nil for a local source
+	dest        *bucket // nil for a local destination
+	tmp         *scratch
+	urls        *urlFetcher // the --urls that replace the source, nil for none
+	localSource string      // where source objects are downloaded to
+	localDest   string      // where outputs are written before they are uploaded
+}
+
+// newRemoteSync - return the remoteSync of source and dest, either of which may
+// be a local directory; the S3 client uses the credentials and region of the AWS
+// environment or shared configuration, or s3Endpoint for an S3 compatible
+// service, the GCS client gcsCredentials or the application default credentials,
+// the SFTP connections sftpKey or the SSH agent, up to workers per server, and
+// the WebDAV requests the password in webdavPassword; the requests and bytes
+// sent to each bucket are counted in stats
+func newRemoteSync(source, dest, s3Endpoint, gcsCredentials, sftpKey, sftpKnownHosts, webdavPassword, tmpDir string, workers int, stats *runStats) (*remoteSync, error) {
+	r := &remoteSync{}
+	var s3Client *s3.Client
+	var gcsClient *storage.Client
+	sftpPools := make(map[string]*sftpPool)
+	for _, loc := range []struct {
+		url string
+		b   **bucket
+	}{{source, &r.source}, {dest, &r.dest}} {
+		if !isRemoteURL(loc.url) {
+			continue
+		}
+		b, err := parseBucketURL(loc.url)
+		if err != nil {
+			return nil, err
+		}
+		b.stats = stats
+		switch b.scheme {
+		case "s3://":
+			if s3Client == nil {
+				if s3Client, err = newS3Client(s3Endpoint); err != nil {
+					return nil, err
+				}
+			}
+			b.s3 = s3Client
+		case "gs://":
+			if gcsClient == nil {
+				if gcsClient, err = newGCSClient(gcsCredentials); err != nil {
+					return nil, err
+				}
+			}
+			b.gcs = gcsClient.Bucket(b.name)
+		case "sftp://":
+			if sftpPools[b.name] == nil {
+				// the walker holds one connection while it lists the source
+				if sftpPools[b.name], err = newSFTPPool(b.name, sftpKey, sftpKnownHosts, workers+1); err != nil {
+					return nil, err
+				}
+			}
+			b.sftp = sftpPools[b.name]
+		case "webdav://", "webdavs://":
+			if b.dav, err = newWebDAVClient(b.scheme, b.name, webdavPassword); err != nil {
+				return nil, err
+			}
+			b.dav.stats = stats
+		}
+		*loc.b = b
+	}
+
+	var err error
+	if r.tmp, err = newScratch(tmpDir); err != nil {
+		return nil, err
+	}
+	r.localSource, r.localDest = filepath.Join(r.tmp.root, "source"), filepath.Join(r.tmp.root, "dest")
+	for _, dir := range []string{r.localSource, r.localDest} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			r.tmp.cleanup()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// remoteSource - return true if the source is a bucket or --urls rather than a
+// local directory
+func (r *remoteSync) remoteSource() bool {
+	return r != nil && (r.source != nil || r.urls != nil)
+}
+
+// remoteFile - a source object that the filter accepted, to be downloaded
+type remoteFile struct {
+	key, rel, local string
+	modTime         time.Time
+}
+
+// walk - list the source bucket in place of walking the source directory, and
+// send the local path of each object that the filter accepts once it has been
+// downloaded; up to opts.numWorkers objects are downloaded at once, so that the
+// workers are not kept waiting on one download after another
+func (r *remoteSync) walk(done <-chan struct{}, opts *options, filter *fileFilter) (<-chan string, <-chan error) {
+	if r.urls != nil {
+		return r.fetchURLs(done, filter)
+	}
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		files := make(chan remoteFile)
+		var mu sync.Mutex
+		var fatal error
+		var wg sync.WaitGroup
+		wg.Add(opts.numWorkers)
+		for i := 0; i < opts.numWorkers; i++ {
+			go func() {
+				defer wg.Done()
+				for f := range files {
+					if err := r.download(ctx, f.key, f.local, f.modTime); err != nil {
+						err = applyPolicy("source", withReason(reasonStat, err))
+						logs.error(logEntry{Action: "download", File: f.key}.withErr(err), "Unable to download %s%s: %v\n", r.source, f.rel, err)
+						if isFatal(err) {
+							mu.Lock()
+							if fatal == nil {
+								fatal = err
+							}
+							mu.Unlock()
+							cancel()
+						}
+						continue
+					}
+					select {
+					case paths <- f.local:
+					case <-done:
+						os.Remove(f.local)
+					}
+				}
+			}()
+		}
+
+		err := r.source.list(ctx, opts.numWorkers, func(key string, info remoteObject) error {
+			// keys are cleaned so that no local path leaves the download directory
+			rel := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(key, r.source.prefix)), "/")
+			if len(rel) == 0 || strings.HasSuffix(key, "/") || path.Base(rel) == profileName {
+				return nil
+			}
+			info.name = path.Base(rel)
+			local := filepath.Join(r.localSource, filepath.FromSlash(rel))
+			if !opts.onlyUnder.selects(filepath.FromSlash(rel), info) || !filter.accepts(local, info) {
+				return nil
+			}
+			select {
+			case files <- remoteFile{key: key, rel: rel, local: local, modTime: info.modTime}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-done:
+				return errors.New("walk canceled")
+			}
+		})
+		close(files)
+		wg.Wait()
+		if fatal != nil {
+			err = fatal
+		}
+		errc <- err
+	}()
+	return paths, errc
+}
+
+// download - write the object key of the source bucket to local, with the
+// object's modification time so that --settle, -a and the layouts see it
+func (r *remoteSync) download(ctx context.Context, key, local string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if err = r.source.download(ctx, key, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	r.source.stats.recordTransfer(r.source.service(), fileSize(local), 0)
+	return os.Chtimes(local, modTime, modTime)
+}
+
+// upload - put the output written to the local destination into the destination
+// bucket and remove it locally; local destinations are left alone
+func (r *remoteSync) upload(opts *options, written string) error {
+	if r == nil || r.dest == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(opts.dest, written)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(written)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(written)
+	defer f.Close()
+	if err := r.dest.upload(context.Background(), r.dest.prefix+filepath.ToSlash(rel), f, mime.TypeByExtension(filepath.Ext(written))); err != nil {
+		return withReason(reasonWrite, fmt.Errorf("unable to upload %s to %s: %v", written, r.dest, err))
+	}
+	r.dest.stats.recordTransfer(r.dest.service(), 0, fileSize(written))
+	logs.info(logEntry{Action: "upload", File: written, Dest: r.dest.String() + filepath.ToSlash(rel)}, "    uploaded to %s%s\n", r.dest, filepath.ToSlash(rel))
+	return nil
+}
+
+// release - remove the local copy of a source object once it has been processed
+func (r *remoteSync) release(local string) {
+	if !r.remoteSource() {
+		return
+	}
+	os.Remove(local)
+}
+
+// cleanup - remove the local directories and close the SFTP connections
+func (r *remoteSync) cleanup() {
+	if r == nil {
+		return
+	}
+	for _, b := range []*bucket{r.source, r.dest} {
+		if b != nil && b.sftp != nil {
+			b.sftp.close()
+		}
+	}
+	r.tmp.cleanup()
+}
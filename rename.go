@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// renamedFrom - return the ledger entry of the output last written from a source
+// with the hash, when that source was not path and is gone, as it is
+// when path is the same photo under a new name
+func (l *ledger) renamedFrom(hash, path string) (ledgerEntry, bool) {
+	if l == nil || len(hash) == 0 {
+		return ledgerEntry{}, false
+	}
+	e, ok := l.hashes[hash]
+	if !ok || filepath.Clean(e.Source) == filepath.Clean(path) || fileExists(e.Source) {
+		return ledgerEntry{}, false
+	}
+	return e, true
+}
+
+// followRename - the --follow-renames mode, when the ledger shows that path is a
+// renamed source whose output is still there and was written with the same
+// settings, move that output to destFile instead of processing path again and
+// leaving the old output behind; return true if path needs no processing
+func followRename(opts *options, path, destFile, hash string) (bool, error) {
+	prev, ok := opts.ledger.renamedFrom(hash, path)
+	if !ok || prev.Params != opts.params() {
+		return false, nil
+	}
+	from, to := outputName(opts, prev.Path), outputName(opts, destFile)
+	if !fileExists(from) {
+		return false, nil
+	}
+	if from != to {
+		if err := opts.trash.keep(to); err != nil {
+			return false, withReason(reasonWrite, err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return false, withReason(reasonWrite, err)
+		}
+	}
+	err := opts.ledger.record(ledgerEntry{Event: "renamed", Source: path, Path: destFile, Params: prev.Params,
+		Note: fmt.Sprintf("from %s, written from %s", prev.Path, prev.Source)}.withSourceHash(hash))
+	if err != nil {
+		logs.warn(logEntry{Action: "ledger"}.withErr(err), "Unable to write to ledger: %v\n", err)
+	}
+	logs.info(logEntry{Action: "rename", File: path, Dest: to}, "    renamed %s to %s, the source was renamed from %s\n", from, to, prev.Source)
+	return true, nil
+}
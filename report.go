@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// reportRow - what happened to one output of a source, one row of the --report
+type reportRow struct {
+	Path       string  `json:"path"`
+	Dest       string  `json:"dest,omitempty"`
+	Action     string  `json:"action"` // resized, converted, copied, renamed, skipped or failed
+	Reason     reason  `json:"reason,omitempty"`
+	OldWidth   int     `json:"old_width"`
+	OldHeight  int     `json:"old_height"`
+	NewWidth   int     `json:"new_width"`
+	NewHeight  int     `json:"new_height"`
+	OldSize    int64   `json:"old_size"`
+	NewSize    int64   `json:"new_size"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// reportColumns - the header of a CSV --report, in the order of reportRow
+var reportColumns = []string{"path", "dest", "action", "reason", "old_width", "old_height", "new_width", "new_height",
+	"old_size", "new_size", "duration_ms", "error"}
+
+// validReport - check that the --report file name is a .json or .csv file
+func validReport(name string) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".csv":
+		return nil
+	}
+	return errors.New("the report must be a .json or .csv file")
+}
+
+// newReportRow - start the --report row of an output of the source path, with
+// the dimensions and size of the source; nil without --report
+func newReportRow(opts *options, path string) *reportRow {
+	if len(opts.report) == 0 {
+		return nil
+	}
+	row := &reportRow{Path: path, OldSize: fileSize(path)}
+	if cfg, _, err := imageConfig(path); err == nil {
+		row.OldWidth, row.OldHeight = cfg.Width, cfg.Height
+	}
+	return row
+}
+
+// finish - complete row with the outcome of writing the output dest, whose file
+// on disk is written when one was written or found up to date, and return it
+func (row *reportRow) finish(dest, written string, skip reason, renamed bool, err error, start time.Time) *reportRow {
+	if row == nil {
+		return nil
+	}
+	row.Dest = dest
+	row.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	if len(written) > 0 {
+		row.NewSize = fileSize(written)
+		// encrypted outputs can not be decoded, and keep their dimensions at 0
+		if cfg, _, cerr := imageConfig(written); cerr == nil {
+			row.NewWidth, row.NewHeight = cfg.Width, cfg.Height
+		}
+	}
+	code := reasonOf(err)
+	switch {
+	case err != nil && code != reasonFallbackCopy:
+		row.Action, row.Reason, row.Error = "failed", code, err.Error()
+	case len(skip) > 0:
+		row.Action, row.Reason = "skipped", skip
+	case renamed:
+		row.Action = "renamed"
+	case err != nil:
+		row.Action, row.Reason, row.Error = "copied", code, err.Error()
+	case row.NewWidth != row.OldWidth || row.NewHeight != row.OldHeight:
+		row.Action = "resized"
+	case row.NewSize == row.OldSize && resizer.FormatFromExt(dest) == resizer.FormatFromExt(row.Path):
+		row.Action = "copied"
+	default:
+		row.Action = "converted"
+	}
+	return row
+}
+
+// writeReport - write rows to the --report file name, as a JSON array or as CSV
+// with a header, depending on its extension
+func writeReport(name string, rows []reportRow, mode os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(name)) == ".json" {
+		if rows == nil {
+			rows = []reportRow{}
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	w.Write(reportColumns)
+	for _, r := range rows {
+		w.Write([]string{r.Path, r.Dest, r.Action, string(r.Reason),
+			strconv.Itoa(r.OldWidth), strconv.Itoa(r.OldHeight), strconv.Itoa(r.NewWidth), strconv.Itoa(r.NewHeight),
+			strconv.FormatInt(r.OldSize, 10), strconv.FormatInt(r.NewSize, 10), fmt.Sprintf("%.3f", r.DurationMS), r.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
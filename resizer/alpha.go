@@ -0,0 +1,53 @@
+package resizer
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Alpha - what happens to transparent pixels of images written as JPEG, which
+// can not hold them: flatten composites them onto Color, keep leaves them to the
+// JPEG encoder, which turns them black, and error refuses the image; the zero
+// value is keep
+type Alpha struct {
+	Mode  string
+	Color color.Color
+}
+
+// ParseAlpha - parse an alpha policy: flatten, flatten:COLOR, keep or error;
+// flatten without a color flattens onto white
+func ParseAlpha(s string) (Alpha, error) {
+	mode, hex := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		mode, hex = s[:i], s[i+1:]
+	}
+	switch mode {
+	case "flatten":
+		if len(hex) == 0 {
+			hex = "#ffffff"
+		}
+		c, err := ParseColor(hex)
+		if err != nil {
+			return Alpha{}, fmt.Errorf("invalid alpha policy: %s: %v", s, err)
+		}
+		return Alpha{Mode: mode, Color: c}, nil
+	case "keep", "error":
+		if len(hex) == 0 {
+			return Alpha{Mode: mode}, nil
+		}
+	}
+	return Alpha{}, fmt.Errorf("invalid alpha policy: %s", s)
+}
+
+// String - return a as the value ParseAlpha parses
+func (a Alpha) String() string {
+	switch a.Mode {
+	case "":
+		return "keep"
+	case "flatten":
+		c := color.NRGBAModel.Convert(a.Color).(color.NRGBA)
+		return fmt.Sprintf("flatten:#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return a.Mode
+}
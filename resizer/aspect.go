@@ -0,0 +1,96 @@
+package resizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Aspect - the aspect ratio that every output must have, such as 3:4; the zero
+// value leaves the aspect ratio to the size
+type Aspect struct {
+	W, H int
+	Pad  bool // pad images to the aspect ratio instead of cropping them
+}
+
+// ParseAspect - parse an aspect ratio such as 3:4, an empty string is none
+func ParseAspect(s string) (Aspect, error) {
+	if len(s) == 0 {
+		return Aspect{}, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		w, werr := strconv.Atoi(parts[0])
+		h, herr := strconv.Atoi(parts[1])
+		if werr == nil && herr == nil && w > 0 && h > 0 {
+			return Aspect{W: w, H: h}, nil
+		}
+	}
+	return Aspect{}, fmt.Errorf("invalid aspect ratio: %s", s)
+}
+
+// String - return the aspect ratio as W:H
+func (a Aspect) String() string {
+	return fmt.Sprintf("%d:%d", a.W, a.H)
+}
+
+// ApplyTo - return size with the dimension that it leaves out filled in from the
+// aspect ratio, or an error if size can not have it
+func (a Aspect) ApplyTo(size Size) (Size, error) {
+	switch {
+	case a.W == 0:
+		return size, nil
+	case size.Fit || size.Percent > 0:
+		return size, fmt.Errorf("aspect ratio %s needs a width or height in pixels", a)
+	case size.Height == 0:
+		size.Height = int(math.Round(float64(size.Width*a.H) / float64(a.W)))
+	case size.Width == 0:
+		size.Width = int(math.Round(float64(size.Height*a.W) / float64(a.H)))
+	}
+	if w, h := a.Fit(size.Width, size.Height); w != size.Width || h != size.Height {
+		return size, fmt.Errorf("%dx%d is not %s", size.Width, size.Height, a)
+	}
+	return size, nil
+}
+
+// Fit - return the dimensions that a w x h image is cropped or padded to for the
+// aspect ratio; dimensions within a pixel of it are left as they are
+func (a Aspect) Fit(w, h int) (int, int) {
+	if a.W == 0 {
+		return w, h
+	}
+	cw := int(math.Round(float64(h*a.W) / float64(a.H)))
+	ch := int(math.Round(float64(w*a.H) / float64(a.W)))
+	if cw >= w-1 && cw <= w+1 {
+		return w, h
+	}
+	// too wide images are cropped narrower or padded taller
+	if (cw < w) != a.Pad {
+		return cw, h
+	}
+	return w, ch
+}
+
+// Enforce - return img cropped evenly on both sides, or padded evenly with
+// background, to the aspect ratio
+func (a Aspect) Enforce(img image.Image, background color.Color) image.Image {
+	b := img.Bounds()
+	w, h := a.Fit(b.Dx(), b.Dy())
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	if !a.Pad {
+		src := ToNRGBA(img)
+		x0, y0 := (b.Dx()-w)/2, (b.Dy()-h)/2
+		return src.SubImage(image.Rect(x0, y0, x0+w, y0+h))
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	offset := image.Pt((w-b.Dx())/2, (h-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(offset), img, b.Min, draw.Over)
+	return dst
+}
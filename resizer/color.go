@@ -0,0 +1,51 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// NeedsNormalizing - return true when images of color model m are not plain 8-bit
+// RGB or grayscale, such as 16-bit PNGs, CMYK JPEGs and paletted images, and so
+// are converted by Normalize when written as format; GIF output is always paletted
+func NeedsNormalizing(m color.Model, format string) bool {
+	if format == "gif" {
+		return false
+	}
+	switch m {
+	case color.CMYKModel, color.Gray16Model, color.RGBA64Model, color.NRGBA64Model, color.Alpha16Model:
+		return true
+	}
+	_, paletted := m.(color.Palette)
+	return paletted
+}
+
+// Normalize - return img as an 8-bit RGB image, keeping any transparency, when
+// NeedsNormalizing is true of its color model, and img itself otherwise; CMYK is
+// converted without a color profile, as Go's JPEG decoder does not read them
+func Normalize(img image.Image, format string) image.Image {
+	if !NeedsNormalizing(img.ColorModel(), format) {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// HasTransparency - return true if any pixel of img is not fully opaque
+func HasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package resizer
+
+import "image"
+
+// Engines - the values of Options.Engine, how images are resized: carve scales and
+// then seam carves to the exact size keeping faces intact, scale only scales
+// proportionally to fit within the size, face-crop crops around the largest face
+// to the aspect ratio and then scales, and smart scales images that already have
+// the aspect ratio and carves the others
+var Engines = []string{"carve", "scale", "face-crop", "smart"}
+
+// Scalers - the values of Options.Scaler, how images are scaled: seam carves them
+// with caire as the engine says, while lanczos and bilinear, which is faster still,
+// never carve and only scale proportionally, lanczos with caire and bilinear with
+// golang.org/x/image/draw
+var Scalers = []string{"seam", "lanczos", "bilinear"}
+
+// SeamDirections - the values of Options.Seams, the seams that the carve and smart
+// engines may remove: vertical seams narrow an image and horizontal seams shorten it
+var SeamDirections = []string{"both", "vertical", "horizontal"}
+
+// DefaultAspectTolerance - the percent that an aspect ratio may differ by for the
+// smart engine to scale rather than carve, about a pixel or two of a photo ID
+const DefaultAspectTolerance = 1.0
+
+// engine - return the engine that images are resized with, which is scale
+// instead of carve or smart when the scaler does not carve
+func (r *Resizer) engine() string {
+	engine := r.Engine
+	if len(engine) == 0 {
+		engine = "carve"
+	}
+	if (engine == "carve" || engine == "smart") && len(r.Scaler) > 0 && r.Scaler != "seam" {
+		return "scale"
+	}
+	return engine
+}
+
+// ResolveTarget - fill in a target dimension of 0, which means proportional to a w x h image
+func ResolveTarget(w, h, tw, th int) (int, int) {
+	switch {
+	case tw == 0:
+		return ScaleDimension(w, float64(th)*100/float64(h)), th
+	case th == 0:
+		return tw, ScaleDimension(h, float64(tw)*100/float64(w))
+	}
+	return tw, th
+}
+
+// engineTarget - return the size that a w x h image is resized to by engine for the
+// target tw x th as returned by Size.Target, filling in dimensions of 0
+func engineTarget(engine string, w, h, tw, th int) (int, int) {
+	if engine == "scale" && tw > 0 && th > 0 {
+		return FitWithin(w, h, tw, th)
+	}
+	return ResolveTarget(w, h, tw, th)
+}
+
+// Target - return the size that a w x h image is written with: the size it is
+// resized to by the engine, or its own when it needs no resizing, fitted to the Aspect
+func (r *Resizer) Target(w, h int) (int, int) {
+	tw, th := w, h
+	if width, height, ok := r.Size.Target(w, h); ok {
+		tw, th = engineTarget(r.engine(), w, h, width, height)
+	}
+	return r.Aspect.Fit(tw, th)
+}
+
+// scale - resize img proportionally to a width of w, or to a height of h when
+// w is 0, with the scaler
+func (r *Resizer) scale(img image.Image, w, h int) (image.Image, error) {
+	if r.Scaler == "bilinear" {
+		return ScaleBilinear(img, w, h), nil
+	}
+	return Scale(r.Processor, img, w, h)
+}
+
+// sameAspect - return true if a w x h image has the aspect ratio of tw x th, give
+// or take tolerance percent
+func sameAspect(w, h, tw, th int, tolerance float64) bool {
+	change := float64(w) * float64(th) / (float64(h) * float64(tw))
+	return change >= 1-tolerance/100 && change <= 1+tolerance/100
+}
+
+// scaleToFill - scale img proportionally with the scaler so that it covers
+// width x height, and then crop what sticks out on either side evenly
+func (r *Resizer) scaleToFill(img image.Image, width, height int) (image.Image, error) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	var scaled image.Image
+	var err error
+	if w*height >= h*width {
+		scaled, err = r.scale(img, 0, height)
+	} else {
+		scaled, err = r.scale(img, width, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	src := ToNRGBA(scaled)
+	b := src.Bounds()
+	x0, y0 := (b.Dx()-width)/2, (b.Dy()-height)/2
+	if x0 < 0 || y0 < 0 {
+		return src, nil
+	}
+	return src.SubImage(image.Rect(x0, y0, x0+width, y0+height)), nil
+}
+
+// carveLimited - resize img to width x height as caire does, scaling it to cover
+// the size and carving away the rest along one axis, but carving no more than
+// Seams and MaxCarve allow; what remains is scaled and cropped like scaleToFill
+func (r *Resizer) carveLimited(img image.Image, width, height int) (image.Image, error) {
+	both := len(r.Seams) == 0 || r.Seams == "both"
+	if both && r.MaxCarve == 0 {
+		return ResizeTo(r.Processor, img, width, height)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	// a relatively wider image is scaled to the height and narrowed with vertical seams
+	vertical := w*height >= h*width
+	limit := r.MaxCarve
+	if limit == 0 {
+		limit = 100
+	}
+	if !both && (r.Seams == "vertical") != vertical {
+		limit = 0
+	}
+	// the percent of the scaled image that carving would remove
+	carve := 100 * (1 - float64(width*h)/float64(w*height))
+	if !vertical {
+		carve = 100 * (1 - float64(height*w)/float64(h*width))
+	}
+	if carve <= limit {
+		return ResizeTo(r.Processor, img, width, height)
+	}
+	if limit == 0 {
+		return r.scaleToFill(img, width, height)
+	}
+	var carved image.Image
+	var err error
+	if vertical {
+		carved, err = ResizeTo(r.Processor, img, int(float64(w*height)/float64(h)*(1-limit/100)), height)
+	} else {
+		carved, err = ResizeTo(r.Processor, img, width, int(float64(h*width)/float64(w)*(1-limit/100)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.scaleToFill(carved, width, height)
+}
+
+// ResizeWithEngine - resize img to width x height with the Engine, a dimension of 0 is
+// scaled proportionally; face-crop resizes images in which no face is found, and
+// sizes without both dimensions, like scale does
+func (r *Resizer) ResizeWithEngine(img image.Image, width, height int) (image.Image, error) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	switch r.engine() {
+	case "scale":
+		tw, _ := engineTarget("scale", w, h, width, height)
+		return r.scale(img, tw, 0)
+	case "face-crop":
+		if width == 0 || height == 0 {
+			return r.scale(img, width, height)
+		}
+		fd, err := sharedDetector(r.Processor.Classifier)
+		if err != nil {
+			return nil, err
+		}
+		// look for faces in a smaller copy and scale them back up
+		work, factor := img, 1.0
+		if w > DetectionMax || h > DetectionMax {
+			dw, _ := FitWithin(w, h, DetectionMax, DetectionMax)
+			if work, err = Scale(r.Processor, img, dw, 0); err != nil {
+				return nil, err
+			}
+			factor = float64(w) / float64(work.Bounds().Dx())
+		}
+		faces := fd.Detect(work)
+		if len(faces) == 0 && r.Square {
+			if len(r.Scaler) > 0 && r.Scaler != "seam" {
+				return r.scaleToFill(img, width, height)
+			}
+			return ResizeSquare(r.Processor, img, width)
+		}
+		if len(faces) == 0 {
+			tw, _ := engineTarget("scale", w, h, width, height)
+			return r.scale(img, tw, 0)
+		}
+		f := PrimaryFace(faces)
+		f.Rectangle = image.Rect(int(float64(f.Min.X)*factor), int(float64(f.Min.Y)*factor),
+			int(float64(f.Max.X)*factor), int(float64(f.Max.Y)*factor))
+		return r.scale(CropToFace(img, f, r.CropMargin, width, height), width, 0)
+	case "smart":
+		if width == 0 || height == 0 {
+			return r.scale(img, width, height)
+		}
+		if sameAspect(w, h, width, height, r.AspectTolerance) {
+			return r.scaleToFill(img, width, height)
+		}
+	}
+	if width == 0 || height == 0 {
+		return ResizeTo(r.Processor, img, width, height)
+	}
+	return r.carveLimited(img, width, height)
+}
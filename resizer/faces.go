@@ -0,0 +1,156 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	pigo "github.com/esimov/pigo/core"
+)
+
+// minFaceQuality - detections scoring below this are ignored, the same threshold caire uses
+const minFaceQuality = 5.0
+
+// DetectionMax - images are scaled down to this longest edge before looking for
+// a face, which is found as well and many times faster
+const DetectionMax = 1024
+
+// Face - the bounding square of a detected face
+type Face struct {
+	image.Rectangle
+	Quality float32
+}
+
+// FaceDetector - finds faces using the same 'facefinder' classification file as caire;
+// it is safe for concurrent use once created
+type FaceDetector struct {
+	classifier *pigo.Pigo
+}
+
+// NewFaceDetector - load the classification file at path
+func NewFaceDetector(path string) (*FaceDetector, error) {
+	cascade, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	classifier, err := pigo.NewPigo().Unpack(cascade)
+	if err != nil {
+		return nil, err
+	}
+	return &FaceDetector{classifier: classifier}, nil
+}
+
+// detectors - the face detectors of the face-crop engine by classification file,
+// each only loaded once an image is resized with it
+var detectors = struct {
+	sync.Mutex
+	loaded map[string]*loadedDetector
+}{loaded: make(map[string]*loadedDetector)}
+
+// loadedDetector - a face detector of detectors, or the error loading it
+type loadedDetector struct {
+	fd  *FaceDetector
+	err error
+}
+
+// sharedDetector - return the face detector of the classification file at path,
+// loading it on first use
+func sharedDetector(path string) (*FaceDetector, error) {
+	detectors.Lock()
+	defer detectors.Unlock()
+	d, ok := detectors.loaded[path]
+	if !ok {
+		d = &loadedDetector{}
+		d.fd, d.err = NewFaceDetector(path)
+		detectors.loaded[path] = d
+	}
+	return d.fd, d.err
+}
+
+// Grayscale - return the luminance of each pixel of img, row by row
+func Grayscale(img image.Image) []uint8 {
+	b := img.Bounds()
+	pixels := make([]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			pixels = append(pixels, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return pixels
+}
+
+// Detect - return the faces found in img, relative to the image's origin
+func (fd *FaceDetector) Detect(img image.Image) []Face {
+	cols, rows := img.Bounds().Dx(), img.Bounds().Dy()
+	maxSize := cols
+	if rows > maxSize {
+		maxSize = rows
+	}
+	params := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     maxSize,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: Grayscale(img),
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		},
+	}
+	detections := fd.classifier.RunCascade(params, 0)
+	detections = fd.classifier.ClusterDetections(detections, 0.2)
+
+	var faces []Face
+	for _, d := range detections {
+		if d.Q < minFaceQuality {
+			continue
+		}
+		r := image.Rect(d.Col-d.Scale/2, d.Row-d.Scale/2, d.Col+d.Scale/2, d.Row+d.Scale/2)
+		faces = append(faces, Face{Rectangle: r, Quality: d.Q})
+	}
+	return faces
+}
+
+// CropToFace - return the part of img around f, extended on every side by margin
+// percent of the face's size and then widened or heightened to the aspect ratio
+// of w x h; the crop is shifted or shrunk as needed to stay within img
+func CropToFace(img image.Image, f Face, margin float64, w, h int) image.Image {
+	// faces are relative to the origin, which ToNRGBA moves to (0, 0)
+	src := ToNRGBA(img)
+	b := src.Bounds()
+	cx := float64(f.Min.X+f.Max.X) / 2
+	cy := float64(f.Min.Y+f.Max.Y) / 2
+	cw := float64(f.Dx()) * (1 + 2*margin/100)
+	ch := float64(f.Dy()) * (1 + 2*margin/100)
+	if w > 0 && h > 0 {
+		aspect := float64(w) / float64(h)
+		if cw/ch < aspect {
+			cw = ch * aspect
+		} else {
+			ch = cw / aspect
+		}
+	}
+	// shrink the crop, keeping its aspect ratio, when it is larger than the image
+	if scale := math.Min(float64(b.Dx())/cw, float64(b.Dy())/ch); scale < 1 {
+		cw, ch = cw*scale, ch*scale
+	}
+
+	x0 := math.Min(math.Max(cx-cw/2, float64(b.Min.X)), float64(b.Max.X)-cw)
+	y0 := math.Min(math.Max(cy-ch/2, float64(b.Min.Y)), float64(b.Max.Y)-ch)
+	r := image.Rect(int(x0), int(y0), int(x0+cw), int(y0+ch)).Intersect(b)
+	return src.SubImage(r)
+}
+
+// PrimaryFace - return the largest of faces, which must not be empty
+func PrimaryFace(faces []Face) Face {
+	primary := faces[0]
+	for _, f := range faces[1:] {
+		if f.Dx() > primary.Dx() {
+			primary = f
+		}
+	}
+	return primary
+}
@@ -0,0 +1,95 @@
+package resizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// formatExtensions - the file extension written for each supported output format
+var formatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+	"tiff": ".tif",
+	"bmp":  ".bmp",
+}
+
+// ParseFormat - return the canonical name of an output format such as jpg or PNG
+func ParseFormat(s string) (string, error) {
+	format := strings.ToLower(s)
+	switch format {
+	case "jpg":
+		format = "jpeg"
+	case "tif":
+		format = "tiff"
+	}
+	if _, ok := formatExtensions[format]; !ok {
+		return "", fmt.Errorf("unsupported output format: %s", s)
+	}
+	return format, nil
+}
+
+// FormatFromExt - return the output format matching the extension of name,
+// or an empty string if the extension is not a supported format
+func FormatFromExt(name string) string {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(name), "."))
+	if err != nil {
+		return ""
+	}
+	return format
+}
+
+// ContentFormat - return the format of the image data in the file at path, which
+// can differ from what its extension says, or an empty string if it is not one of
+// the supported output formats
+func ContentFormat(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	_, format, err := image.DecodeConfig(f)
+	if _, ok := formatExtensions[format]; err != nil || !ok {
+		return ""
+	}
+	return format
+}
+
+// ReplaceExt - return name with its extension replaced by the one for format
+func ReplaceExt(name, format string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + formatExtensions[format]
+}
+
+// WithFormat - return name with the extension of format unless its extension
+// already names format, such as .jpeg for jpeg; an empty format leaves name as it is
+func WithFormat(name, format string) string {
+	if len(format) > 0 && format != FormatFromExt(name) {
+		return ReplaceExt(name, format)
+	}
+	return name
+}
+
+// Extension - return the file extension written for format, such as .jpg, or
+// an empty string if it is not a supported format
+func Extension(format string) string {
+	return formatExtensions[format]
+}
+
+// ParseColor - parse a hex color such as #ffffff or #fff
+func ParseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.NRGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
@@ -0,0 +1,151 @@
+package resizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// TooLargeError - returned when an image declares more pixels than allowed
+type TooLargeError struct {
+	Width, Height int
+	MaxPixels     int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("image is %dx%d, more than the %d megapixels allowed", e.Width, e.Height, e.MaxPixels/1000000)
+}
+
+// CheckPixels - return a *TooLargeError when the header of the image in r declares
+// more than maxPixels pixels, so that it is refused before any memory is allocated
+// for it; a maxPixels of 0 allows any size
+func CheckPixels(r io.Reader, maxPixels int) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return &TooLargeError{Width: cfg.Width, Height: cfg.Height, MaxPixels: maxPixels}
+	}
+	return nil
+}
+
+// DecodeFile - read and decode the image file at path, also returning its format,
+// refusing images of more than maxPixels pixels
+func DecodeFile(path string, maxPixels int) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	if err := CheckPixels(f, maxPixels); err != nil {
+		return nil, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(f)
+}
+
+// DecodeReader - decode the image in r like DecodeFile
+func DecodeReader(r io.Reader, maxPixels int) (image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := CheckPixels(bytes.NewReader(data), maxPixels); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// ToNRGBA - convert img to an NRGBA image with its origin at (0, 0), as needed by caire
+func ToNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Bounds().Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// Flatten - composite img over a solid background color, removing any transparency
+func Flatten(img image.Image, background color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// jfifSegment - return a JFIF APP0 segment recording dpi as the pixel density
+func jfifSegment(dpi int) []byte {
+	return []byte{
+		0xff, 0xe0, 0x00, 0x10, // APP0 marker and segment length
+		'J', 'F', 'I', 'F', 0x00,
+		0x01, 0x02, // version 1.02
+		0x01, // density units: dots per inch
+		byte(dpi >> 8), byte(dpi),
+		byte(dpi >> 8), byte(dpi),
+		0x00, 0x00, // no thumbnail
+	}
+}
+
+// DefaultQuality - the JPEG quality used when none is given
+const DefaultQuality = 100
+
+// Encode - write img to w in the given format; for JPEG output a dpi greater
+// than 0 is stamped into the JFIF header, and quality, from 1 to 100, is the
+// JPEG or WebP quality, DefaultQuality when it is 0
+func Encode(w io.Writer, img image.Image, format string, dpi, quality int) error {
+	if quality <= 0 {
+		quality = DefaultQuality
+	}
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "webp":
+		// WebP has no pixel density, so dpi is not recorded
+		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+	case "tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case "bmp":
+		return bmp.Encode(w, img)
+	}
+
+	if dpi <= 0 {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	// Go's encoder does not write a JFIF header, so insert one right after the SOI marker
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	encoded := buf.Bytes()
+	if _, err := w.Write(encoded[:2]); err != nil {
+		return err
+	}
+	if _, err := w.Write(jfifSegment(dpi)); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[2:])
+	return err
+}
@@ -0,0 +1,333 @@
+// Package resizer resizes and converts photo ID images the way photo_id_resizer
+// does, for Go programs that embed it instead of running the command.  Images are
+// resized by the engine of the options, by default with caire, which carves them
+// to the exact size when both a width and a height are given, keeping the faces
+// it detects intact.  The command writes every output with a Resizer, so the same
+// options give the same images; what it does around them, such as naming outputs
+// after templates, copying metadata and encrypting, stays in the command.
+//
+//	r := resizer.New(resizer.Options{Size: resizer.Size{Width: 600, Height: 600}}, "facefinder")
+//	if err := r.ResizeFile("in/10042.png", "out/10042.jpg"); err != nil {
+//		log.Fatal(err)
+//	}
+package resizer
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/esimov/caire"
+)
+
+// ErrTransparent - returned for images with transparent pixels written as JPEG
+// when the Alpha mode is error
+var ErrTransparent = errors.New("image has transparent pixels, which JPEG can not hold")
+
+// Options - the settings of a Resizer; the zero value of each leaves it out
+type Options struct {
+	Size       Size        // target dimensions
+	Format     string      // output format of ResizeDir: jpeg, png, gif, webp, tiff or bmp, empty to keep the format of each source
+	DPI        int         // pixel density stamped into JPEG output, 0 for none
+	Quality    int         // JPEG or WebP quality from 1 to 100, 0 for DefaultQuality
+	Background color.Color // color that transparency is flattened onto, nil for none
+	MaxPixels  int         // largest image that is decoded, 0 for no limit
+	// PreserveColor keeps 16-bit, CMYK and paletted images as they are instead of
+	// writing them as 8-bit RGB, see Normalize
+	PreserveColor bool
+	// FixExtensions takes the format of sources from their content rather than
+	// their extension, so that PNG data in a.jpg is written as a.png
+	FixExtensions bool
+
+	Engine          string  // how images are resized, see Engines; empty for carve
+	Scaler          string  // how images are scaled, see Scalers; empty for seam
+	Seams           string  // the seams that may be carved, see SeamDirections; empty for both
+	MaxCarve        float64 // largest percent of an image that is carved away, 0 for no limit
+	AspectTolerance float64 // percent that an aspect ratio may differ by for the smart engine to only scale
+	CropMargin      float64 // percent of the face's size that face-crop keeps around it
+	Square          bool    // carve images in which face-crop finds no face to a square
+	Aspect          Aspect  // the aspect ratio every output is cropped or padded to, if given
+	Alpha           Alpha   // what happens to transparency in JPEG outputs without a Background
+}
+
+// Resizer - resizes images with the given options; it is safe for concurrent use,
+// though only one image at a time is carved, see caireMu
+type Resizer struct {
+	Options
+	Processor *caire.Processor
+}
+
+// NewProcessor - return a caire processor set up like photo_id_resizer's, detecting
+// faces with the pigo classification file at classifier; NewWidth and NewHeight are
+// set for each image
+func NewProcessor(classifier string) *caire.Processor {
+	return &caire.Processor{
+		BlurRadius:     10,
+		SobelThreshold: 1,
+		Percentage:     false,
+		Square:         false,
+		Debug:          false,
+		Scale:          true,
+		FaceDetect:     true,
+		FaceAngle:      0,
+		Classifier:     classifier,
+	}
+}
+
+// caireMu - held while caire resizes an image.  caire keeps the state of the
+// seams being carved in package level variables, so a processor of its own does
+// not keep one goroutine's carving from corrupting another's.
+var caireMu sync.Mutex
+
+// caireResize - resize img with q, one image at a time
+func caireResize(q *caire.Processor, img image.Image) (image.Image, error) {
+	nrgba := ToNRGBA(img)
+	caireMu.Lock()
+	defer caireMu.Unlock()
+	return q.Resize(nrgba)
+}
+
+// CloneProcessor - return a new processor with the settings of p, so that the
+// target size of one image can be set without changing p
+func CloneProcessor(p *caire.Processor) *caire.Processor {
+	return &caire.Processor{
+		BlurRadius:     p.BlurRadius,
+		SobelThreshold: p.SobelThreshold,
+		NewWidth:       p.NewWidth,
+		NewHeight:      p.NewHeight,
+		Percentage:     p.Percentage,
+		Square:         p.Square,
+		Debug:          p.Debug,
+		Scale:          p.Scale,
+		FaceDetect:     p.FaceDetect,
+		FaceAngle:      p.FaceAngle,
+		Classifier:     p.Classifier,
+	}
+}
+
+// New - return a Resizer with opts, detecting faces with the pigo classification
+// file at classifier
+func New(opts Options, classifier string) *Resizer {
+	return &Resizer{Options: opts, Processor: NewProcessor(classifier)}
+}
+
+// ResizeTo - resize img to width x height with the settings of p, a dimension of 0
+// is scaled proportionally; p itself is not changed
+func ResizeTo(p *caire.Processor, img image.Image, width, height int) (image.Image, error) {
+	// each image gets its own processor since the target size varies per image
+	q := CloneProcessor(p)
+	q.NewWidth, q.NewHeight = width, height
+	return caireResize(q, img)
+}
+
+// ResizeSquare - return img carved to a square of n x n with caire's Square
+// option, keeping the faces it detects intact
+func ResizeSquare(p *caire.Processor, img image.Image, n int) (image.Image, error) {
+	q := CloneProcessor(p)
+	q.NewWidth, q.NewHeight = n, n
+	q.Square = true
+	return caireResize(q, img)
+}
+
+// Resize - return img resized to the target size with the engine, or img itself
+// when it is already within size; resized tells which
+func (r *Resizer) Resize(img image.Image) (out image.Image, resized bool, err error) {
+	b := img.Bounds()
+	width, height, ok := r.Size.Target(b.Dx(), b.Dy())
+	if !ok {
+		return img, false, nil
+	}
+	out, err = r.ResizeWithEngine(img, width, height)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// Finish - return img, resized or not, ready to be encoded in format: cropped or
+// padded to the Aspect, written as 8-bit RGB unless PreserveColor is set and
+// flattened onto the Background, or as Alpha says for JPEG outputs without one
+func (r *Resizer) Finish(img image.Image, format string) (image.Image, error) {
+	if r.Aspect.W > 0 {
+		background := r.Background
+		if background == nil {
+			background = color.White
+		}
+		img = r.Aspect.Enforce(img, background)
+	}
+	if !r.PreserveColor {
+		img = Normalize(img, format)
+	}
+	if r.Background != nil && format != "png" {
+		img = Flatten(img, r.Background)
+	}
+	if format == "jpeg" && HasTransparency(img) {
+		switch r.Alpha.Mode {
+		case "flatten":
+			img = Flatten(img, r.Alpha.Color)
+		case "error":
+			return nil, ErrTransparent
+		}
+	}
+	return img, nil
+}
+
+// Plan - what writing the output of a source image file takes; when none of it
+// is needed, the source is copied unchanged
+type Plan struct {
+	Width, Height int  // that the image is resized to, as returned by Size.Target
+	Resize        bool // the image is not within the size
+	Reshape       bool // the image is within the size but not of the Aspect
+	Convert       bool // the output is in another format than the source
+	Normalize     bool // the image is written as 8-bit RGB, see NeedsNormalizing
+	Stamp         bool // a DPI is stamped into the output
+}
+
+// Copy - return true if copying the source gives its output
+func (p Plan) Copy() bool {
+	return !p.Resize && !p.Reshape && !p.Convert && !p.Normalize && !p.Stamp
+}
+
+// Plan - return what writing srcname as an output in format takes, from the
+// header of the image; when it can not be read, only Convert and Stamp are set
+func (r *Resizer) Plan(srcname, format string) (Plan, error) {
+	plan := Plan{Convert: format != r.SourceFormat(srcname), Stamp: r.DPI > 0}
+	f, err := os.Open(srcname)
+	if err != nil {
+		return plan, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return plan, err
+	}
+	plan.Width, plan.Height, plan.Resize = r.Size.Target(cfg.Width, cfg.Height)
+	if !plan.Resize && r.Aspect.W > 0 {
+		w, h := r.Aspect.Fit(cfg.Width, cfg.Height)
+		plan.Reshape = w != cfg.Width || h != cfg.Height
+	}
+	plan.Normalize = !r.PreserveColor && NeedsNormalizing(cfg.ColorModel, format)
+	return plan, nil
+}
+
+// SourceFormat - return the format of the image file srcname, from its content
+// when FixExtensions is set and otherwise from its extension
+func (r *Resizer) SourceFormat(srcname string) string {
+	if r.FixExtensions {
+		if format := ContentFormat(srcname); len(format) > 0 {
+			return format
+		}
+	}
+	return FormatFromExt(srcname)
+}
+
+// OutputName - return the name in dest of the output of srcname: its own name
+// with the extension of Format, or of its SourceFormat when Format is empty
+func (r *Resizer) OutputName(dest, srcname string) string {
+	format := r.Format
+	if len(format) == 0 {
+		format = r.SourceFormat(srcname)
+	}
+	return WithFormat(filepath.Join(dest, filepath.Base(srcname)), format)
+}
+
+// Process - decode the image in src, resize it and write it to dst in format,
+// or in its own format when format is empty
+func (r *Resizer) Process(src io.Reader, dst io.Writer, format string) error {
+	img, sourceFormat, err := DecodeReader(src, r.MaxPixels)
+	if err != nil {
+		return err
+	}
+	if len(format) == 0 {
+		format = sourceFormat
+	}
+	if img, _, err = r.Resize(img); err != nil {
+		return err
+	}
+	if img, err = r.Finish(img, format); err != nil {
+		return err
+	}
+	return Encode(dst, img, format, r.DPI, r.Quality)
+}
+
+// ResizeFile - write the output of the image file srcname to dstname, in the
+// format that the extension of dstname names; sources that need nothing done
+// to them, see Plan, are copied unchanged
+func (r *Resizer) ResizeFile(srcname, dstname string) error {
+	format := FormatFromExt(dstname)
+	if len(format) == 0 {
+		_, err := ParseFormat(filepath.Ext(dstname))
+		return err
+	}
+	plan, err := r.Plan(srcname, format)
+	if err != nil {
+		return err
+	}
+	if plan.Copy() {
+		return copyFile(srcname, dstname)
+	}
+	img, _, err := DecodeFile(srcname, r.MaxPixels)
+	if err != nil {
+		return err
+	}
+	if plan.Resize {
+		if img, err = r.ResizeWithEngine(img, plan.Width, plan.Height); err != nil {
+			return err
+		}
+	}
+	if img, err = r.Finish(img, format); err != nil {
+		return err
+	}
+	dst, err := os.Create(dstname)
+	if err != nil {
+		return err
+	}
+	if err := Encode(dst, img, format, r.DPI, r.Quality); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// copyFile - copy the file srcname to dstname
+func copyFile(srcname, dstname string) error {
+	src, err := os.Open(srcname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstname)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// ResizeDir - resize every image file in the tree rooted at source into dest,
+// which must exist, under the OutputName of each; it stops at the first error
+// and returns the number of files written
+func (r *Resizer) ResizeDir(source, dest string) (int, error) {
+	n := 0
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || len(FormatFromExt(path)) == 0 {
+			return nil
+		}
+		if err := r.ResizeFile(path, r.OutputName(dest, path)); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
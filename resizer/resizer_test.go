@@ -0,0 +1,70 @@
+package resizer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+// testImage - return a w x h image with a gradient and a block that depend on
+// seed, so that every image carves differently
+func testImage(w, h, seed int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8(seed * 37), 0xff})
+		}
+	}
+	for y := h / 4; y < h/2; y++ {
+		for x := seed % (w / 2); x < seed%(w/2)+w/4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{0xff, 0xff, 0xff, 0xff})
+		}
+	}
+	return img
+}
+
+// TestResizeConcurrent - images carved by several goroutines at once must be
+// the same as when they are carved one after the other; run it with -race
+func TestResizeConcurrent(t *testing.T) {
+	r := New(Options{Size: Size{Width: 48, Height: 40}}, "")
+	r.Processor.FaceDetect = false
+
+	const n = 8
+	var sources []image.Image
+	for i := 0; i < n; i++ {
+		sources = append(sources, testImage(80+i*4, 64, i))
+	}
+	serial := make([]image.Image, n)
+	for i, img := range sources {
+		out, _, err := r.Resize(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial[i] = out
+	}
+
+	for round := 0; round < 3; round++ {
+		concurrent := make([]image.Image, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range sources {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				concurrent[i], _, errs[i] = r.Resize(sources[i])
+			}(i)
+		}
+		wg.Wait()
+		for i := range sources {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			want, got := ToNRGBA(serial[i]), ToNRGBA(concurrent[i])
+			if want.Bounds() != got.Bounds() || !bytes.Equal(want.Pix, got.Pix) {
+				t.Errorf("round %d: image %d differs from the one resized on its own", round, i)
+			}
+		}
+	}
+}
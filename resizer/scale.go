@@ -0,0 +1,45 @@
+package resizer
+
+import (
+	"image"
+
+	"github.com/esimov/caire"
+	"golang.org/x/image/draw"
+)
+
+// Scale - resize img proportionally to a width of w, or to a height of h
+// when w is 0, using caire's Lanczos scaling without seam carving or face detection
+func Scale(p *caire.Processor, img image.Image, w, h int) (image.Image, error) {
+	q := CloneProcessor(p)
+	q.NewWidth, q.NewHeight = w, 0
+	if w == 0 {
+		q.NewHeight = h
+	}
+	q.Scale = true
+	q.FaceDetect = false
+	q.Square, q.Percentage = false, false
+	return caireResize(q, img)
+}
+
+// ScaleBilinear - resize img proportionally like Scale, with bilinear interpolation,
+// which is faster than Scale's Lanczos but not as sharp
+func ScaleBilinear(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	if w == 0 {
+		w = ScaleDimension(b.Dx(), float64(h)*100/float64(b.Dy()))
+	} else {
+		h = ScaleDimension(b.Dy(), float64(w)*100/float64(b.Dx()))
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// FitWithin - return the width and height of a w x h image scaled
+// proportionally so that it fits within maxW x maxH
+func FitWithin(w, h, maxW, maxH int) (int, int) {
+	if w*maxH > h*maxW {
+		return maxW, ScaleDimension(h, float64(maxW)*100/float64(w))
+	}
+	return ScaleDimension(w, float64(maxH)*100/float64(h)), maxH
+}
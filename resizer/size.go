@@ -0,0 +1,117 @@
+package resizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size - the target dimensions of resized images
+//
+// When only one of width or height is given, the other dimension is scaled
+// proportionally.  When both are given, images are scaled and then carved to
+// exactly width x height, unless fit is set, in which case images are only
+// scaled proportionally so that they fit within a width x height box.
+type Size struct {
+	Width   int     // max width in pixels, 0 when unconstrained
+	Height  int     // max height in pixels, 0 when unconstrained
+	Percent float64 // when > 0, scale both dimensions to this percentage
+	Fit     bool    // scale proportionally so the longest edge fits within width x height
+}
+
+// ParseDimension - parse a single pixel dimension, an empty string means unconstrained
+func ParseDimension(s string) (int, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid dimension: %s", s)
+	}
+	return n, nil
+}
+
+// ParseSize - parse a size specification such as 800x600, 800x, x600 or 50%
+// when fit is true, a single number such as 400 is the same as 400x400
+func ParseSize(spec string, fit bool) (Size, error) {
+	var size Size
+	invalid := fmt.Errorf("invalid size specification: %s", spec)
+
+	if strings.HasSuffix(spec, "%") {
+		if fit {
+			return size, invalid
+		}
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return size, invalid
+		}
+		size.Percent = pct
+		return size, nil
+	}
+
+	spec = strings.ToLower(spec)
+	if fit && !strings.Contains(spec, "x") {
+		spec = spec + "x" + spec
+	}
+	parts := strings.Split(spec, "x")
+	if len(parts) != 2 {
+		return size, invalid
+	}
+	var err error
+	if size.Width, err = ParseDimension(parts[0]); err != nil {
+		return size, invalid
+	}
+	if size.Height, err = ParseDimension(parts[1]); err != nil {
+		return size, invalid
+	}
+	if size.Width == 0 && size.Height == 0 {
+		return size, invalid
+	}
+	size.Fit = fit
+	return size, nil
+}
+
+// IsSet - return true if any target dimension has been given
+func (s Size) IsSet() bool {
+	return s.Width > 0 || s.Height > 0 || s.Percent > 0
+}
+
+// Target - return the width and height that a w x h image should be resized to,
+// a returned dimension of 0 is scaled proportionally; ok is false when the
+// image does not need resizing
+func (s Size) Target(w, h int) (tw, th int, ok bool) {
+	switch {
+	case s.Percent > 0:
+		if s.Percent >= 100 {
+			return 0, 0, false
+		}
+		// only constrain the longest edge so that the aspect ratio is preserved
+		if w >= h {
+			return ScaleDimension(w, s.Percent), 0, true
+		}
+		return 0, ScaleDimension(h, s.Percent), true
+	case s.Fit:
+		if (s.Width == 0 || w <= s.Width) && (s.Height == 0 || h <= s.Height) {
+			return 0, 0, false
+		}
+		// constrain whichever edge overflows the box by the largest ratio
+		if s.Height == 0 || (s.Width > 0 && w*s.Height >= h*s.Width) {
+			return s.Width, 0, true
+		}
+		return 0, s.Height, true
+	}
+
+	if (s.Width == 0 || w <= s.Width+1) && (s.Height == 0 || h <= s.Height+1) {
+		return 0, 0, false
+	}
+	return s.Width, s.Height, true
+}
+
+// ScaleDimension - return n scaled to pct percent, but never less than 1
+func ScaleDimension(n int, pct float64) int {
+	scaled := int(float64(n)*pct/100 + 0.5)
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
@@ -0,0 +1,86 @@
+package resizer
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		spec    string
+		fit     bool
+		want    Size
+		wantErr bool
+	}{
+		{spec: "800x600", want: Size{Width: 800, Height: 600}},
+		{spec: "800X600", want: Size{Width: 800, Height: 600}},
+		{spec: "800x", want: Size{Width: 800}},
+		{spec: "x600", want: Size{Height: 600}},
+		{spec: "50%", want: Size{Percent: 50}},
+		{spec: "12.5%", want: Size{Percent: 12.5}},
+		{spec: "400", fit: true, want: Size{Width: 400, Height: 400, Fit: true}},
+		{spec: "400x300", fit: true, want: Size{Width: 400, Height: 300, Fit: true}},
+		{spec: "x300", fit: true, want: Size{Height: 300, Fit: true}},
+		{spec: "400", wantErr: true},
+		{spec: "x", wantErr: true},
+		{spec: "", wantErr: true},
+		{spec: "0x600", wantErr: true},
+		{spec: "-1x600", wantErr: true},
+		{spec: "800x600x400", wantErr: true},
+		{spec: "axb", wantErr: true},
+		{spec: "0%", wantErr: true},
+		{spec: "101%", wantErr: true},
+		{spec: "abc%", wantErr: true},
+		{spec: "50%", fit: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.spec, tt.fit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q, %v) = %+v, want an error", tt.spec, tt.fit, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q, %v): %v", tt.spec, tt.fit, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q, %v) = %+v, want %+v", tt.spec, tt.fit, got, tt.want)
+		}
+	}
+}
+
+func TestSizeTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   Size
+		w, h   int
+		tw, th int
+		ok     bool
+	}{
+		{name: "both edges", size: Size{Width: 800, Height: 600}, w: 1600, h: 1200, tw: 800, th: 600, ok: true},
+		{name: "width only", size: Size{Width: 800}, w: 1600, h: 1200, tw: 800, ok: true},
+		{name: "height only", size: Size{Height: 600}, w: 1600, h: 1200, th: 600, ok: true},
+		{name: "already small", size: Size{Width: 800, Height: 600}, w: 640, h: 480},
+		{name: "one pixel over is tolerated", size: Size{Width: 800, Height: 600}, w: 801, h: 601},
+		{name: "two pixels over", size: Size{Width: 800, Height: 600}, w: 802, h: 600, tw: 800, th: 600, ok: true},
+		{name: "unconstrained height", size: Size{Width: 800}, w: 800, h: 5000},
+		{name: "percent of landscape", size: Size{Percent: 50}, w: 1000, h: 600, tw: 500, ok: true},
+		{name: "percent of portrait", size: Size{Percent: 50}, w: 600, h: 1000, th: 500, ok: true},
+		{name: "percent of square", size: Size{Percent: 25}, w: 400, h: 400, tw: 100, ok: true},
+		{name: "percent rounds to one pixel", size: Size{Percent: 1}, w: 10, h: 5, tw: 1, ok: true},
+		{name: "hundred percent", size: Size{Percent: 100}, w: 1000, h: 600},
+		{name: "fit already inside", size: Size{Width: 400, Height: 400, Fit: true}, w: 400, h: 300},
+		{name: "fit landscape", size: Size{Width: 400, Height: 400, Fit: true}, w: 1600, h: 1200, tw: 400, ok: true},
+		{name: "fit portrait", size: Size{Width: 400, Height: 400, Fit: true}, w: 1200, h: 1600, th: 400, ok: true},
+		{name: "fit wide box", size: Size{Width: 800, Height: 400, Fit: true}, w: 1000, h: 1000, th: 400, ok: true},
+		{name: "fit tall box", size: Size{Width: 400, Height: 800, Fit: true}, w: 1000, h: 1000, tw: 400, ok: true},
+		{name: "fit one pixel over", size: Size{Width: 400, Height: 400, Fit: true}, w: 401, h: 300, tw: 400, ok: true},
+		{name: "fit height only", size: Size{Height: 300, Fit: true}, w: 2000, h: 600, th: 300, ok: true},
+	}
+	for _, tt := range tests {
+		tw, th, ok := tt.size.Target(tt.w, tt.h)
+		if tw != tt.tw || th != tt.th || ok != tt.ok {
+			t.Errorf("%s: %+v.Target(%d, %d) = %d, %d, %v, want %d, %d, %v",
+				tt.name, tt.size, tt.w, tt.h, tw, th, ok, tt.tw, tt.th, tt.ok)
+		}
+	}
+}
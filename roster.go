@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// rosterIDColumns - header names recognized as the employee ID column of a roster;
+// without one of them, the first column is used and the first row is data
+var rosterIDColumns = []string{"id", "employee_id", "employee id", "employeeid", "emplid"}
+
+// rosterPriorityColumn - header name of the optional column that marks the entries
+// whose photos are processed first, see parsePriority
+const rosterPriorityColumn = "priority"
+
+// rosterPriorities - the words a priority column can hold; numbers can be given too,
+// and anything else, such as backfill or an empty cell, is 0
+var rosterPriorities = map[string]int{
+	"vip":      2,
+	"new-hire": 1,
+	"new hire": 1,
+	"high":     1,
+}
+
+// roster - the employee IDs that are expected to have a photo
+type roster struct {
+	ids      map[string]bool
+	order    []string       // in the order of the file, for the report
+	priority map[string]int // IDs with a priority above 0, the higher the sooner
+}
+
+// parsePriority - return the priority of a priority column cell
+func parsePriority(cell string) int {
+	cell = strings.ToLower(strings.TrimSpace(cell))
+	if p, ok := rosterPriorities[cell]; ok {
+		return p
+	}
+	if p, err := strconv.Atoi(cell); err == nil && p > 0 {
+		return p
+	}
+	return 0
+}
+
+// priorityOf - return the priority of the roster entry the photo at path belongs
+// to, 0 for none or when there is no roster
+func (ro *roster) priorityOf(path string) int {
+	if ro == nil {
+		return 0
+	}
+	return ro.priority[photoID(path)]
+}
+
+// prioritize - sort paths so that the photos of entries with a higher priority
+// come first, keeping the order of those with the same priority
+func (ro *roster) prioritize(paths []string) {
+	if ro == nil || len(ro.priority) == 0 {
+		return
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return ro.priorityOf(paths[i]) > ro.priorityOf(paths[j])
+	})
+}
+
+// photoID - return the employee ID a photo belongs to, which is its file name
+// without the extension
+func photoID(path string) string {
+	name := filepath.Base(path)
+	return strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// loadRoster - read the employee IDs from a CSV file
+func loadRoster(path string) (*roster, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	ro := &roster{ids: make(map[string]bool), priority: make(map[string]int)}
+	column, priorityColumn := 0, -1
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			if c := headerColumn(record); c >= 0 {
+				column = c
+				for i, cell := range record {
+					if strings.ToLower(strings.TrimSpace(cell)) == rosterPriorityColumn {
+						priorityColumn = i
+					}
+				}
+				continue
+			}
+		}
+		if column >= len(record) {
+			continue
+		}
+		id := strings.ToLower(strings.TrimSpace(record[column]))
+		if len(id) > 0 && !ro.ids[id] {
+			ro.ids[id] = true
+			ro.order = append(ro.order, id)
+			if priorityColumn >= 0 && priorityColumn < len(record) {
+				if p := parsePriority(record[priorityColumn]); p > 0 {
+					ro.priority[id] = p
+				}
+			}
+		}
+	}
+	return ro, nil
+}
+
+// headerColumn - return the index of the ID column if record is a header row, or -1
+func headerColumn(record []string) int {
+	for i, cell := range record {
+		cell = strings.ToLower(strings.TrimSpace(cell))
+		for _, name := range rosterIDColumns {
+			if cell == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// rosterEntry - one line of the reconciliation report
+type rosterEntry struct {
+	id     string
+	status string // missing-source, missing-destination or not-on-roster
+	path   string
+}
+
+// reconcile - compare the roster to the photos found in the source and destination
+// directories, returning the expected IDs that are missing from either and the
+// photos that do not belong to anyone on the roster
+func (ro *roster) reconcile(sources, dests []string) []rosterEntry {
+	var entries, orphans []rosterEntry
+	found := func(paths []string) map[string]bool {
+		ids := make(map[string]bool)
+		sort.Strings(paths)
+		for _, path := range paths {
+			id := photoID(path)
+			ids[id] = true
+			if !ro.ids[id] {
+				orphans = append(orphans, rosterEntry{id: id, status: "not-on-roster", path: path})
+			}
+		}
+		return ids
+	}
+	inSource, inDest := found(sources), found(dests)
+
+	for _, id := range ro.order {
+		if !inSource[id] {
+			entries = append(entries, rosterEntry{id: id, status: "missing-source"})
+		}
+		if !inDest[id] {
+			entries = append(entries, rosterEntry{id: id, status: "missing-destination"})
+		}
+	}
+	return append(entries, orphans...)
+}
+
+// listImages - return the files under dir with the extension of a supported format
+func listImages(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == trashDir {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && len(resizer.FormatFromExt(path)) > 0 {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// reportRoster - reconcile the roster with a run's sources and destination directory,
+// print a summary and write every discrepancy to the CSV file output, if given
+func reportRoster(ro *roster, sources []string, dest, output string, mode os.FileMode) error {
+	dests, err := listImages(dest)
+	if err != nil {
+		return err
+	}
+	entries := ro.reconcile(sources, dests)
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.status]++
+	}
+	fmt.Printf("roster: %d expected, %d missing from source, %d missing from destination, %d photos not on roster\n",
+		len(ro.order), counts["missing-source"], counts["missing-destination"], counts["not-on-roster"])
+	if len(output) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "status", "path"})
+	for _, e := range entries {
+		w.Write([]string{e.id, e.status, e.path})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
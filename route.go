@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// routeFields - the metadata a route can match on, and the EXIF tag of each text field;
+// format is the format of the image data, such as png, whatever the extension
+var routeFields = map[string]uint16{
+	"make":       exifTagMake,
+	"model":      exifTagModel,
+	"software":   exifTagSoftware,
+	"megapixels": 0,
+	"format":     0,
+}
+
+// route - one rule of a --routes file: images whose field matches are written to
+// a subdirectory of the destination and processed with the settings of a profile
+type route struct {
+	field    string
+	match    string            // a case-insensitive glob, or for megapixels a range such as <2, >=12 or 2-12
+	dest     string            // subdirectory of the destination, empty for the destination itself
+	profile  string            // file with the settings, in the format of a directory profile
+	settings map[string]string // nil when there is no profile
+}
+
+// parseRange - parse a megapixel range such as <2, <=2, >12, >=12 or 2-12, which
+// includes its lower bound and excludes its upper bound
+func parseRange(s string) (low, high float64, err error) {
+	low, high = 0, 1e18
+	var v float64
+	switch {
+	case strings.HasPrefix(s, "<="), strings.HasPrefix(s, "<"):
+		v, err = strconv.ParseFloat(strings.TrimLeft(s, "<="), 64)
+		high = v
+		if strings.HasPrefix(s, "<=") {
+			high = v + 1e-9
+		}
+	case strings.HasPrefix(s, ">="), strings.HasPrefix(s, ">"):
+		v, err = strconv.ParseFloat(strings.TrimLeft(s, ">="), 64)
+		low = v
+		if !strings.HasPrefix(s, ">=") {
+			low = v + 1e-9
+		}
+	default:
+		parts := strings.Split(s, "-")
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("invalid megapixel range: %s", s)
+		}
+		if low, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err == nil {
+			high, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid megapixel range: %s", s)
+	}
+	return low, high, nil
+}
+
+// loadRoutes - read a CSV file of routes with a header of field, match, dest and
+// profile, checking every route so that mistakes are found before the run
+func loadRoutes(file string) ([]route, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"field", "match"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: no %s column", file, name)
+		}
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var routes []route
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		line, _ := r.FieldPos(0)
+		rt := route{
+			field:   strings.ToLower(column(record, "field")),
+			match:   column(record, "match"),
+			dest:    filepath.Clean(filepath.FromSlash(column(record, "dest"))),
+			profile: column(record, "profile"),
+		}
+		if _, ok := routeFields[rt.field]; !ok {
+			return nil, fmt.Errorf("%s:%d: unknown field: %s", file, line, rt.field)
+		}
+		if rt.field == "megapixels" {
+			_, _, err = parseRange(rt.match)
+		} else {
+			_, err = path.Match(strings.ToLower(rt.match), "")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+		if filepath.IsAbs(rt.dest) || rt.dest == ".." || strings.HasPrefix(rt.dest, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s:%d: dest must be a subdirectory of the destination: %s", file, line, rt.dest)
+		}
+		if len(rt.profile) > 0 {
+			if !filepath.IsAbs(rt.profile) {
+				rt.profile = filepath.Join(filepath.Dir(file), rt.profile)
+			}
+			if rt.settings, err = loadProfileFile(rt.profile); err == nil {
+				_, err = applyProfile(&options{}, rt.settings)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+			}
+		}
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// matches - return true if the image at file has metadata that matches rt;
+// text holds its EXIF text values
+func (rt route) matches(file string, text map[uint16]string) bool {
+	if rt.field == "megapixels" {
+		f, err := os.Open(file)
+		if err != nil {
+			return false
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			return false
+		}
+		low, high, _ := parseRange(rt.match)
+		mp := float64(cfg.Width) * float64(cfg.Height) / 1e6
+		return mp >= low && mp < high
+	}
+	if rt.field == "format" {
+		f, err := os.Open(file)
+		if err != nil {
+			return false
+		}
+		_, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			return false
+		}
+		pattern := strings.ToLower(rt.match)
+		matched, _ := path.Match(pattern, format)
+		if !matched && format == "jpeg" {
+			matched, _ = path.Match(pattern, "jpg")
+		}
+		return matched
+	}
+	value, ok := text[routeFields[rt.field]]
+	if !ok {
+		return false
+	}
+	matched, _ := path.Match(strings.ToLower(rt.match), strings.ToLower(value))
+	return matched
+}
+
+// routeFor - return the first of routes that the image at file matches, or nil
+func routeFor(routes []route, file string) *route {
+	if len(routes) == 0 {
+		return nil
+	}
+	text := exifText(file, exifTagMake, exifTagModel, exifTagSoftware)
+	for i := range routes {
+		if routes[i].matches(file, text) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
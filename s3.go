@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newS3Client - return a client with the credentials and region of the AWS
+// environment or shared configuration; endpoint, if given, is an S3 compatible
+// service.  The SDK retries transient errors itself.
+func newS3Client(endpoint string) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if len(endpoint) > 0 {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+// listS3 - call found with every object under the prefix of an S3 bucket
+func (b *bucket) listS3(ctx context.Context, found func(key string, info remoteObject) error) error {
+	pages := s3.NewListObjectsV2Paginator(b.s3, &s3.ListObjectsV2Input{Bucket: aws.String(b.name), Prefix: aws.String(b.prefix)})
+	for pages.HasMorePages() {
+		b.call()
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %v", b, err)
+		}
+		for _, obj := range page.Contents {
+			if err := found(aws.ToString(obj.Key), remoteObject{size: aws.ToInt64(obj.Size), modTime: aws.ToTime(obj.LastModified)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// downloadS3 - write the object key of an S3 bucket to w
+func (b *bucket) downloadS3(ctx context.Context, key string, w io.Writer) error {
+	b.call()
+	out, err := b.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(b.name), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+// uploadS3 - write the file f to the object key of an S3 bucket
+func (b *bucket) uploadS3(ctx context.Context, key string, f *os.File, contentType string) error {
+	input := &s3.PutObjectInput{Bucket: aws.String(b.name), Key: aws.String(key), Body: f}
+	if len(contentType) > 0 {
+		input.ContentType = aws.String(contentType)
+	}
+	b.call()
+	_, err := b.s3.PutObject(ctx, input)
+	return err
+}
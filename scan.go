@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scanner - submits every source to a virus or content scanner before it is decoded,
+// with an external command or over ICAP; a nil scanner lets every source through
+type scanner struct {
+	command    []string // the command and its arguments, the source is appended
+	icap       *url.URL // the ICAP service, such as icap://host:1344/avscan
+	timeout    time.Duration
+	quarantine string // directory rejected sources are moved to, empty to leave them
+}
+
+// newScanner - return a scanner for --scan-command and --scan-icap, only one of
+// which may be given, or nil when neither is
+func newScanner(command, icap string, timeout time.Duration, quarantine string) (*scanner, error) {
+	if len(command) == 0 && len(icap) == 0 {
+		return nil, nil
+	}
+	if len(command) > 0 && len(icap) > 0 {
+		return nil, errors.New("only one of --scan-command and --scan-icap can be used")
+	}
+	s := &scanner{command: strings.Fields(command), timeout: timeout, quarantine: quarantine}
+	if len(icap) > 0 {
+		u, err := url.Parse(icap)
+		if err != nil || u.Scheme != "icap" || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid --scan-icap: %s", icap)
+		}
+		if len(u.Port()) == 0 {
+			u.Host = net.JoinHostPort(u.Hostname(), "1344")
+		}
+		s.icap = u
+	}
+	return s, nil
+}
+
+// check - scan path, a file in the source directory, and return an error tagged
+// ERR_SCAN_REJECTED when the scanner rejects it, after moving it to the same place
+// under the quarantine, or ERR_SCAN when it can not be scanned
+func (s *scanner) check(source, path string) error {
+	if s == nil {
+		return nil
+	}
+	var clean bool
+	var err error
+	if s.icap != nil {
+		clean, err = s.scanICAP(path)
+	} else {
+		clean, err = s.scanCommand(path)
+	}
+	if err != nil {
+		return withReason(reasonScanFailed, err)
+	}
+	if clean {
+		return nil
+	}
+	if len(s.quarantine) == 0 {
+		return withReason(reasonScanRejected, fmt.Errorf("%s was rejected by the scanner", path))
+	}
+	rel, err := filepath.Rel(source, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	dst := filepath.Join(s.quarantine, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return withReason(reasonScanRejected, fmt.Errorf("%s was rejected by the scanner and can not be quarantined: %v", path, err))
+	}
+	if err := moveFile(path, dst, 0600); err != nil {
+		return withReason(reasonScanRejected, fmt.Errorf("%s was rejected by the scanner and can not be quarantined: %v", path, err))
+	}
+	return withReason(reasonScanRejected, fmt.Errorf("%s was rejected by the scanner and moved to %s", path, dst))
+}
+
+// scanCommand - run the --scan-command on path; exit code 0 is clean and 1 is
+// rejected, as with clamscan and clamdscan, anything else is an error
+func (s *scanner) scanCommand(path string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	args := append(append([]string{}, s.command[1:]...), path)
+	out, err := exec.CommandContext(ctx, s.command[0], args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, nil
+	case ctx.Err() != nil:
+		return false, fmt.Errorf("%s did not finish within %v", s.command[0], s.timeout)
+	}
+	return false, fmt.Errorf("%s: %v: %s", s.command[0], err, strings.TrimSpace(string(out)))
+}
+
+// scanICAP - send path to the ICAP service as the body of an HTTP response with
+// RESPMOD; 204 No Content is clean, while a modified response, or one naming an
+// infection or violation, is rejected
+func (s *scanner) scanICAP(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	conn, err := net.DialTimeout("tcp", s.icap.Host, s.timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	resHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s",
+		s.icap.String(), s.icap.Hostname(), len(resHeader), resHeader)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return false, err
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return false, err
+	}
+	parts := strings.Fields(status)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return false, fmt.Errorf("invalid ICAP response: %s", status)
+	}
+	code, _ := strconv.Atoi(parts[1])
+	switch {
+	case len(header.Get("X-Infection-Found")) > 0 || len(header.Get("X-Violations-Found")) > 0:
+		return false, nil
+	case code == 204:
+		return true, nil
+	case code == 200:
+		return false, nil
+	}
+	return false, fmt.Errorf("ICAP service answered: %s", status)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/esimov/caire"
+)
+
+// scheduleAliases - the shorthands --schedule accepts in place of five fields
+var scheduleAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// cronSchedule - when the serve subcommand runs a batch, parsed from the five
+// fields of a crontab entry: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	spec                           string
+	minute, hour, day, month, week uint64 // bit n is set when the value n matches
+	anyDay, anyWeekday             bool   // the day of month or of week is *
+}
+
+// parseCronField - parse one field of a crontab entry, a list of *, values and
+// ranges of values in [min,max], each optionally with a step such as */15
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value: %s", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value: %s", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%s is out of the range %d-%d", part, min, max)
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// parseSchedule - parse a crontab entry such as "0 2 * * *", or one of the
+// scheduleAliases; 7 is Sunday as well as 0
+func parseSchedule(spec string) (cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if alias, ok := scheduleAliases[spec]; ok {
+		fields = strings.Fields(alias)
+	}
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("invalid schedule, expected minute hour day month weekday: %s", spec)
+	}
+	c := cronSchedule{spec: spec, anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.day, 1, 31}, {&c.month, 1, 12}, {&c.week, 0, 7}} {
+		if *f.bits, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return cronSchedule{}, fmt.Errorf("invalid schedule %s: %v", spec, err)
+		}
+	}
+	if c.week&(1<<7) != 0 {
+		c.week |= 1
+	}
+	if c.next(time.Now()).IsZero() {
+		return cronSchedule{}, fmt.Errorf("invalid schedule, it never runs: %s", spec)
+	}
+	return c, nil
+}
+
+// matchesDay - return true if the schedule runs on the day of t; like cron,
+// when both the day of month and of week are given, either one matches
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	day := c.day&(1<<uint(t.Day())) != 0
+	weekday := c.week&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// next - return the first minute after t that the schedule runs at, or the
+// zero time if it never does, such as on February 30
+func (c cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		y, m, d := t.Date()
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// writePIDFile - write the process ID to name, refusing to when name holds the
+// ID of another process that is still running; the returned function removes it
+func writePIDFile(name string, mode os.FileMode) (func(), error) {
+	if data, err := ioutil.ReadFile(name); err == nil {
+		// signal 0 only checks that the process exists; other systems report
+		// an error for it, so their PID files are always taken over
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() {
+			if proc, err := os.FindProcess(pid); err == nil && proc.Signal(syscall.Signal(0)) == nil {
+				return nil, fmt.Errorf("%s is already running as process %d, see %s", pgmName, pid, name)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(name, []byte(fmt.Sprintf("%d\n", os.Getpid())), mode); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(name) }, nil
+}
+
+// runDaemon - the serve subcommand with --schedule, run a batch at every time
+// of the schedule until SIGTERM or an interrupt; a batch that is running then
+// stops handing out files and is reported on before the program exits.  With
+// --metrics, /metrics is served on listen meanwhile.
+func runDaemon(opts *options, p *caire.Processor, schedule cronSchedule, listen, pidFile, snapshotFile, snapshotChanges string) int {
+	// the daemon stops its batches itself, so that the handler of a batch
+	// run never exits the program between batches
+	interrupts.own()
+	if len(pidFile) > 0 {
+		remove, err := writePIDFile(pidFile, opts.fileMode)
+		if err != nil {
+			logs.error(logEntry{Action: "daemon", File: pidFile}.withErr(err), "Unable to write the PID file: %v\n", err)
+			return 1
+		}
+		defer remove()
+		interrupts.onAbort(remove)
+	}
+	if opts.metrics != nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", opts.metrics.handleMetrics)
+		go func() {
+			if err := http.ListenAndServe(listen, mux); err != nil {
+				logs.error(logEntry{Action: "listen"}.withErr(err), "Unable to serve /metrics: %v\n", err)
+			}
+		}()
+		logs.warn(logEntry{Action: "listen"}, "serving /metrics on %s\n", listen)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	for {
+		next := schedule.next(clock())
+		logs.info(logEntry{Action: "daemon"}, "next batch at %s (%s)\n", next.Format(time.RFC3339), schedule.spec)
+		timer := time.NewTimer(next.Sub(clock()))
+		select {
+		case sig := <-stop:
+			timer.Stop()
+			logs.warn(logEntry{Action: "daemon"}, "stopping on %s\n", sig)
+			return 0
+		case <-timer.C:
+		}
+
+		logs.info(logEntry{Action: "daemon", File: opts.source}, "starting the scheduled batch of %s\n", opts.source)
+		done := make(chan struct{})
+		finished := make(chan error, 1)
+		go func() {
+			if len(snapshotFile) > 0 {
+				var err error
+				if opts.snapshot, err = takeSnapshot(opts, snapshotFile, snapshotChanges); err != nil {
+					finished <- fmt.Errorf("unable to take snapshot: %v", err)
+					return
+				}
+			}
+			paths, errc := walkFiles(done, opts, opts.maxAge)
+			finished <- processAll(done, paths, errc, opts, p)
+		}()
+		select {
+		case err := <-finished:
+			close(done)
+			if err != nil {
+				logs.error(logEntry{Action: "daemon"}.withErr(err), "The scheduled batch failed: %v\n", err)
+			}
+		case sig := <-stop:
+			interrupts.markStopped()
+			logs.warn(logEntry{Action: "daemon"}, "stopping on %s, once the files being processed are done or within %v\n", sig, opts.gracePeriod)
+			close(done)
+			grace := time.NewTimer(opts.gracePeriod)
+			select {
+			case <-finished:
+				grace.Stop()
+				return 0
+			case sig = <-stop:
+				logs.warn(logEntry{Action: "daemon"}, "aborting on %s\n", sig)
+			case <-grace.C:
+				logs.warn(logEntry{Action: "daemon"}, "aborting, the files being processed were not done within %v\n", opts.gracePeriod)
+			}
+			// removes the incomplete outputs and the PID file, and exits
+			interrupts.abort()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// bitsOf - return the bits of a parsed crontab field that match values
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, n := range values {
+		bits |= 1 << uint(n)
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{field: "*", min: 0, max: 7, want: bitsOf(0, 1, 2, 3, 4, 5, 6, 7)},
+		{field: "5", min: 0, max: 59, want: bitsOf(5)},
+		{field: "1,3,5", min: 0, max: 59, want: bitsOf(1, 3, 5)},
+		{field: "1-5", min: 0, max: 7, want: bitsOf(1, 2, 3, 4, 5)},
+		{field: "*/15", min: 0, max: 59, want: bitsOf(0, 15, 30, 45)},
+		{field: "5/20", min: 0, max: 59, want: bitsOf(5, 25, 45)},
+		{field: "1-10/3", min: 1, max: 31, want: bitsOf(1, 4, 7, 10)},
+		{field: "0-1,22-23", min: 0, max: 23, want: bitsOf(0, 1, 22, 23)},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "0", min: 1, max: 31, wantErr: true},
+		{field: "5-1", min: 0, max: 59, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "*/x", min: 0, max: 59, wantErr: true},
+		{field: "a", min: 0, max: 59, wantErr: true},
+		{field: "1-", min: 0, max: 59, wantErr: true},
+		{field: "", min: 0, max: 59, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q, %d, %d) = %b, want an error", tt.field, tt.min, tt.max, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q, %d, %d): %v", tt.field, tt.min, tt.max, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q, %d, %d) = %b, want %b", tt.field, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 6, 12, 10, 17, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", at(6, 12, 10, 18)},
+		{"*/15 * * * *", at(6, 12, 10, 30)},
+		{"30 10 12 6 *", at(6, 12, 10, 30)},
+		{"0 2 * * *", at(6, 13, 2, 0)},
+		{"@daily", at(6, 13, 0, 0)},
+		{"0 0 31 * *", at(7, 31, 0, 0)},
+		// Sunday is 0 or 7
+		{"0 9 * * 0", at(6, 16, 9, 0)},
+		{"0 9 * * 7", at(6, 16, 9, 0)},
+		{"@weekly", at(6, 16, 0, 0)},
+		// given both, either the day of month or the day of week matches
+		{"0 0 * * 5", at(6, 14, 0, 0)},
+		{"0 0 13 * 5", at(6, 13, 0, 0)},
+		{"0 0 1 * 1", at(6, 17, 0, 0)},
+		{"0 0 1 7 1", at(7, 1, 0, 0)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := c.next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: next(%v) = %v, want %v", tt.spec, from, got, tt.want)
+		}
+	}
+
+	// a schedule that only matches a day that does not exist never runs
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4 *", "0 0 31 2,4,6,9,11 *"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error since it never runs", spec)
+		}
+	}
+	feb30 := cronSchedule{minute: bitsOf(0), hour: bitsOf(0), day: bitsOf(30), month: bitsOf(2), anyWeekday: true}
+	if got := feb30.next(from); !got.IsZero() {
+		t.Errorf("February 30: next(%v) = %v, want the zero time", from, got)
+	}
+	// unless a day of the week is given as well
+	if _, err := parseSchedule("0 0 30 2 1"); err != nil {
+		t.Errorf("parseSchedule(%q): %v", "0 0 30 2 1", err)
+	}
+
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "@yearly", "61 * * * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
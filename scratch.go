@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// scratch - a directory of a run's intermediate files, under --tmp-dir, with a
+// subdirectory for each worker so that their files never collide; it is removed
+// when the run ends, also when it is interrupted
+type scratch struct {
+	root string
+}
+
+// newScratch - create the scratch directory of a run in dir, or in the system's
+// temporary directory when dir is empty
+func newScratch(dir string) (*scratch, error) {
+	root, err := ioutil.TempDir(dir, pgmName)
+	if err != nil {
+		return nil, err
+	}
+	s := &scratch{root: root}
+	interrupts.onAbort(s.cleanup)
+	return s, nil
+}
+
+// worker - return the scratch directory of worker n, creating it if needed
+func (s *scratch) worker(n int) (string, error) {
+	dir := filepath.Join(s.root, fmt.Sprintf("worker-%d", n))
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// cleanup - remove the scratch directory and everything in it
+func (s *scratch) cleanup() {
+	os.RemoveAll(s.root)
+}
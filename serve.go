@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/esimov/caire"
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+const (
+	previewMaxEdge     = 320      // default longest edge of /preview images
+	previewLargestEdge = 1024     // largest longest edge a /preview request may ask for
+	previewJPEGQuality = 75       // previews favor speed over quality
+	defaultCropMargin  = 40       // percent of the face's size kept around it in crop mode
+	maxUploadBytes     = 32 << 20 // largest image that can be uploaded
+)
+
+// server - the HTTP endpoints of the serve subcommand
+type server struct {
+	opts  *options
+	p     *caire.Processor // only copied, see resizer.CloneProcessor, as requests are served concurrently
+	fd    *resizer.FaceDetector
+	cache *thumbCache // resized photos of /photo, nil unless --cache-dir is given
+	jobs  *jobQueue   // batch runs submitted to /jobs, nil unless --jobs-root is given
+}
+
+// readUpload - decode the image sent as the "image" field of a multipart
+// form, or otherwise as the whole request body
+func readUpload(w http.ResponseWriter, r *http.Request, maxPixels int) (image.Image, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		img, _, err := decodeReader(file, maxPixels)
+		return img, err
+	}
+	img, _, err := decodeReader(r.Body, maxPixels)
+	return img, err
+}
+
+// previewSize - return the size requested by the preset or size form values,
+// falling back to the size given on the command line
+func (s *server) previewSize(r *http.Request, fit bool) (resizer.Size, error) {
+	return s.requestedSize(r.FormValue("preset"), r.FormValue("size"), fit)
+}
+
+// requestedSize - return the size of the preset name or the size spec, whichever
+// is given, falling back to the size given on the command line
+func (s *server) requestedSize(name, spec string, fit bool) (resizer.Size, error) {
+	if len(name) > 0 {
+		pr, ok := presets[name]
+		if !ok {
+			return resizer.Size{}, fmt.Errorf("unknown preset: %s", name)
+		}
+		return resizer.Size{Width: pr.width, Height: pr.height, Fit: fit}, nil
+	}
+	if len(spec) > 0 {
+		return resizer.ParseSize(spec, fit)
+	}
+	if !s.opts.size.IsSet() {
+		return resizer.Size{}, errors.New("a size or preset is required")
+	}
+	return s.opts.size, nil
+}
+
+// handlePreview - return a small JPEG showing what an uploaded image would look
+// like after processing.  Form values: mode (carve, fit or crop), size (WxH) or
+// preset, margin (percent around the face for crop) and max (longest edge).
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST an image to preview", http.StatusMethodNotAllowed)
+		return
+	}
+	img, err := readUpload(w, r, s.opts.maxPixels)
+	if reasonOf(err) == reasonTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, string(reasonDecode)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode := r.FormValue("mode")
+	if len(mode) == 0 {
+		mode = "carve"
+	}
+	size, err := s.previewSize(r, mode == "fit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxEdge := previewMaxEdge
+	if v := r.FormValue("max"); len(v) > 0 {
+		if maxEdge, err = strconv.Atoi(v); err != nil || maxEdge <= 0 || maxEdge > previewLargestEdge {
+			http.Error(w, "invalid max: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	margin := float64(defaultCropMargin)
+	if v := r.FormValue("margin"); len(v) > 0 {
+		if margin, err = strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err != nil || margin < 0 {
+			http.Error(w, "invalid margin: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var preview image.Image
+	switch mode {
+	case "carve", "fit":
+		preview, err = s.previewResize(img, size, maxEdge)
+	case "crop":
+		preview, err = s.previewCrop(img, size, margin, maxEdge)
+	default:
+		err = fmt.Errorf("invalid mode: %s", mode)
+	}
+	if err == nil {
+		// finished as the output would be, so that the preview shows its aspect ratio and background
+		preview, err = s.opts.imageResizer(s.p).Finish(preview, "jpeg")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	if err := jpeg.Encode(w, preview, &jpeg.Options{Quality: previewJPEGQuality}); err != nil {
+		logs.warn(logEntry{Action: "preview"}.withErr(err), "Unable to send preview: %v\n", err)
+	}
+}
+
+// previewResize - carve or fit img as it would be at full size, but first scale
+// everything down so the result's longest edge is at most maxEdge
+func (s *server) previewResize(img image.Image, size resizer.Size, maxEdge int) (image.Image, error) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	tw, th, ok := size.Target(w, h)
+	if !ok {
+		tw, th = w, h
+	}
+	tw, th = resizer.ResolveTarget(w, h, tw, th)
+
+	// shrink the source by the same factor as the target so the same share of it is carved away
+	pw, ph := tw, th
+	if pw > maxEdge || ph > maxEdge {
+		pw, ph = resizer.FitWithin(tw, th, maxEdge, maxEdge)
+	}
+	work := img
+	if pw < tw {
+		var err error
+		if work, err = resizer.Scale(s.p, img, resizer.ScaleDimension(w, float64(pw)*100/float64(tw)), 0); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		return work, nil
+	}
+	if size.Fit || size.Percent > 0 || (size.Width == 0 || size.Height == 0) {
+		return resizer.Scale(s.p, work, pw, 0)
+	}
+	return resizer.ResizeTo(s.p, work, pw, ph)
+}
+
+// previewCrop - crop img around its face to the aspect ratio of size with margin
+// percent of the face's size on each side, then scale it to fit within maxEdge
+func (s *server) previewCrop(img image.Image, size resizer.Size, margin float64, maxEdge int) (image.Image, error) {
+	if size.Width == 0 || size.Height == 0 {
+		return nil, errors.New("crop mode needs both a width and height")
+	}
+	work := img
+	if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w > resizer.DetectionMax || h > resizer.DetectionMax {
+		dw, _ := resizer.FitWithin(w, h, resizer.DetectionMax, resizer.DetectionMax)
+		var err error
+		if work, err = resizer.Scale(s.p, img, dw, 0); err != nil {
+			return nil, err
+		}
+	}
+	faces := s.fd.Detect(work)
+	if len(faces) == 0 {
+		return nil, errors.New(string(reasonNoFace))
+	}
+	cropped := resizer.CropToFace(work, resizer.PrimaryFace(faces), margin, size.Width, size.Height)
+
+	pw, ph := resizer.FitWithin(size.Width, size.Height, maxEdge, maxEdge)
+	if cw := cropped.Bounds().Dx(); pw >= cw {
+		return cropped, nil
+	}
+	return resizer.Scale(s.p, cropped, pw, ph)
+}
+
+// findPhoto - return the source file of the photo id, its name relative to the
+// source directory with or without its extension
+func (s *server) findPhoto(id string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+id), "/")
+	if len(name) == 0 {
+		return "", errors.New("a photo ID is required")
+	}
+	srcname := filepath.Join(s.opts.source, filepath.FromSlash(name))
+	if fileExists(srcname) {
+		return srcname, nil
+	}
+	infos, err := ioutil.ReadDir(filepath.Dir(srcname))
+	if err != nil {
+		return "", fmt.Errorf("no photo %s", id)
+	}
+	base := filepath.Base(srcname)
+	for _, info := range infos {
+		ext := filepath.Ext(info.Name())
+		if info.Mode().IsRegular() && strings.TrimSuffix(info.Name(), ext) == base && len(resizer.FormatFromExt(info.Name())) > 0 {
+			return filepath.Join(filepath.Dir(srcname), info.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("no photo %s", id)
+}
+
+// handlePhoto - GET /photo/{id}, return the source photo id resized as a batch run
+// would to the size or preset query value, or to the size given on the command
+// line, as JPEG; resized photos are kept in the --cache-dir and served from there
+// until their source changes
+func (s *server) handlePhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "GET a photo", http.StatusMethodNotAllowed)
+		return
+	}
+	srcname, err := s.findPhoto(strings.TrimPrefix(r.URL.Path, "/photo/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	size, err := s.previewSize(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(srcname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	key := thumbKey(srcname, info.ModTime(), fmt.Sprintf("%+v", size), s.opts.params())
+	data, hit := s.cache.get(key)
+	if !hit {
+		if data, err = s.resizePhoto(srcname, size); reasonOf(err) == reasonTooLarge || reasonOf(err) == reasonDecode {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			logs.error(logEntry{Action: "photo", File: srcname}.withErr(err), "Unable to resize %s: %v\n", srcname, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.cache.put(key, data, s.opts.fileMode); err != nil {
+			logs.warn(logEntry{Action: "cache", File: srcname}.withErr(err), "Unable to cache %s: %v\n", srcname, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if hit {
+		w.Header().Set("X-Cache", "hit")
+	} else {
+		w.Header().Set("X-Cache", "miss")
+	}
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// resizePhoto - return srcname resized to size with the engine, DPI, quality and
+// background of the command line, encoded as JPEG
+func (s *server) resizePhoto(srcname string, size resizer.Size) ([]byte, error) {
+	img, _, err := decodeImage(srcname, s.opts.maxPixels)
+	if err != nil {
+		if reasonOf(err) != reasonTooLarge {
+			err = withReason(reasonDecode, err)
+		}
+		return nil, err
+	}
+	data, _, err := s.encodeResized(img, size, "jpeg", s.opts.quality)
+	return data, err
+}
+
+// encodeResized - return img resized to size with the engine of the command line
+// and finished as a batch run's outputs are, with its aspect ratio, alpha policy
+// and background, encoded in format with quality, and the resized image's bounds
+func (s *server) encodeResized(img image.Image, size resizer.Size, format string, quality int) ([]byte, image.Rectangle, error) {
+	r := s.opts.imageResizer(s.p)
+	if width, height, ok := size.Target(img.Bounds().Dx(), img.Bounds().Dy()); ok {
+		var err error
+		if img, err = r.ResizeWithEngine(img, width, height); err != nil {
+			return nil, image.Rectangle{}, withReason(reasonResize, err)
+		}
+	}
+	img, err := r.Finish(img, format)
+	if err != nil {
+		return nil, image.Rectangle{}, withReason(reasonTransparent, err)
+	}
+	var buf bytes.Buffer
+	if err := resizer.Encode(&buf, img, format, s.opts.dpi, quality); err != nil {
+		return nil, image.Rectangle{}, withReason(reasonEncode, err)
+	}
+	return buf.Bytes(), img.Bounds(), nil
+}
+
+// runServer - serve the HTTP endpoints on listen until the server fails
+func runServer(opts *options, p *caire.Processor, classifier, listen, grpcListen, jobsRoot string) int {
+	fd, err := resizer.NewFaceDetector(classifier)
+	if err != nil {
+		log.Fatalf("Unable to load classification file: %s ; %s\n", classifier, err)
+	}
+	s := &server{opts: opts, p: p, fd: fd}
+	if len(opts.cacheDir) > 0 {
+		if s.cache, err = openThumbCache(opts.cacheDir, int64(opts.cacheMB)<<20, opts.dirMode); err != nil {
+			log.Fatalf("Unable to open --cache-dir: %v\n", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/preview", s.handlePreview)
+	if len(opts.source) > 0 {
+		mux.HandleFunc("/photo/", s.handlePhoto)
+	}
+	if len(jobsRoot) > 0 {
+		s.jobs = newJobQueue(jobsRoot)
+		go s.jobs.run(p)
+		mux.HandleFunc("/jobs", requireToken(opts.token, s.handleJobs))
+		mux.HandleFunc("/jobs/", requireToken(opts.token, s.handleJobs))
+	}
+	if opts.metrics != nil {
+		if s.jobs != nil {
+			opts.metrics.queueLength = func() int { return len(s.jobs.queued) }
+		}
+		mux.HandleFunc("/metrics", opts.metrics.handleMetrics)
+	}
+	if len(grpcListen) > 0 {
+		lis, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			log.Fatalf("Unable to listen on --grpc-listen: %v\n", err)
+		}
+		go func() {
+			if err := newGRPCServer(s).Serve(lis); err != nil {
+				logs.error(logEntry{Action: "listen"}.withErr(err), "%v\n", err)
+			}
+		}()
+		logs.warn(logEntry{Action: "listen"}, "serving gRPC on %s\n", grpcListen)
+	}
+	logs.warn(logEntry{Action: "listen"}, "listening on %s\n", listen)
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		logs.error(logEntry{Action: "listen"}.withErr(err), "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDefaultKeys - the private keys in ~/.ssh tried when neither --sftp-key nor
+// an SSH agent is available
+var sftpDefaultKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sftpConn - one SSH connection and the SFTP session on it
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// close - end the SFTP session and the SSH connection
+func (c *sftpConn) close() {
+	c.sftp.Close()
+	c.ssh.Close()
+}
+
+// sftpPool - the connections to one SFTP server, shared by the walker and the
+// workers.  A connection is dialed when none is idle and kept for the next call
+// when it is done, up to one per worker; one that failed is closed instead, so
+// that a dropped connection is replaced rather than reused.
+type sftpPool struct {
+	addr   string
+	config *ssh.ClientConfig
+	idle   chan *sftpConn
+}
+
+// sftpAuth - return the ways to authenticate with: the private key in keyFile,
+// the keys of the SSH agent of SSH_AUTH_SOCK, and without either of those, the
+// sftpDefaultKeys that exist.  Keys protected by a passphrase need the agent.
+func sftpAuth(keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	var keyFiles []string
+	if len(keyFile) > 0 {
+		keyFiles = append(keyFiles, keyFile)
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if len(keyFile) == 0 && len(methods) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range sftpDefaultKeys {
+				if fileExists(filepath.Join(home, ".ssh", name)) {
+					keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+				}
+			}
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range keyFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the private key %s: %v", name, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH key: give --sftp-key or start an SSH agent")
+	}
+	return methods, nil
+}
+
+// newSFTPPool - return a pool of up to size connections to host, given as
+// [user@]host[:port], authenticated with sftpAuth and checked against the host
+// keys in knownHosts, or ~/.ssh/known_hosts when it is empty
+func newSFTPPool(host, keyFile, knownHosts string, size int) (*sftpPool, error) {
+	name := ""
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		name, host = host[:i], host[i+1:]
+	}
+	if len(name) == 0 {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = u.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	if len(knownHosts) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the known hosts: %v", err)
+	}
+	auth, err := sftpAuth(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if size < 1 {
+		size = 1
+	}
+	config := &ssh.ClientConfig{User: name, Auth: auth, HostKeyCallback: hostKeys, Timeout: 30 * time.Second}
+	return &sftpPool{addr: host, config: config, idle: make(chan *sftpConn, size)}, nil
+}
+
+// get - return an idle connection, or a new one when none is
+func (p *sftpPool) get() (*sftpConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+	client, err := ssh.Dial("tcp", p.addr, p.config)
+	if err != nil {
+		return nil, err
+	}
+	session, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sftpConn{ssh: client, sftp: session}, nil
+}
+
+// do - call fn with a connection of the pool, which is kept for the next call
+// unless fn failed or the pool is full
+func (p *sftpPool) do(fn func(client *sftp.Client) error) error {
+	c, err := p.get()
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %v", p.addr, err)
+	}
+	if err = fn(c.sftp); err != nil {
+		c.close()
+		return err
+	}
+	select {
+	case p.idle <- c:
+	default:
+		c.close()
+	}
+	return nil
+}
+
+// close - close the idle connections
+func (p *sftpPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.close()
+		default:
+			return
+		}
+	}
+}
+
+// sftpPath - return the path on the server of the key, which is relative to /
+func sftpPath(key string) string {
+	return "/" + key
+}
+
+// listSFTP - call found with every regular file under the prefix of an SFTP
+// server, walking its directories with one connection
+func (b *bucket) listSFTP(ctx context.Context, found func(key string, info remoteObject) error) error {
+	return b.sftp.do(func(client *sftp.Client) error {
+		var walk func(dir string) error
+		walk = func(dir string) error {
+			b.call()
+			entries, err := client.ReadDir(sftpPath(dir))
+			if err != nil {
+				return fmt.Errorf("unable to list %s%s: %v", b.scheme+b.name+"/", dir, err)
+			}
+			for _, entry := range entries {
+				key := path.Join(dir, entry.Name())
+				switch {
+				case entry.IsDir():
+					err = walk(key)
+				case entry.Mode().IsRegular():
+					err = found(key, remoteObject{size: entry.Size(), modTime: entry.ModTime()})
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		}
+		return walk(strings.TrimSuffix(b.prefix, "/"))
+	})
+}
+
+// downloadSFTP - write the file key of an SFTP server to w
+func (b *bucket) downloadSFTP(ctx context.Context, key string, w io.Writer) error {
+	return b.sftp.do(func(client *sftp.Client) error {
+		b.call()
+		f, err := client.Open(sftpPath(key))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteTo(w)
+		return err
+	})
+}
+
+// uploadSFTP - write the file f to the file key of an SFTP server, creating its
+// directories as needed
+func (b *bucket) uploadSFTP(ctx context.Context, key string, f *os.File) error {
+	return b.sftp.do(func(client *sftp.Client) error {
+		b.call()
+		if err := client.MkdirAll(path.Dir(sftpPath(key))); err != nil {
+			return err
+		}
+		b.call()
+		out, err := client.Create(sftpPath(key))
+		if err != nil {
+			return err
+		}
+		if _, err := out.ReadFrom(f); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shardSpec - the part of the discovered files handled by this run, so that
+// several machines can split one large batch; the zero value handles every file
+type shardSpec struct {
+	index int // 1 based
+	count int
+}
+
+// parseShard - parse a shard specification such as 2/8, an empty string means no sharding
+func parseShard(spec string) (shardSpec, error) {
+	if len(spec) == 0 {
+		return shardSpec{}, nil
+	}
+	invalid := fmt.Errorf("invalid shard specification: %s", spec)
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return shardSpec{}, invalid
+	}
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return shardSpec{}, invalid
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil || count < 1 || index < 1 || index > count {
+		return shardSpec{}, invalid
+	}
+	return shardSpec{index: index, count: count}, nil
+}
+
+// owns - return true if path belongs to this shard
+//
+// Files are assigned by rendezvous hashing, so every machine agrees on the
+// assignment without talking to the others, re-runs get the same assignment
+// and changing the number of shards only moves the files it has to.  The key
+// is the lower cased file name without its extension, so sources that would
+// share a destination name always land on the same shard and --conflict-strategy
+// still sees all of them.
+func (s shardSpec) owns(path string) bool {
+	if s.count <= 1 {
+		return true
+	}
+	name := strings.ToLower(filepath.Base(path))
+	key := strings.TrimSuffix(name, filepath.Ext(name))
+
+	best, bestScore := 0, uint64(0)
+	for i := 1; i <= s.count; i++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%s", i, key)
+		if score := mix(h.Sum64()); i == 1 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best == s.index
+}
+
+// mix - spread the bits of an FNV hash, whose high bits barely change between
+// keys that differ by one character, so that comparing hashes is fair
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (s shardSpec) String() string {
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    shardSpec
+		wantErr bool
+	}{
+		{spec: "", want: shardSpec{}},
+		{spec: "1/1", want: shardSpec{index: 1, count: 1}},
+		{spec: "2/8", want: shardSpec{index: 2, count: 8}},
+		{spec: "8/8", want: shardSpec{index: 8, count: 8}},
+		{spec: "0/8", wantErr: true},
+		{spec: "9/8", wantErr: true},
+		{spec: "1/0", wantErr: true},
+		{spec: "-1/8", wantErr: true},
+		{spec: "2", wantErr: true},
+		{spec: "2/8/1", wantErr: true},
+		{spec: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseShard(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseShard(%q) = %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseShard(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseShard(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// testPaths - return n source paths spread over a few directories
+func testPaths(n int) []string {
+	var paths []string
+	for i := 0; i < n; i++ {
+		paths = append(paths, filepath.Join(fmt.Sprintf("dept%d", i%7), fmt.Sprintf("IMG_%04d.jpg", i)))
+	}
+	return paths
+}
+
+func TestShardOwnsEveryFileOnce(t *testing.T) {
+	paths := testPaths(1000)
+	for _, count := range []int{1, 2, 3, 8} {
+		owned := make([]int, count+1)
+		for _, path := range paths {
+			owners := 0
+			for i := 1; i <= count; i++ {
+				if (shardSpec{index: i, count: count}).owns(path) {
+					owners++
+					owned[i]++
+				}
+			}
+			if owners != 1 {
+				t.Errorf("%s is owned by %d of %d shards, want 1", path, owners, count)
+			}
+		}
+		for i := 1; i <= count; i++ {
+			if owned[i] == 0 {
+				t.Errorf("shard %d/%d owns none of %d files", i, count, len(paths))
+			}
+		}
+	}
+	for _, path := range paths {
+		if !(shardSpec{}).owns(path) {
+			t.Errorf("the zero shardSpec does not own %s", path)
+		}
+	}
+}
+
+func TestShardAssignmentIsStable(t *testing.T) {
+	paths := testPaths(500)
+	assign := func(count int) map[string]int {
+		m := make(map[string]int)
+		for _, path := range paths {
+			for i := 1; i <= count; i++ {
+				if (shardSpec{index: i, count: count}).owns(path) {
+					m[path] = i
+				}
+			}
+		}
+		return m
+	}
+
+	first, again := assign(8), assign(8)
+	for _, path := range paths {
+		if first[path] != again[path] {
+			t.Errorf("%s moved from shard %d to %d between runs", path, first[path], again[path])
+		}
+	}
+
+	// growing from 8 to 9 shards only moves files to the new shard
+	grown := assign(9)
+	for _, path := range paths {
+		if grown[path] != first[path] && grown[path] != 9 {
+			t.Errorf("%s moved from shard %d to %d when a shard was added", path, first[path], grown[path])
+		}
+	}
+
+	// sources that would share a destination name land on the same shard
+	s := shardSpec{index: 3, count: 8}
+	for _, pair := range [][2]string{
+		{"a/photo.jpg", "b/photo.png"},
+		{"a/Photo.JPG", "b/photo.jpg"},
+	} {
+		if s.owns(pair[0]) != s.owns(pair[1]) {
+			t.Errorf("%s and %s are on different shards", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// sigSuffix - appended to the name of a signed file to name its detached signature
+const sigSuffix = ".sig"
+
+// readPEM - return the DER bytes of the first PEM block in the file at path
+func readPEM(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: not a PEM file", path)
+	}
+	return block.Bytes, nil
+}
+
+// loadSigningKey - return the Ed25519 private key in the PKCS#8 PEM file at path,
+// as written by: openssl genpkey -algorithm ed25519
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// loadVerifyKey - return the Ed25519 public key in the PEM file at path, as
+// written by: openssl pkey -pubout
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return public, nil
+}
+
+// signFile - write a detached signature of the file at path to path.sig; the
+// signature is the raw 64 bytes that openssl pkeyutl -verify -rawin expects
+func signFile(key ed25519.PrivateKey, path string, mode os.FileMode) error {
+	if key == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+sigSuffix, ed25519.Sign(key, data), mode)
+}
+
+// verifyFile - check the detached signature of the file at path
+func verifyFile(key ed25519.PublicKey, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig, err := ioutil.ReadFile(path + sigSuffix)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("signature does not match, the file was changed after it was signed")
+	}
+	return nil
+}
+
+// runVerify - the verify subcommand, check the signatures written by --sign-key
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM file of the public key matching --sign-key")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s verify --key FILE file...\n", pgmName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(*keyPath) == 0 || fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	key, err := loadVerifyKey(*keyPath)
+	if err != nil {
+		log.Fatalf("Unable to read public key: %v\n", err)
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		if err := verifyFile(key, name); err != nil {
+			log.Printf("Unable to verify %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("verified: %s\n", name)
+	}
+	return status
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotChanges - what happens to sources that changed after the --snapshot:
+// warn processes them anyway, skip leaves them alone
+var snapshotChanges = []string{"warn", "skip"}
+
+// validSnapshotChanges - return true if mode is one of snapshotChanges
+func validSnapshotChanges(mode string) bool {
+	for _, m := range snapshotChanges {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotEntry - one line of a --snapshot file
+type snapshotEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Hash    string    `json:"hash,omitempty"` // instead of SHA256, with another --hash
+}
+
+// snapshot - the state of the source files at the start of a run, so that files
+// edited during a long batch are noticed before they are processed; a nil snapshot
+// notices nothing
+type snapshot struct {
+	files map[string]fileState
+	skip  bool
+}
+
+// takeSnapshot - record the size, modification time and hash of every source
+// file of a run in file, one JSON object per line; files are hashed by as many
+// goroutines as there are workers
+func takeSnapshot(opts *options, file, changes string) (*snapshot, error) {
+	w, err := newPoller(opts)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{files: make(map[string]fileState), skip: changes == "skip"}
+	enc := json.NewEncoder(out)
+	sums := hashFiles(opts.hashAlgorithm, paths, opts.numWorkers)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		sum, ok := sums[path]
+		if !ok {
+			continue
+		}
+		s.files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		rel, err := filepath.Rel(opts.source, path)
+		if err != nil {
+			rel = path
+		}
+		entry := snapshotEntry{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+		if opts.hashAlgorithm != "sha256" {
+			entry.SHA256, entry.Hash = "", sum
+		}
+		if err := enc.Encode(entry); err != nil {
+			out.Close()
+			return nil, err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	logs.info(logEntry{Action: "snapshot", Dest: file}, "snapshot: %d files recorded in %s\n", len(s.files), file)
+	return s, nil
+}
+
+// check - return an error if the file at path has changed since the snapshot;
+// files that were added after it are not checked
+func (s *snapshot) check(path string) error {
+	if s == nil {
+		return nil
+	}
+	before, ok := s.files[path]
+	if !ok {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if info.Size() != before.size || !info.ModTime().Equal(before.modTime) {
+		return withReason(reasonSourceEdited, fmt.Errorf("%s changed after the snapshot at the start of the run", path))
+	}
+	return nil
+}
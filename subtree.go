@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// subtrees - the directories under the source root that --only-under restricts
+// processing to, relative to the root; empty for the whole tree
+type subtrees []string
+
+// parseSubtrees - return the --only-under directories relative to source, each of
+// which must be an existing directory inside it
+func parseSubtrees(source string, dirs []string) (subtrees, error) {
+	var st subtrees
+	for _, dir := range dirs {
+		rel := filepath.Clean(dir)
+		if filepath.IsAbs(rel) {
+			root, err := filepath.Abs(source)
+			if err != nil {
+				return nil, err
+			}
+			if rel, err = filepath.Rel(root, rel); err != nil {
+				return nil, err
+			}
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("--only-under %s is not inside %s", dir, source)
+		}
+		if !dirExists(filepath.Join(source, rel)) {
+			return nil, fmt.Errorf("--only-under %s is not a directory in %s", dir, source)
+		}
+		st = append(st, rel)
+	}
+	return st, nil
+}
+
+// selects - return true if rel, a path relative to the source root, is inside one
+// of the subtrees, or is a directory that leads to one
+func (st subtrees) selects(rel string, info os.FileInfo) bool {
+	if len(st) == 0 || rel == "." {
+		return true
+	}
+	sep := string(filepath.Separator)
+	for _, dir := range st {
+		if dir == "." || rel == dir || strings.HasPrefix(rel, dir+sep) {
+			return true
+		}
+		if info.IsDir() && strings.HasPrefix(dir, rel+sep) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runSummary - the outcome of a batch run as one line of JSON, written by
+// --summary-file and --summary-fd for wrapper scripts
+type runSummary struct {
+	Version      string         `json:"version"`
+	RunID        string         `json:"run_id"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Scanned      int            `json:"scanned"` // files found in the source, selected or not
+	Files        int            `json:"files"`   // sources that were selected
+	Written      int            `json:"written"` // outputs that were written or were already up to date
+	Failed       int            `json:"failed"`
+	Reasons      map[reason]int `json:"reasons,omitempty"` // of the failures and fallbacks
+	Skipped      map[reason]int `json:"skipped,omitempty"` // by reason, including those not selected
+	Copies       int            `json:"copies"`
+	Resizes      int            `json:"resizes"`
+	BytesRead    int64          `json:"bytes_read"`
+	BytesWritten int64          `json:"bytes_written"`
+	Error        string         `json:"error,omitempty"` // that ended the run early
+	Interrupted  bool           `json:"interrupted,omitempty"`
+	seen         map[string]bool
+}
+
+// count - add the result of one output of a source to s; with --sizes a source
+// has several
+func (s *runSummary) count(r result) {
+	if !s.seen[r.path] {
+		if s.seen == nil {
+			s.seen = make(map[string]bool)
+		}
+		s.seen[r.path] = true
+		s.Files++
+	}
+	if len(r.dest) > 0 {
+		s.Written++
+	}
+	if r.err == nil {
+		return
+	}
+	code := reasonOf(r.err)
+	if code != reasonFallbackCopy {
+		s.Failed++
+	}
+	if len(code) > 0 {
+		if s.Reasons == nil {
+			s.Reasons = make(map[reason]int)
+		}
+		s.Reasons[code]++
+	}
+}
+
+// finish - complete s with the counters of stats and err once the run has ended,
+// and copy it to the summaryTo of opts
+func (s *runSummary) finish(opts *options, err error) {
+	s.Version, s.RunID, s.End = pgmVersion, runID, clock()
+	if opts.stats != nil {
+		opts.stats.mu.Lock()
+		s.Copies, s.Resizes = opts.stats.copies, opts.stats.resizes
+		s.BytesRead, s.BytesWritten = opts.stats.bytesRead, opts.stats.bytesWritten
+		s.Scanned = opts.stats.scanned
+		for code, n := range opts.stats.skipped {
+			if s.Skipped == nil {
+				s.Skipped = make(map[reason]int)
+			}
+			s.Skipped[code] = n
+		}
+		opts.stats.mu.Unlock()
+	}
+	// a --manifest names its sources instead of walking the source directory
+	if s.Scanned < s.Files {
+		s.Scanned = s.Files
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.Interrupted = interrupts.interrupted()
+	if opts.summaryTo != nil {
+		*opts.summaryTo = *s
+	}
+}
+
+// writeSummary - write the finished s to the --summary-file and the
+// --summary-fd, whichever are given
+func writeSummary(opts *options, s *runSummary) error {
+	if len(opts.summaryFile) == 0 && opts.summaryFD == 0 {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if len(opts.summaryFile) > 0 {
+		if err := ioutil.WriteFile(opts.summaryFile, data, opts.fileMode); err != nil {
+			return err
+		}
+		if err := signFile(opts.signingKey, opts.summaryFile, opts.fileMode); err != nil {
+			return err
+		}
+	}
+	if opts.summaryFD > 0 {
+		// the descriptor is left open for the summaries of later watch passes
+		if _, err := os.NewFile(uintptr(opts.summaryFD), "summary").Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordScan - count a file found by the walk, and the reason it was skipped
+// for unless it was selected
+func (s *runStats) recordScan(code reason, selected bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.scanned++
+	s.mu.Unlock()
+	if !selected {
+		s.recordSkip(code)
+	}
+}
+
+// recordSkip - count a file or output that was left alone for code
+func (s *runStats) recordSkip(code reason) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.skipped == nil {
+		s.skipped = make(map[reason]int)
+	}
+	s.skipped[code]++
+}
+
+// report - output the outcome of the finished run s: the files found, selected,
+// resized, copied unchanged, skipped and failed, with the reasons of the skips
+// and failures, and the time it took
+func (s *runSummary) report() {
+	skipped := 0
+	for _, n := range s.Skipped {
+		skipped += n
+	}
+	logs.info(logEntry{Action: "summary"}, "summary: %d scanned, %d selected, %d resized, %d copied unchanged, %d skipped, %d failed\n",
+		s.Scanned, s.Files, s.Resizes, s.Copies, skipped, s.Failed)
+	if len(s.Skipped) > 0 {
+		logs.info(logEntry{Action: "summary"}, "skipped: %s\n", countsByReason(s.Skipped))
+	}
+	if len(s.Reasons) > 0 {
+		logs.info(logEntry{Action: "summary"}, "failed: %s\n", countsByReason(s.Reasons))
+	}
+	wall := s.End.Sub(s.Start)
+	each := "-"
+	if n := s.Resizes + s.Copies; n > 0 {
+		each = (wall / time.Duration(n)).Round(time.Millisecond).String()
+	}
+	logs.info(logEntry{Action: "summary"}, "time: %v, %s per image; %s read, %s written\n",
+		wall.Round(time.Millisecond), each, formatBytes(s.BytesRead), formatBytes(s.BytesWritten))
+}
+
+// countsByReason - format counts as "3 SKIP_EXISTS, 1 SKIP_REGEX", most frequent first
+func countsByReason(counts map[reason]int) string {
+	codes := make([]reason, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%d %s", counts[code], code)
+	}
+	return strings.Join(parts, ", ")
+}
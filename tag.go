@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tagName - the extended attribute, or NTFS alternate data stream, that
+// --tag-outputs writes; the user. namespace is the one unprivileged users can write
+const tagName = "user." + pgmName + ".run"
+
+// runID - identifies this run in --trash directories and output tags
+var runID = fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), os.Getpid())
+
+// tagWarning - so that a destination without extended attributes is reported once
+var tagWarning sync.Once
+
+// tagOutput - record the run and the settings that dstname was written with on
+// the file itself, such as: run=20240115-220000-4312 version=1.3.0 size=600x600 ...
+func tagOutput(opts *options, dstname string) {
+	if !opts.tagOutputs {
+		return
+	}
+	value := fmt.Sprintf("run=%s version=%s %s", runID, pgmVersion, opts.params())
+	if err := setTag(dstname, tagName, []byte(value)); err != nil {
+		tagWarning.Do(func() {
+			logs.warn(logEntry{Action: "tag", Dest: dstname}.withErr(err), "Unable to tag outputs, the destination may not support it: %v\n", err)
+		})
+	}
+}
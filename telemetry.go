@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// telemetryBuckets - the upper bounds, in megapixels, of the source sizes that
+// --telemetry groups resize times by; the last bucket has no upper bound
+var telemetryBuckets = []struct {
+	name string
+	max  float64
+}{
+	{"0-1MP", 1}, {"1-4MP", 4}, {"4-12MP", 12}, {"12-24MP", 24}, {"24+MP", 0},
+}
+
+// telemetryBucket - the resizes of one source size bucket
+type telemetryBucket struct {
+	Resizes int   `json:"resizes"`
+	TotalMS int64 `json:"total_ms"`
+	MaxMS   int64 `json:"max_ms"`
+}
+
+// telemetryRecord - one line of a --telemetry file; it holds no names, paths or
+// host details, only what is needed to choose better defaults
+type telemetryRecord struct {
+	Time           string                      `json:"time"`
+	Version        string                      `json:"version"`
+	OS             string                      `json:"os"`
+	Arch           string                      `json:"arch"`
+	CPUs           int                         `json:"cpus"`
+	Mode           string                      `json:"mode"`
+	Workers        int                         `json:"workers"`
+	BlurRadius     int                         `json:"blur_radius"`
+	SobelThreshold int                         `json:"sobel_threshold"`
+	Copies         int                         `json:"copies"`
+	Buckets        map[string]*telemetryBucket `json:"buckets"`
+}
+
+// telemetry - anonymous performance counters that are only kept when --telemetry
+// is given; a nil telemetry records nothing
+type telemetry struct {
+	mu     sync.Mutex
+	file   string
+	record telemetryRecord
+}
+
+// newTelemetry - return a telemetry appending to file for a run in mode with workers
+func newTelemetry(file, mode string, workers, blurRadius, sobelThreshold int) *telemetry {
+	return &telemetry{file: file, record: telemetryRecord{
+		Version:        pgmVersion,
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		CPUs:           runtime.NumCPU(),
+		Mode:           mode,
+		Workers:        workers,
+		BlurRadius:     blurRadius,
+		SobelThreshold: sobelThreshold,
+		Buckets:        make(map[string]*telemetryBucket),
+	}}
+}
+
+// recordResize - count a source of pixels pixels that took d to resize or convert
+func (t *telemetry) recordResize(pixels int, d time.Duration) {
+	if t == nil {
+		return
+	}
+	mp := float64(pixels) / 1e6
+	name := telemetryBuckets[len(telemetryBuckets)-1].name
+	for _, b := range telemetryBuckets {
+		if b.max > 0 && mp < b.max {
+			name = b.name
+			break
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.record.Buckets[name]
+	if !ok {
+		b = &telemetryBucket{}
+		t.record.Buckets[name] = b
+	}
+	ms := d.Milliseconds()
+	b.Resizes++
+	b.TotalMS += ms
+	if ms > b.MaxMS {
+		b.MaxMS = ms
+	}
+}
+
+// recordCopy - count a source that was copied unchanged
+func (t *telemetry) recordCopy() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record.Copies++
+}
+
+// write - append the counters gathered since the last write to the file as one
+// JSON line, and start counting again
+func (t *telemetry) write(mode os.FileMode) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.record.Copies == 0 && len(t.record.Buckets) == 0 {
+		return nil
+	}
+	t.record.Time = clock().UTC().Format("2006-01-02")
+	line, err := json.Marshal(t.record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	t.record.Copies = 0
+	t.record.Buckets = make(map[string]*telemetryBucket)
+	return f.Close()
+}
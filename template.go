@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// nameVariables - the variables a --name-template can use, and what each is
+var nameVariables = map[string]string{
+	"basename": "name of the source without its extension",
+	"ext":      "extension of the output format, without the dot, such as jpg",
+	"format":   "output format, such as jpeg",
+	"width":    "width of the output",
+	"height":   "height of the output",
+	"date":     "modification date of the source, such as 20240131",
+	"hash":     "first 8 characters of the --hash of the source",
+}
+
+// parseNameTemplate - check that spec, such as {basename}_{width}x{height}.{ext},
+// only uses nameVariables and names a file under the destination
+func parseNameTemplate(spec string) error {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return errors.New("empty name template")
+	}
+	literal := spec
+	for rest := spec; ; {
+		i := strings.IndexAny(rest, "{}")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexAny(rest[i+1:], "{}") + i + 1
+		if rest[i] != '{' || j <= i || rest[j] != '}' {
+			return fmt.Errorf("unbalanced braces in name template: %s", spec)
+		}
+		if _, ok := nameVariables[rest[i+1:j]]; !ok {
+			return fmt.Errorf("unknown variable in name template: {%s}", rest[i+1:j])
+		}
+		literal = strings.Replace(literal, rest[i:j+1], "x", 1)
+		rest = rest[j+1:]
+	}
+	name := filepath.Clean(filepath.FromSlash(literal))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("name template must name a file under the destination: %s", spec)
+	}
+	return nil
+}
+
+// templateName - return the --name-template expanded for srcname written in
+// format; the size of the source is read and the source hashed only when the
+// template uses them
+func (opts *options) templateName(srcname, format string) string {
+	width, height := -1, -1
+	size := func() {
+		if width < 0 {
+			width, height = 0, 0
+			if cfg, _, err := imageConfig(srcname); err == nil {
+				width, height = opts.imageResizer(nil).Target(cfg.Width, cfg.Height)
+			}
+		}
+	}
+	value := func(variable string) string {
+		switch variable {
+		case "basename":
+			base := filepath.Base(srcname)
+			return strings.TrimSuffix(base, filepath.Ext(base))
+		case "ext":
+			if ext := resizer.Extension(format); len(ext) > 0 {
+				return strings.TrimPrefix(ext, ".")
+			}
+			return strings.TrimPrefix(filepath.Ext(srcname), ".")
+		case "format":
+			return format
+		case "width":
+			size()
+			return strconv.Itoa(width)
+		case "height":
+			size()
+			return strconv.Itoa(height)
+		case "date":
+			if info, err := os.Stat(srcname); err == nil {
+				return info.ModTime().Format("20060102")
+			}
+		case "hash":
+			if sum, err := hashFile(opts.hashAlgorithm, srcname); err == nil {
+				sum = sum[strings.Index(sum, ":")+1:]
+				return sum[:8]
+			}
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	rest := opts.nameTemplate
+	for {
+		i := strings.Index(rest, "{")
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+		j := strings.Index(rest, "}")
+		b.WriteString(rest[:i])
+		b.WriteString(value(rest[i+1 : j]))
+		rest = rest[j+1:]
+	}
+	return filepath.FromSlash(b.String())
+}
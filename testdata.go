@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/jftuga/photo_id_resizer/resizer"
+)
+
+// testdataSizes - the sizes of the images written by gen-testdata, from thumbnails
+// to camera originals, in portrait and landscape
+var testdataSizes = [][2]int{
+	{150, 200}, {300, 300}, {480, 640}, {600, 600}, {640, 480}, {1200, 1600}, {2400, 1800}, {3000, 4000},
+}
+
+// testdataDirs - the subdirectories gen-testdata spreads images over, to try
+// directory profiles, --only-under and --routes on
+var testdataDirs = []string{"", "Sales", "Sales/East", "HR"}
+
+// fillEllipse - paint the ellipse centered on cx, cy with radii rx and ry
+func fillEllipse(img *image.NRGBA, cx, cy, rx, ry int, c color.NRGBA) {
+	for y := cy - ry; y <= cy+ry; y++ {
+		for x := cx - rx; x <= cx+rx; x++ {
+			dx, dy := float64(x-cx)/float64(rx), float64(y-cy)/float64(ry)
+			if dx*dx+dy*dy <= 1 {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// drawFace - return a width by height portrait of a drawn face on a plain background
+func drawFace(width, height int, rng *rand.Rand) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	background := color.NRGBA{uint8(180 + rng.Intn(76)), uint8(180 + rng.Intn(76)), uint8(180 + rng.Intn(76)), 255}
+	draw.Draw(img, img.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+	skin := color.NRGBA{uint8(120 + rng.Intn(116)), uint8(80 + rng.Intn(100)), uint8(60 + rng.Intn(80)), 255}
+	hair := color.NRGBA{uint8(rng.Intn(90)), uint8(rng.Intn(60)), uint8(rng.Intn(40)), 255}
+	shirt := color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+	dark := color.NRGBA{30, 20, 20, 255}
+
+	unit := width
+	if height < unit {
+		unit = height
+	}
+	cx, cy := width/2, height*9/20
+	rx, ry := unit*4/20, unit*5/20
+	fillEllipse(img, cx, height, unit*8/20, unit*5/20, shirt)
+	fillEllipse(img, cx, cy-ry/5, rx*11/10, ry*11/10, hair)
+	fillEllipse(img, cx, cy, rx, ry, skin)
+	fillEllipse(img, cx-rx*2/5, cy-ry/6, rx/7+1, ry/12+1, dark)
+	fillEllipse(img, cx+rx*2/5, cy-ry/6, rx/7+1, ry/12+1, dark)
+	fillEllipse(img, cx, cy+ry/2, rx*2/5+1, ry/16+1, dark)
+	return img
+}
+
+// rotate - return img turned a quarter turn clockwise, or counterclockwise
+func rotate(img *image.NRGBA, clockwise bool) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if clockwise {
+				out.SetNRGBA(b.Dy()-1-y, x, img.NRGBAAt(x, y))
+			} else {
+				out.SetNRGBA(y, b.Dx()-1-x, img.NRGBAAt(x, y))
+			}
+		}
+	}
+	return out
+}
+
+// orientationSegment - return an APP1 Exif segment holding only an orientation tag
+func orientationSegment(orientation int) []byte {
+	return []byte{
+		0xff, 0xe1, 0x00, 0x22, // APP1 marker and segment length
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08, // big-endian TIFF header, IFD0 at 8
+		0x00, 0x01, // one entry
+		byte(exifTagOrientation >> 8), byte(exifTagOrientation & 0xff), 0x00, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, byte(orientation), 0x00, 0x00, // SHORT value, padded
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+}
+
+// encodeOriented - return img encoded as a JPEG whose EXIF orientation is given;
+// the pixels are stored turned so that viewers that apply the tag show it upright
+func encodeOriented(img *image.NRGBA, orientation int) ([]byte, error) {
+	switch orientation {
+	case 3:
+		img = rotate(rotate(img, true), true)
+	case 6:
+		img = rotate(img, false)
+	case 8:
+		img = rotate(img, true)
+	}
+	var buf bytes.Buffer
+	if err := resizer.Encode(&buf, img, "jpg", 0, 0); err != nil {
+		return nil, err
+	}
+	encoded := buf.Bytes()
+	return append(append(append([]byte{}, encoded[:2]...), orientationSegment(orientation)...), encoded[2:]...), nil
+}
+
+// runGenTestdata - the gen-testdata subcommand, write synthetic photos of drawn faces
+// in every size and format, with EXIF orientations and broken files among them,
+// so that a deployment can be tried out without real employee photos
+func runGenTestdata(args []string) int {
+	fs := flag.NewFlagSet("gen-testdata", flag.ExitOnError)
+	count := fs.Int("n", 40, "number of images to write, besides the broken files")
+	seed := fs.Int64("seed", 1, "seed of the random sizes, formats and colors, so that runs can be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s gen-testdata [-n N] [-seed S] DIR\n", pgmName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *count < 0 {
+		fs.Usage()
+		return 1
+	}
+	dir := fs.Arg(0)
+	rng := rand.New(rand.NewSource(*seed))
+
+	write := func(name string, data []byte) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatalf("Unable to create directory: %v\n", err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			log.Fatalf("Unable to write %s: %v\n", path, err)
+		}
+	}
+
+	formats := []string{"jpg", "jpg", "png", "gif"}
+	orientations := []int{1, 3, 6, 8}
+	var sample []byte
+	for i := 0; i < *count; i++ {
+		size := testdataSizes[rng.Intn(len(testdataSizes))]
+		format := formats[rng.Intn(len(formats))]
+		name := fmt.Sprintf("%s/%05d.%s", testdataDirs[rng.Intn(len(testdataDirs))], 10000+i, format)
+		img := drawFace(size[0], size[1], rng)
+
+		var data []byte
+		var err error
+		if format == "jpg" && rng.Intn(3) == 0 {
+			data, err = encodeOriented(img, orientations[rng.Intn(len(orientations))])
+		} else {
+			var buf bytes.Buffer
+			dpi := 0
+			if rng.Intn(2) == 0 {
+				dpi = 300
+			}
+			err = resizer.Encode(&buf, img, format, dpi, 0)
+			data = buf.Bytes()
+		}
+		if err != nil {
+			log.Fatalf("Unable to encode %s: %v\n", name, err)
+		}
+		write(name, data)
+		if format == "jpg" && sample == nil {
+			sample = data
+		}
+	}
+
+	// files that every deployment meets sooner or later
+	if sample == nil {
+		var buf bytes.Buffer
+		resizer.Encode(&buf, drawFace(300, 300, rng), "jpg", 0, 0)
+		sample = buf.Bytes()
+	}
+	write("broken/truncated.jpg", sample[:len(sample)/2])
+	write("broken/not-an-image.jpg", []byte("this is not a photo\n"))
+	write("broken/empty.png", nil)
+	write("broken/png-named-jpg.jpg", func() []byte {
+		var buf bytes.Buffer
+		resizer.Encode(&buf, drawFace(300, 300, rng), "png", 0, 0)
+		return buf.Bytes()
+	}())
+	write("broken/uploading.jpg.part", sample)
+	fmt.Printf("gen-testdata: %d images and 5 broken files written to %s\n", *count, dir)
+	return 0
+}
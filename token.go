@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// readToken - return the shared token in the first line of the file at path
+func readToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if len(token) == 0 {
+		return "", fmt.Errorf("%s: empty token", path)
+	}
+	return token, nil
+}
+
+// requireToken - return next, refusing requests without an "Authorization: Bearer"
+// header of token; every request is let through when token is empty
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	if len(token) == 0 {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// withToken - set the Authorization header of req to token, if there is one
+func withToken(req *http.Request, token string) {
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// loopbackAddr - return true if the listen address addr only accepts connections
+// from this machine
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
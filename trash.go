@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// trashDir - the directory in the destination that --trash moves displaced files to
+const trashDir = ".trash"
+
+// trash - keeps the destination files a run would overwrite in a directory of its
+// own under trashDir, for a recovery window; a nil trash keeps nothing
+type trash struct {
+	dest    string
+	dir     string // this run's directory
+	dirMode os.FileMode
+}
+
+// newTrash - return the trash of a run writing to dest, first removing the
+// directories of earlier runs that are older than days, unless days is 0
+func newTrash(dest string, days int, dirMode os.FileMode) *trash {
+	root := filepath.Join(dest, trashDir)
+	if days > 0 {
+		entries, _ := ioutil.ReadDir(root)
+		cutoff := clock().AddDate(0, 0, -days)
+		for _, entry := range entries {
+			if entry.IsDir() && entry.ModTime().Before(cutoff) {
+				if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+					logs.warn(logEntry{Action: "trash"}.withErr(err), "Unable to empty trash: %v\n", err)
+				}
+			}
+		}
+	}
+	return &trash{dest: dest, dir: filepath.Join(root, runID), dirMode: dirMode}
+}
+
+// keep - move the file at path, if there is one, into the trash before it is
+// overwritten, keeping its path relative to the destination
+func (t *trash) keep(path string) error {
+	if t == nil || !fileExists(path) {
+		return nil
+	}
+	rel, err := filepath.Rel(t.dest, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	target := filepath.Join(t.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), t.dirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+	// the directory's age is that of the run, whatever the age of what it holds
+	now := clock()
+	os.Chtimes(t.dir, now, now)
+	return nil
+}
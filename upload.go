@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// uploadSuffixes - names given to files while they are still being uploaded or
+// downloaded; they are renamed once complete, so they are never processed
+var uploadSuffixes = []string{".part", ".partial", ".tmp", ".filepart", ".crdownload", ".download", "~"}
+
+// isInProgress - return true if name is that of a file that is still being written
+func isInProgress(name string) bool {
+	lower := strings.ToLower(name)
+	for _, suffix := range uploadSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitUntilStable - wait until neither the size nor the modification time of path
+// has changed for settle, so that a slow upload is not processed half written;
+// return false if path disappears or done is closed first
+func waitUntilStable(path string, settle time.Duration, done <-chan struct{}) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for {
+		age := time.Since(info.ModTime())
+		if age >= settle {
+			return true
+		}
+		// a modification time in the future, from a clock that is ahead, waits settle
+		wait := settle - age
+		if wait > settle {
+			wait = settle
+		}
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return false
+		}
+
+		latest, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		if latest.Size() == info.Size() && latest.ModTime().Equal(info.ModTime()) {
+			return true
+		}
+		info = latest
+	}
+}
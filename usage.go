@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transferred - the bytes downloaded from and uploaded to one kind of bucket
+type transferred struct {
+	read, written int64
+}
+
+// recordIO - count read bytes read from the source and written bytes written to
+// the destination; sources and outputs in a bucket are counted here as the local
+// copies that are read and written, and by recordTransfer as well
+func (s *runStats) recordIO(read, written int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesRead += read
+	s.bytesWritten += written
+}
+
+// recordTransfer - count read bytes downloaded from and written bytes uploaded
+// to a bucket of the remote service named service, such as s3
+func (s *runStats) recordTransfer(service string, read, written int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remote == nil {
+		s.remote = make(map[string]*transferred)
+	}
+	if s.remote[service] == nil {
+		s.remote[service] = &transferred{}
+	}
+	s.remote[service].read += read
+	s.remote[service].written += written
+}
+
+// recordCall - count a request made to the remote service named service, such
+// as the --notify webhook, the coordinator of the work subcommand or a bucket
+func (s *runStats) recordCall(service string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[service]++
+}
+
+// fileSize - return the size of the file at path, or 0 if it can not be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// usageLines - describe the resources used so far by the process, for capacity
+// planning; s.mu must be held
+func (s *runStats) usageLines() []string {
+	lines := []string{fmt.Sprintf("io: %s read, %s written", formatBytes(s.bytesRead), formatBytes(s.bytesWritten))}
+	if len(s.remote) > 0 {
+		services := make([]string, 0, len(s.remote))
+		for service := range s.remote {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		remote := make([]string, len(services))
+		for i, service := range services {
+			remote[i] = fmt.Sprintf("%s %s read, %s written", service, formatBytes(s.remote[service].read), formatBytes(s.remote[service].written))
+		}
+		lines = append(lines, "remote io: "+strings.Join(remote, "; "))
+	}
+	if peak, user, system, ok := processUsage(); ok {
+		lines = append(lines, fmt.Sprintf("usage: %s peak memory, %v user and %v system cpu time",
+			formatBytes(peak), user.Round(time.Millisecond), system.Round(time.Millisecond)))
+	}
+	if len(s.calls) > 0 {
+		services := make([]string, 0, len(s.calls))
+		for service := range s.calls {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		calls := make([]string, len(services))
+		for i, service := range services {
+			calls[i] = fmt.Sprintf("%d %s", s.calls[service], service)
+		}
+		lines = append(lines, "api calls: "+strings.Join(calls, ", "))
+	}
+	return lines
+}
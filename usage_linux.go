@@ -0,0 +1,16 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// processUsage - return the peak resident memory and the cpu time of the process
+func processUsage() (peak int64, user, system time.Duration, ok bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, 0, false
+	}
+	// Linux reports the peak in kilobytes
+	return int64(ru.Maxrss) * 1024, time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano()), true
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/esimov/caire"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchModes - the ways --watch can find new and changed files: notify waits for
+// change notifications, poll walks the source directory, and auto notifies where
+// the file system supports it and polls otherwise
+var watchModes = []string{"auto", "notify", "poll"}
+
+// validWatchMode - return true if mode is one of watchModes
+func validWatchMode(mode string) bool {
+	for _, m := range watchModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// fileState - what the poller remembers of a file to tell whether it changed
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// poller - finds new and changed files by walking the source directory and
+// comparing the size and modification time of each file to the previous walk,
+// which works on every file system, including NFS and SMB mounts where change
+// notifications are not delivered
+type poller struct {
+	opts   *options
+	filter *fileFilter
+	files  map[string]fileState
+}
+
+// newPoller - return a poller for the source directory of opts
+func newPoller(opts *options) (*poller, error) {
+	filter, err := newFileFilter(opts.match, opts.exclude, opts.maxAge, opts.shard)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{opts: opts, filter: filter, files: make(map[string]fileState)}, nil
+}
+
+// scan - walk the source directory and return the files that are new or changed
+// since the previous scan, in name order; files that are gone are forgotten.  The
+// walk reads directories only, and decides quietly, so that polling often is cheap.
+func (w *poller) scan() ([]string, error) {
+	seen := make(map[string]fileState)
+	var changed []string
+	err := filepath.Walk(w.opts.source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == profileName {
+			return nil
+		}
+		if rel, err := filepath.Rel(w.opts.source, path); err == nil && (!w.opts.onlyUnder.selects(rel, info) || rel == w.opts.nestedDest) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if ok, _, _ := w.filter.check(path, info); !ok {
+			return nil
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		seen[path] = state
+		if previous, ok := w.files[path]; !ok || previous != state {
+			changed = append(changed, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.files = seen
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// watchDirs - add dir and every directory under it, except a destination nested
+// in the source, to watcher, as change notifications are not recursive
+func watchDirs(watcher *fsnotify.Watcher, opts *options, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if rel, err := filepath.Rel(opts.source, path); err == nil && rel == opts.nestedDest {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// processChanges - process the files that w finds new or changed
+func processChanges(w *poller, opts *options, p *caire.Processor) {
+	changed, err := w.scan()
+	if err != nil {
+		logs.error(logEntry{Action: "walk", File: opts.source}.withErr(err), "Error walking %s: %v\n", opts.source, err)
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+	opts.roster.prioritize(changed)
+	logs.info(logEntry{Action: "watch"}, "watch: %d new or changed files\n", len(changed))
+	if err := processPaths(opts, p, changed); err != nil {
+		logs.error(logEntry{Action: "walk"}.withErr(err), "%v\n", err)
+	}
+}
+
+// runWatch - the --watch mode, process the source directory once and then keep
+// processing the files that are added to it or changed.  With notifications, the
+// source directory is walked once no change has been notified for debounce, so
+// that files still being written are not processed half done; when polling, it
+// is walked every interval.
+func runWatch(opts *options, p *caire.Processor, mode string, interval, debounce time.Duration) int {
+	w, err := newPoller(opts)
+	if err != nil {
+		logs.error(logEntry{Action: "watch", File: opts.source}.withErr(err), "%v\n", err)
+		return 1
+	}
+	var watcher *fsnotify.Watcher
+	if mode != "poll" {
+		if watcher, err = fsnotify.NewWatcher(); err == nil {
+			if err = watchDirs(watcher, opts, opts.source); err != nil {
+				watcher.Close()
+			}
+		}
+		if err != nil && mode == "notify" {
+			logs.error(logEntry{Action: "watch", File: opts.source}.withErr(err), "Unable to watch %s for changes: %v\n", opts.source, err)
+			return 1
+		}
+		if err != nil {
+			logs.warn(logEntry{Action: "watch", File: opts.source}.withErr(err), "Change notifications are not available, polling instead: %v\n", err)
+			watcher = nil
+		}
+	}
+
+	if watcher == nil {
+		logs.info(logEntry{Action: "watch", File: opts.source}, "watching %s for changes every %s (poll)\n", opts.source, interval)
+		for {
+			processChanges(w, opts, p)
+			time.Sleep(interval)
+		}
+	}
+	defer watcher.Close()
+	logs.info(logEntry{Action: "watch", File: opts.source}, "watching %s for changes, processing them after %s without changes (notify)\n", opts.source, debounce)
+	processChanges(w, opts, p)
+	var quiet <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return 1
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchDirs(watcher, opts, event.Name); err != nil {
+						logs.warn(logEntry{Action: "watch", File: event.Name}.withErr(err), "Unable to watch %s for changes: %v\n", event.Name, err)
+					}
+				}
+			}
+			quiet = time.After(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return 1
+			}
+			// notifications may have been lost, so walk the source directory to be sure
+			logs.warn(logEntry{Action: "watch", File: opts.source}.withErr(err), "Change notifications failed: %v\n", err)
+			quiet = time.After(debounce)
+		case <-quiet:
+			quiet = nil
+			processChanges(w, opts, p)
+		}
+	}
+}
+
+// processPaths - process the given source files like a batch run
+func processPaths(opts *options, p *caire.Processor, files []string) error {
+	done := make(chan struct{})
+	defer close(done)
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		for _, path := range files {
+			select {
+			case paths <- path:
+			case <-done:
+				return
+			}
+		}
+		errc <- nil
+	}()
+	return processAll(done, paths, errc, opts, p)
+}
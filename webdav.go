@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// webdavTimeout - how long one request to a WebDAV server may take
+const webdavTimeout = 5 * time.Minute
+
+// webdavPropfind - the body of the PROPFIND requests that list a collection
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// webdavClient - the HTTP client of a WebDAV server, such as Nextcloud's at
+// /remote.php/dav/files/USER, authenticated with basic authentication when a
+// user is given
+type webdavClient struct {
+	base     string // scheme and host, such as https://cloud.example.com
+	user     string
+	password string
+	client   *http.Client
+	stats    *runStats // that every request is counted in, nil for none
+}
+
+// webdavMultistatus - the part of a PROPFIND response that listing needs
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				Collection    *struct{} `xml:"resourcetype>collection"`
+				ContentLength int64     `xml:"getcontentlength"`
+				LastModified  string    `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// newWebDAVClient - return a client of host, given as [user@]host[:port], over
+// HTTPS for webdavs:// and HTTP for webdav://; the password of the user is the
+// first line of passwordFile, such as a Nextcloud app password
+func newWebDAVClient(scheme, host, passwordFile string) (*webdavClient, error) {
+	c := &webdavClient{base: "https://", client: &http.Client{Timeout: webdavTimeout}}
+	if scheme == "webdav://" {
+		c.base = "http://"
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		c.user, host = host[:i], host[i+1:]
+	}
+	c.base += host
+	if len(passwordFile) > 0 {
+		password, err := readPassphrase(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		c.password = string(password)
+	}
+	return c, nil
+}
+
+// request - send a request with method for the resource key, a path relative
+// to the root of the server, and return the response if its status is one of ok
+func (c *webdavClient) request(ctx context.Context, method, key string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+(&url.URL{Path: "/" + key}).EscapedPath(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if sr, isSection := body.(*io.SectionReader); isSection {
+		// without a length the file would be sent chunked, which some servers refuse
+		req.ContentLength = sr.Size()
+	}
+	if len(c.user) > 0 {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	c.stats.recordCall("webdav")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s %s: %s", method, key, resp.Status)
+}
+
+// mkcol - create the collection dir and, when they are missing, its parents
+func (c *webdavClient) mkcol(ctx context.Context, dir string) error {
+	resp, err := c.request(ctx, "MKCOL", dir+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		return nil
+	}
+	if parent := path.Dir(dir); parent != "." && parent != dir {
+		if err := c.mkcol(ctx, parent); err != nil {
+			return err
+		}
+	}
+	resp, err = c.request(ctx, "MKCOL", dir+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listWebDAV - call found with every file under the prefix of a WebDAV server,
+// listing one collection at a time, as servers such as Nextcloud refuse
+// PROPFIND with a depth of infinity
+func (b *bucket) listWebDAV(ctx context.Context, found func(key string, info remoteObject) error) error {
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+		resp, err := b.dav.request(ctx, "PROPFIND", dir+"/", strings.NewReader(webdavPropfind), header, http.StatusMultiStatus)
+		if err != nil {
+			return fmt.Errorf("unable to list %s%s: %v", b.scheme+b.name+"/", dir, err)
+		}
+		var ms webdavMultistatus
+		err = xml.NewDecoder(resp.Body).Decode(&ms)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to list %s%s: %v", b.scheme+b.name+"/", dir, err)
+		}
+		for _, r := range ms.Responses {
+			// hrefs are escaped paths, or sometimes whole URLs
+			u, err := url.Parse(r.Href)
+			if err != nil {
+				continue
+			}
+			key := strings.Trim(u.Path, "/")
+			if key == dir || !strings.HasPrefix(key, strings.TrimPrefix(dir+"/", "/")) {
+				continue
+			}
+			for _, ps := range r.Propstat {
+				if !strings.Contains(ps.Status, " 200 ") {
+					continue
+				}
+				if ps.Prop.Collection != nil {
+					err = walk(key)
+				} else {
+					modTime, _ := http.ParseTime(ps.Prop.LastModified)
+					err = found(key, remoteObject{size: ps.Prop.ContentLength, modTime: modTime})
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(strings.TrimSuffix(b.prefix, "/"))
+}
+
+// downloadWebDAV - write the file key of a WebDAV server to w
+func (b *bucket) downloadWebDAV(ctx context.Context, key string, w io.Writer) error {
+	resp, err := b.dav.request(ctx, http.MethodGet, key, nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// uploadWebDAV - write the file f to the file key of a WebDAV server; when the
+// server answers that its collection does not exist, the collection is created
+// and the file written again
+func (b *bucket) uploadWebDAV(ctx context.Context, key string, f *os.File, contentType string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var header http.Header
+	if len(contentType) > 0 {
+		header = http.Header{"Content-Type": {contentType}}
+	}
+	// sections of f are sent, as the client closes a body that can be closed
+	resp, err := b.dav.request(ctx, http.MethodPut, key, io.NewSectionReader(f, 0, info.Size()), header, http.StatusCreated, http.StatusNoContent, http.StatusOK, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		return nil
+	}
+	if err := b.dav.mkcol(ctx, path.Dir(key)); err != nil {
+		return err
+	}
+	resp, err = b.dav.request(ctx, http.MethodPut, key, io.NewSectionReader(f, 0, info.Size()), header, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
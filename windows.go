@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// windowsNameModes - what --windows-names does with source names that can not be
+// used as file names on Windows, such as CON.jpg or names ending in a dot
+//
+//	rename: write them under a name that Windows accepts, such as CON_.jpg
+//	flag:   do not process them, reporting them with ERR_WINDOWS_NAME
+//	ignore: write them as they are
+var windowsNameModes = []string{"rename", "flag", "ignore"}
+
+// validWindowsNames - return true if mode is one of windowsNameModes
+func validWindowsNames(mode string) bool {
+	for _, m := range windowsNameModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultWindowsNames - the default for --windows-names, which only renames when
+// running on Windows
+func defaultWindowsNames() string {
+	if runtime.GOOS == "windows" {
+		return "rename"
+	}
+	return "ignore"
+}
+
+// windowsReserved - device names that Windows reserves, with or without an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsInvalid - characters that Windows does not allow in file names
+const windowsInvalid = `<>:"/\|?*`
+
+// windowsStem - return the part of base that Windows compares to device names
+func windowsStem(base string) string {
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	return strings.ToUpper(strings.TrimRight(base, " "))
+}
+
+// windowsNameProblem - return why base can not be used as a file name on Windows,
+// or an empty string if it can
+func windowsNameProblem(base string) string {
+	if windowsReserved[windowsStem(base)] {
+		return fmt.Sprintf("%s is a reserved device name", windowsStem(base))
+	}
+	if strings.HasSuffix(base, ".") || strings.HasSuffix(base, " ") {
+		return "name ends in a dot or space"
+	}
+	for _, r := range base {
+		if r < 32 || strings.ContainsRune(windowsInvalid, r) {
+			return fmt.Sprintf("name contains %q", r)
+		}
+	}
+	return ""
+}
+
+// windowsSafeName - return base changed as little as possible so that it can be
+// used as a file name on Windows
+func windowsSafeName(base string) string {
+	base = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(windowsInvalid, r) {
+			return '_'
+		}
+		return r
+	}, base)
+	base = strings.TrimRight(base, ". ")
+	if windowsReserved[windowsStem(base)] {
+		stem := strings.TrimRight(strings.SplitN(base, ".", 2)[0], " ")
+		base = stem + "_" + base[len(stem):]
+	}
+	if len(base) == 0 {
+		base = "_"
+	}
+	return base
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// workflow directories - the states a photo moves through under --workflow:
+// sources are uploaded to incoming, batch runs write to processed, and a reviewer
+// approves or rejects each output before approved photos are published
+const (
+	stateIncoming  = "incoming"
+	stateProcessed = "processed"
+	stateApproved  = "approved"
+	statePublished = "published"
+	stateRejected  = "rejected"
+)
+
+// transition - a review subcommand that moves photos from one state to another
+type transition struct {
+	event string
+	from  []string // states a photo may be in, tried in order
+	to    string
+}
+
+// transitions - the review subcommands, keyed by name
+var transitions = map[string]transition{
+	"approve": {event: "approved", from: []string{stateProcessed}, to: stateApproved},
+	"reject":  {event: "rejected", from: []string{stateProcessed, stateApproved}, to: stateRejected},
+	"publish": {event: "published", from: []string{stateApproved}, to: statePublished},
+}
+
+// runTransition - return the review subcommand called name, which moves the
+// photos named in its arguments, relative to their state directory, and records
+// each move in the ledger
+func runTransition(name string) func(args []string) int {
+	t := transitions[name]
+	return func(args []string) int {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		workflow := fs.String("workflow", "", "directory holding the incoming, processed, approved, published and rejected directories")
+		ledgerPath := fs.String("ledger", "", "JSON lines file to record each move in")
+		note := fs.String("note", "", "reason for the decision, recorded in the ledger")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: %s %s --workflow DIR [--ledger FILE] [--note TEXT] photo...\n", pgmName, name)
+			fs.PrintDefaults()
+		}
+		fs.Parse(args)
+		if len(*workflow) == 0 || fs.NArg() == 0 {
+			fs.Usage()
+			return 1
+		}
+
+		var l *ledger
+		if len(*ledgerPath) > 0 {
+			var err error
+			if l, err = openLedger(*ledgerPath, 0644); err != nil {
+				log.Fatalf("Unable to open ledger: %v\n", err)
+			}
+			defer l.Close()
+		}
+
+		status := 0
+		for _, photo := range fs.Args() {
+			if err := t.move(*workflow, photo, *note, l); err != nil {
+				log.Printf("Unable to %s %s: %v\n", name, photo, err)
+				status = 1
+				continue
+			}
+			fmt.Printf("%s: %s\n", t.event, photo)
+		}
+		return status
+	}
+}
+
+// move - move photo from the first of t.from that holds it to t.to
+func (t transition) move(workflow, photo, note string, l *ledger) error {
+	for _, from := range t.from {
+		src := filepath.Join(workflow, from, photo)
+		if !fileExists(src) {
+			continue
+		}
+		dst := filepath.Join(workflow, t.to, photo)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return withReason(reasonMkdir, err)
+		}
+		if err := moveFile(src, dst, 0644); err != nil {
+			return withReason(reasonWrite, err)
+		}
+		return l.record(ledgerEntry{Event: t.event, Source: src, Path: dst, Note: note})
+	}
+	return fmt.Errorf("not found in %v", t.from)
+}
+
+// runReviews - the reviews subcommand, output how often photos were rejected for
+// each --note in the ledger, most common first, so that the causes of rejections
+// can be found, and with -o write every review decision and its note as CSV
+func runReviews(args []string) int {
+	fs := flag.NewFlagSet("reviews", flag.ExitOnError)
+	ledgerPath := fs.String("ledger", "", "JSON lines file the review subcommands recorded their moves in")
+	output := fs.String("o", "", "CSV file to write the time, decision, photo and note of each review to")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s reviews --ledger FILE [-o FILE]\n", pgmName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(*ledgerPath) == 0 || fs.NArg() > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	entries, err := readLedger(*ledgerPath)
+	if err != nil {
+		log.Printf("Unable to read ledger: %v\n", err)
+		return 1
+	}
+	var reviews []ledgerEntry
+	notes := make(map[string]int)
+	rejected := 0
+	for _, e := range entries {
+		switch e.Event {
+		case "rejected":
+			notes[strings.ToLower(strings.TrimSpace(e.Note))]++
+			rejected++
+		case "approved", "published":
+		default:
+			continue
+		}
+		reviews = append(reviews, e)
+	}
+
+	causes := make([]string, 0, len(notes))
+	for note := range notes {
+		causes = append(causes, note)
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		if notes[causes[i]] != notes[causes[j]] {
+			return notes[causes[i]] > notes[causes[j]]
+		}
+		return causes[i] < causes[j]
+	})
+	fmt.Printf("reviews: %d decisions, %d rejections\n", len(reviews), rejected)
+	for _, note := range causes {
+		if len(note) == 0 {
+			fmt.Printf("%6d  (no note)\n", notes[note])
+			continue
+		}
+		fmt.Printf("%6d  %s\n", notes[note], note)
+	}
+	if len(*output) == 0 {
+		return 0
+	}
+
+	f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Printf("Unable to write %s: %v\n", *output, err)
+		return 1
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "decision", "photo", "note"})
+	for _, e := range reviews {
+		w.Write([]string{e.Time.Format(time.RFC3339), e.Event, e.Path, e.Note})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		log.Printf("Unable to write %s: %v\n", *output, err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("Unable to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
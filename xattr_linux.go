@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// setTag - set the extended attribute name of the file at path to value
+func setTag(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setTag - extended attributes are only written on Linux and Windows
+func setTag(path, name string, value []byte) error {
+	return fmt.Errorf("tags are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,9 @@
+package main
+
+import "io/ioutil"
+
+// setTag - write value to the NTFS alternate data stream name of the file at path,
+// which Get-Content -Stream reads
+func setTag(path, name string, value []byte) error {
+	return ioutil.WriteFile(path+":"+name, value, 0644)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// parseFlatYAML - parse the subset of YAML used by the program's own files: one
+// "key: value" pair per line, with blank lines and # comments ignored and
+// optional quotes around values; nesting and lists are not supported
+func parseFlatYAML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is given twice", n, key)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}